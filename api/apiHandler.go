@@ -42,6 +42,9 @@ func (a *APIHandler) postHandler(c *gin.Context) {
 		allowedActions := map[string]bool{
 			"login":       true,
 			"linkConvert": true,
+			// 本地覆盖配置只存在于从节点
+			"saveOverlay":   true,
+			"deleteOverlay": true,
 		}
 		if !allowedActions[action] {
 			jsonMsg(c, "failed", common.NewError("readonly mode: write operations are not allowed"))
@@ -75,6 +78,16 @@ func (a *APIHandler) postHandler(c *gin.Context) {
 		a.ApiService.GenerateNodeToken(c)
 	case "deleteNodeToken":
 		a.ApiService.DeleteNodeToken(c)
+	case "revokeNodeToken":
+		a.ApiService.RevokeNodeToken(c)
+	case "rotateNodeSecret":
+		a.ApiService.RotateNodeSecret(c)
+	case "revokeNodeSecret":
+		a.ApiService.RevokeNodeSecret(c)
+	case "nodeCommand":
+		a.ApiService.QueueNodeCommand(c, loginUser)
+	case "drainNode":
+		a.ApiService.DrainNode(c)
 	// API Key 管理
 	case "createApiKey":
 		a.ApiService.CreateApiKey(c)
@@ -85,6 +98,11 @@ func (a *APIHandler) postHandler(c *gin.Context) {
 	// Webhook 配置
 	case "saveWebhookConfig":
 		a.ApiService.SaveWebhookConfig(c)
+	// 本地覆盖配置 (仅 Worker 模式)
+	case "saveOverlay":
+		a.ApiService.SaveOverlay(c)
+	case "deleteOverlay":
+		a.ApiService.DeleteOverlay(c)
 	default:
 		jsonMsg(c, "failed", common.NewError("unknown action: ", action))
 	}
@@ -124,11 +142,25 @@ func (a *APIHandler) getHandler(c *gin.Context) {
 		a.ApiService.GetDb(c)
 	case "tokens":
 		a.ApiService.GetTokens(c)
+	case "subAccess":
+		a.ApiService.GetSubAccess(c)
+	case "externalSubs":
+		a.ApiService.GetExternalSubs(c)
 	// 节点管理 (仅 Master 模式)
 	case "nodes":
 		a.ApiService.GetNodes(c)
 	case "nodeTokens":
 		a.ApiService.GetNodeTokens(c)
+	case "nodeTokenNodes":
+		a.ApiService.GetNodeTokenNodes(c)
+	case "nodeStats":
+		a.ApiService.GetNodeStats(c)
+	case "nodeProbes":
+		a.ApiService.GetNodeProbes(c)
+	case "nodeCommands":
+		a.ApiService.GetNodeCommands(c)
+	case "nodeGroups":
+		a.ApiService.GetNodeGroups(c)
 	// 节点模式信息
 	case "nodeMode":
 		a.ApiService.GetNodeMode(c)
@@ -138,6 +170,9 @@ func (a *APIHandler) getHandler(c *gin.Context) {
 	// Webhook 配置
 	case "webhookConfig":
 		a.ApiService.GetWebhookConfig(c)
+	// 本地覆盖配置 (仅 Worker 模式)
+	case "overlays":
+		a.ApiService.GetOverlays(c)
 	default:
 		jsonMsg(c, "failed", common.NewError("unknown action: ", action))
 	}
@@ -30,6 +30,8 @@ type ApiService struct {
 	service.StatsService
 	service.ServerService
 	service.NodeService
+	service.SubAccessService
+	service.ExternalSubService
 }
 
 func (a *ApiService) LoadData(c *gin.Context) {
@@ -364,6 +366,19 @@ func (a *ApiService) GetTokens(c *gin.Context) {
 	jsonObj(c, tokens, err)
 }
 
+// GetSubAccess 获取订阅获取记录
+func (a *ApiService) GetSubAccess(c *gin.Context) {
+	clientId, _ := strconv.ParseUint(c.Query("clientId"), 10, 32)
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	records, err := a.SubAccessService.GetSubAccess(uint(clientId), limit)
+	jsonObj(c, records, err)
+}
+
+// GetExternalSubs 获取外部订阅缓存的健康状态
+func (a *ApiService) GetExternalSubs(c *gin.Context) {
+	jsonObj(c, a.ExternalSubService.GetExternalSubStatus(), nil)
+}
+
 func (a *ApiService) AddToken(c *gin.Context) {
 	loginUser := GetLoginUser(c)
 	expiry := c.Request.FormValue("expiry")
@@ -424,10 +439,61 @@ func (a *ApiService) GenerateNodeToken(c *gin.Context) {
 		}
 	}
 
-	token, err := a.NodeService.GenerateToken(name, expiresAt)
+	// 默认单次使用，0 表示不限次数
+	options := service.NodeTokenOptions{
+		MaxUses:      1,
+		NodeIdPrefix: c.Request.FormValue("nodeIdPrefix"),
+		Group:        c.Request.FormValue("group"),
+		Country:      c.Request.FormValue("country"),
+		Flag:         c.Request.FormValue("flag"),
+	}
+	if maxUsesStr := c.Request.FormValue("maxUses"); maxUsesStr != "" {
+		var err error
+		options.MaxUses, err = strconv.Atoi(maxUsesStr)
+		if err != nil {
+			jsonMsg(c, "", err)
+			return
+		}
+	}
+
+	token, err := a.NodeService.GenerateToken(name, expiresAt, options)
 	jsonObj(c, token, err)
 }
 
+// RevokeNodeToken 吊销节点邀请码，可同时禁用用它注册的节点
+func (a *ApiService) RevokeNodeToken(c *gin.Context) {
+	if !config.IsMaster() {
+		jsonMsg(c, "", common.NewError("only master node can revoke tokens"))
+		return
+	}
+
+	id, err := strconv.ParseUint(c.Request.FormValue("id"), 10, 32)
+	if err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	disableNodes := c.Request.FormValue("disableNodes") == "true"
+
+	err = a.NodeService.RevokeToken(uint(id), disableNodes)
+	jsonMsg(c, "", err)
+}
+
+// GetNodeTokenNodes 获取使用邀请码注册的节点
+func (a *ApiService) GetNodeTokenNodes(c *gin.Context) {
+	if !config.IsMaster() {
+		jsonMsg(c, "", common.NewError("only master node can list node tokens"))
+		return
+	}
+
+	id, err := strconv.ParseUint(c.Query("id"), 10, 32)
+	if err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	nodes, err := a.NodeService.GetTokenNodes(uint(id))
+	jsonObj(c, nodes, err)
+}
+
 // DeleteNodeToken 删除节点邀请码
 func (a *ApiService) DeleteNodeToken(c *gin.Context) {
 	if !config.IsMaster() {
@@ -446,6 +512,66 @@ func (a *ApiService) DeleteNodeToken(c *gin.Context) {
 	jsonMsg(c, "", err)
 }
 
+// RotateNodeSecret 轮换节点密钥，新密钥通过心跳下发，不需要重新注册
+func (a *ApiService) RotateNodeSecret(c *gin.Context) {
+	if !config.IsMaster() {
+		jsonMsg(c, "", common.NewError("only master node can rotate node secrets"))
+		return
+	}
+
+	idStr := c.Request.FormValue("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+
+	err = a.NodeService.RotateNodeSecret(uint(id))
+	jsonMsg(c, "", err)
+}
+
+// RevokeNodeSecret 吊销节点密钥 (密钥泄露时使用)，返回从节点重新认证用的新 token
+func (a *ApiService) RevokeNodeSecret(c *gin.Context) {
+	if !config.IsMaster() {
+		jsonMsg(c, "", common.NewError("only master node can revoke node secrets"))
+		return
+	}
+
+	idStr := c.Request.FormValue("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+
+	token, err := a.NodeService.RevokeNodeSecret(uint(id))
+	if err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	jsonObj(c, token, nil)
+}
+
+// DrainNode 开启或结束节点维护 (排空) 模式
+// 参数: id、drain (true / false)、grace (秒，宽限期内关闭空闲连接，到期后关闭所有连接，0 表示不主动关闭)
+func (a *ApiService) DrainNode(c *gin.Context) {
+	if !config.IsMaster() {
+		jsonMsg(c, "", common.NewError("only master node can drain nodes"))
+		return
+	}
+
+	id, err := strconv.ParseUint(c.Request.FormValue("id"), 10, 32)
+	if err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	drain := c.Request.FormValue("drain") == "true"
+	grace, _ := strconv.ParseInt(c.Request.FormValue("grace"), 10, 64)
+
+	err = a.NodeService.SetNodeDrain(uint(id), drain, grace)
+	jsonMsg(c, "", err)
+}
+
 // GetNodeMode 获取当前节点模式信息
 func (a *ApiService) GetNodeMode(c *gin.Context) {
 	data := map[string]interface{}{
@@ -456,9 +582,87 @@ func (a *ApiService) GetNodeMode(c *gin.Context) {
 		"isMaster":   config.IsMaster(),
 		"isWorker":   config.IsWorker(),
 	}
+	// 主节点的配置签名公钥，注册早于配置签名的从节点通过 SUI_MASTER_SIGN_KEY 固定
+	if config.IsMaster() {
+		if signKey, err := a.NodeService.NodeSignPublicKey(); err == nil {
+			data["signKey"] = signKey
+		}
+	}
 	jsonObj(c, data, nil)
 }
 
+// GetNodeStats 查询节点统计时间序列
+// 参数: nodeId (为空时返回所有节点)、metric、from / to (unix 秒)、bucket (秒)
+func (a *ApiService) GetNodeStats(c *gin.Context) {
+	if !config.IsMaster() {
+		jsonMsg(c, "", common.NewError("only master node can query node stats"))
+		return
+	}
+
+	now := time.Now().Unix()
+	from, err := strconv.ParseInt(c.Query("from"), 10, 64)
+	if err != nil {
+		from = now - 86400
+	}
+	to, err := strconv.ParseInt(c.Query("to"), 10, 64)
+	if err != nil {
+		to = now
+	}
+	bucket, err := strconv.ParseInt(c.Query("bucket"), 10, 64)
+	if err != nil {
+		bucket = 300
+	}
+	data, err := a.NodeService.GetNodeStatsRange(c.Query("nodeId"), c.Query("metric"), from, to, bucket)
+	jsonObj(c, data, err)
+}
+
+// GetNodeProbes 获取节点的延迟和丢包探测历史
+// 参数: nodeId、since (unix 秒，默认最近 24 小时)
+func (a *ApiService) GetNodeProbes(c *gin.Context) {
+	if !config.IsMaster() {
+		jsonMsg(c, "", common.NewError("only master node can query node probes"))
+		return
+	}
+	since, err := strconv.ParseInt(c.Query("since"), 10, 64)
+	if err != nil {
+		since = time.Now().Unix() - 86400
+	}
+	probes, err := a.NodeService.GetNodeProbes(c.Query("nodeId"), since)
+	jsonObj(c, probes, err)
+}
+
+// QueueNodeCommand 向从节点下发远程命令
+// 参数: nodeId、command (restartCore / restartApp / resync / logs / keypair)、args (JSON，可选)
+func (a *ApiService) QueueNodeCommand(c *gin.Context, loginUser string) {
+	if !config.IsMaster() {
+		jsonMsg(c, "", common.NewError("only master node can send node commands"))
+		return
+	}
+	nodeId := c.Request.FormValue("nodeId")
+	command := c.Request.FormValue("command")
+	args := json.RawMessage(c.Request.FormValue("args"))
+	cmd, err := a.NodeService.QueueNodeCommand(nodeId, command, args, loginUser)
+	jsonObj(c, cmd, err)
+}
+
+// GetNodeCommands 获取远程命令及执行结果
+// 参数: nodeId (为空时返回所有节点)、limit
+func (a *ApiService) GetNodeCommands(c *gin.Context) {
+	if !config.IsMaster() {
+		jsonMsg(c, "", common.NewError("only master node can query node commands"))
+		return
+	}
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	commands, err := a.NodeService.GetNodeCommands(c.Query("nodeId"), limit)
+	jsonObj(c, commands, err)
+}
+
+// GetNodeGroups 获取节点分组 (通过 save 接口的 nodeGroups 对象新增、编辑和删除)
+func (a *ApiService) GetNodeGroups(c *gin.Context) {
+	groups, err := a.NodeService.GetNodeGroups()
+	jsonObj(c, groups, err)
+}
+
 // ========== API Key 管理 ==========
 
 // GetApiKeys 获取 API Key 列表
@@ -589,3 +793,66 @@ func (a *ApiService) SaveWebhookConfig(c *gin.Context) {
 
 	jsonMsg(c, "", err)
 }
+
+// ========== 本地覆盖配置 (从节点) ==========
+
+// GetOverlays 获取从节点本地覆盖配置及其与同步配置的冲突
+func (a *ApiService) GetOverlays(c *gin.Context) {
+	if !config.IsWorker() {
+		jsonMsg(c, "", common.NewError("only worker node has local overlays"))
+		return
+	}
+	overlays, err := a.ConfigService.GetOverlays()
+	if err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	conflicts, err := a.ConfigService.GetOverlayConflicts()
+	jsonObj(c, gin.H{
+		"overlays":  overlays,
+		"conflicts": conflicts,
+	}, err)
+}
+
+// SaveOverlay 保存从节点本地覆盖配置并重启 core
+func (a *ApiService) SaveOverlay(c *gin.Context) {
+	if !config.IsWorker() {
+		jsonMsg(c, "", common.NewError("only worker node has local overlays"))
+		return
+	}
+
+	var overlay model.LocalOverlay
+	err := json.Unmarshal([]byte(c.Request.FormValue("data")), &overlay)
+	if err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	err = a.ConfigService.SaveOverlay(&overlay)
+	if err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	err = a.ConfigService.RestartCore()
+	jsonObj(c, overlay, err)
+}
+
+// DeleteOverlay 删除从节点本地覆盖配置并重启 core
+func (a *ApiService) DeleteOverlay(c *gin.Context) {
+	if !config.IsWorker() {
+		jsonMsg(c, "", common.NewError("only worker node has local overlays"))
+		return
+	}
+
+	id, err := strconv.ParseUint(c.Request.FormValue("id"), 10, 32)
+	if err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	err = a.ConfigService.DeleteOverlay(uint(id))
+	if err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	err = a.ConfigService.RestartCore()
+	jsonMsg(c, "", err)
+}
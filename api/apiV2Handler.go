@@ -86,6 +86,12 @@ func (a *APIv2Handler) getHandler(c *gin.Context) {
 		a.ApiService.GetKeypairs(c)
 	case "getdb":
 		a.ApiService.GetDb(c)
+	case "nodeStats":
+		a.ApiService.GetNodeStats(c)
+	case "nodeProbes":
+		a.ApiService.GetNodeProbes(c)
+	case "nodeCommands":
+		a.ApiService.GetNodeCommands(c)
 	default:
 		jsonMsg(c, "failed", common.NewError("unknown action: ", action))
 	}
@@ -4,6 +4,7 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"net/http"
+	"strconv"
 
 	"github.com/alireza0/s-ui/database"
 	"github.com/alireza0/s-ui/database/model"
@@ -45,6 +46,8 @@ type UserCreateRequest struct {
 	Inbounds             []uint `json:"inbounds"`             // 关联的 Inbound IDs
 	Desc                 string `json:"desc"`                 // 描述
 	Group                string `json:"group"`                // 分组
+	// 可使用的节点分组，为空时可使用所有非会员节点 (会员可使用所有节点)
+	NodeGroups []string `json:"nodeGroups"`
 }
 
 // UserUpdateRequest 更新用户请求
@@ -62,6 +65,8 @@ type UserUpdateRequest struct {
 	Inbounds             []uint  `json:"inbounds,omitempty"`
 	Desc                 *string `json:"desc,omitempty"`
 	Group                *string `json:"group,omitempty"`
+	// 传入空数组表示取消分组限制
+	NodeGroups []string `json:"nodeGroups,omitempty"`
 }
 
 // UserResponse 用户信息响应
@@ -81,8 +86,31 @@ type UserResponse struct {
 	TimeResetStrategy    string `json:"timeResetStrategy"`
 	SpeedLimit           int    `json:"speedLimit"`
 	DeviceLimit          int    `json:"deviceLimit"`
+	DeviceRejected       int64  `json:"deviceRejected"`
 	Desc                 string `json:"desc"`
 	Group                string `json:"group"`
+	// 可使用的节点分组
+	NodeGroups []string `json:"nodeGroups"`
+	// 订阅令牌，为空时订阅链接使用 UUID
+	SubToken string `json:"subToken"`
+}
+
+// SubscriptionRegenerateRequest 重新生成订阅链接请求
+type SubscriptionRegenerateRequest struct {
+	ExpiresAt int64 `json:"expiresAt"` // 链接过期时间戳，0=长期有效
+}
+
+// SubscriptionResponse 订阅链接响应
+type SubscriptionResponse struct {
+	service.SubTokenInfo
+	URL string `json:"url"`
+}
+
+// NodeGroupResponse 节点分组响应
+type NodeGroupResponse struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+	Desc string `json:"desc"`
 }
 
 // NewExternalHandler 创建外部 API 处理器
@@ -108,7 +136,14 @@ func (h *ExternalHandler) initRouter(g *gin.RouterGroup) {
 		users.POST("/:uuid/disable", h.disableUser)        // POST /api/v1/users/{uuid}/disable
 		users.POST("/:uuid/reset-traffic", h.resetTraffic) // POST /api/v1/users/{uuid}/reset-traffic
 		users.POST("/:uuid/reset-time", h.resetTime)       // POST /api/v1/users/{uuid}/reset-time
+
+		// 订阅链接
+		users.GET("/:uuid/subscription", h.getSubscription)                    // GET /api/v1/users/{uuid}/subscription
+		users.POST("/:uuid/subscription/regenerate", h.regenerateSubscription) // POST /api/v1/users/{uuid}/subscription/regenerate
 	}
+
+	// 节点分组 (用户可用分组的取值)
+	g.GET("/node-groups", h.getNodeGroups) // GET /api/v1/node-groups
 }
 
 // apiKeyAuth API Key 认证中间件
@@ -271,6 +306,16 @@ func (h *ExternalHandler) createUser(c *gin.Context) {
 	}
 	inboundsJSON, _ := json.Marshal(inbounds)
 
+	// 处理节点分组
+	if err := h.ConfigService.NodeService.ValidateNodeGroups(req.NodeGroups); err != nil {
+		h.errorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	nodeGroupsJSON, _ := json.Marshal(req.NodeGroups)
+	if req.NodeGroups == nil {
+		nodeGroupsJSON = nil
+	}
+
 	// 处理 Enable 默认值
 	enable := true
 	if req.Enable != nil {
@@ -298,6 +343,7 @@ func (h *ExternalHandler) createUser(c *gin.Context) {
 		Config:               config,
 		Desc:                 req.Desc,
 		Group:                req.Group,
+		NodeGroups:           nodeGroupsJSON,
 	}
 
 	// 使用 ConfigService.Save 来保存并触发核心重载
@@ -394,6 +440,14 @@ func (h *ExternalHandler) updateUser(c *gin.Context) {
 	if req.Group != nil {
 		client.Group = *req.Group
 	}
+	if req.NodeGroups != nil {
+		if err := h.ConfigService.NodeService.ValidateNodeGroups(req.NodeGroups); err != nil {
+			h.errorResponse(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		nodeGroupsJSON, _ := json.Marshal(req.NodeGroups)
+		client.NodeGroups = nodeGroupsJSON
+	}
 
 	// 使用 ConfigService.Save 来保存并触发核心重载
 	clientJSON, _ := json.Marshal(client)
@@ -532,6 +586,74 @@ func (h *ExternalHandler) resetTime(c *gin.Context) {
 	h.successResponse(c, nil)
 }
 
+// getSubscription 获取订阅链接
+// 设置了订阅令牌时用当前令牌签发 (可通过 expiresAt 参数限制有效期)，否则返回 UUID 链接
+func (h *ExternalHandler) getSubscription(c *gin.Context) {
+	client, err := h.ClientService.GetByUUID(c.Param("uuid"))
+	if err != nil {
+		h.errorResponse(c, http.StatusNotFound, "user not found")
+		return
+	}
+
+	expiresAt, err := strconv.ParseInt(c.DefaultQuery("expiresAt", "0"), 10, 64)
+	if err != nil {
+		h.errorResponse(c, http.StatusBadRequest, "invalid expiresAt")
+		return
+	}
+
+	info := &service.SubTokenInfo{SubId: client.UUID}
+	if client.SubToken != "" {
+		info, err = h.ClientService.SignSubToken(client.SubToken, expiresAt)
+		if err != nil {
+			h.errorResponse(c, http.StatusBadRequest, "failed to sign subscription url: "+err.Error())
+			return
+		}
+	} else if expiresAt != 0 {
+		h.errorResponse(c, http.StatusBadRequest, "user has no subscription token, regenerate the subscription first")
+		return
+	}
+
+	h.successResponse(c, h.toSubscriptionResponse(c, info))
+}
+
+// regenerateSubscription 轮换订阅令牌，之前的订阅链接 (包括 UUID 和名称链接) 立即失效
+func (h *ExternalHandler) regenerateSubscription(c *gin.Context) {
+	client, err := h.ClientService.GetByUUID(c.Param("uuid"))
+	if err != nil {
+		h.errorResponse(c, http.StatusNotFound, "user not found")
+		return
+	}
+
+	var req SubscriptionRegenerateRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			h.errorResponse(c, http.StatusBadRequest, "invalid request body: "+err.Error())
+			return
+		}
+	}
+
+	info, err := h.ClientService.RotateSubToken(client.Id, req.ExpiresAt)
+	if err != nil {
+		h.errorResponse(c, http.StatusBadRequest, "failed to regenerate subscription: "+err.Error())
+		return
+	}
+
+	logger.Info("External API: regenerated subscription token for user ", client.Name)
+	h.successResponse(c, h.toSubscriptionResponse(c, info))
+}
+
+// toSubscriptionResponse 拼接完整的订阅链接
+func (h *ExternalHandler) toSubscriptionResponse(c *gin.Context, info *service.SubTokenInfo) *SubscriptionResponse {
+	subURI, err := h.ConfigService.SettingService.GetFinalSubURI(getHostname(c))
+	if err != nil {
+		logger.Warning("Failed to get subscription uri: ", err)
+	}
+	return &SubscriptionResponse{
+		SubTokenInfo: *info,
+		URL:          subURI + info.SubId,
+	}
+}
+
 // toUserResponse 转换为用户响应
 func (h *ExternalHandler) toUserResponse(client *model.Client) *UserResponse {
 	return &UserResponse{
@@ -550,7 +672,29 @@ func (h *ExternalHandler) toUserResponse(client *model.Client) *UserResponse {
 		TimeResetStrategy:    client.TimeResetStrategy,
 		SpeedLimit:           client.SpeedLimit,
 		DeviceLimit:          client.DeviceLimit,
+		DeviceRejected:       client.DeviceRejected,
 		Desc:                 client.Desc,
 		Group:                client.Group,
+		NodeGroups:           service.ParseNodeScope(client.NodeGroups),
+		SubToken:             client.SubToken,
+	}
+}
+
+// getNodeGroups 获取节点分组列表
+func (h *ExternalHandler) getNodeGroups(c *gin.Context) {
+	groups, err := h.ConfigService.NodeService.GetNodeGroups()
+	if err != nil {
+		h.errorResponse(c, http.StatusInternalServerError, "failed to get node groups: "+err.Error())
+		return
+	}
+
+	result := make([]NodeGroupResponse, 0, len(groups))
+	for _, group := range groups {
+		result = append(result, NodeGroupResponse{
+			Name: group.Name,
+			Kind: group.Kind,
+			Desc: group.Desc,
+		})
 	}
+	h.successResponse(c, result)
 }
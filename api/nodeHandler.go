@@ -1,10 +1,17 @@
 package api
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/alireza0/s-ui/config"
 	"github.com/alireza0/s-ui/database/model"
+	"github.com/alireza0/s-ui/logger"
 	"github.com/alireza0/s-ui/service"
 
 	"github.com/gin-gonic/gin"
@@ -12,12 +19,18 @@ import (
 
 // NodeHandler 节点 API Handler (主节点提供，从节点调用)
 type NodeHandler struct {
-	nodeService service.NodeService
+	nodeService    service.NodeService
+	clientService  service.ClientService
+	settingService service.SettingService
 }
 
 // NewNodeHandler 创建 NodeHandler 并注册路由
 func NewNodeHandler(g *gin.RouterGroup) *NodeHandler {
 	h := &NodeHandler{}
+	// 首次启动时生成配置签名密钥
+	if _, err := h.nodeService.NodeSignPublicKey(); err != nil {
+		logger.Warning("Failed to load node config signing key: ", err)
+	}
 	h.initRouter(g)
 	return h
 }
@@ -32,19 +45,26 @@ func (h *NodeHandler) initRouter(g *gin.RouterGroup) {
 	{
 		auth.GET("/config/version", h.getConfigVersion)
 		auth.GET("/config", h.getConfig)
+		auth.GET("/config/watch", h.watchConfig)
 		auth.POST("/stats", h.reportStats)
 		auth.POST("/onlines", h.reportOnlines)
 		auth.POST("/heartbeat", h.heartbeat)
+		auth.POST("/cert", h.issueCert)
+		auth.POST("/commands", h.takeCommands)
+		auth.POST("/commands/result", h.reportCommandResult)
 	}
 }
 
 // authMiddleware 节点认证中间件
+// 从节点用节点密钥对请求签名 (X-Node-Timestamp / X-Node-Nonce / X-Node-Signature)
+// 未携带签名时按旧版 X-Node-Token 认证，只对尚未分配节点密钥的节点有效
 func (h *NodeHandler) authMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		nodeId := c.GetHeader("X-Node-Id")
+		signature := c.GetHeader("X-Node-Signature")
 		token := c.GetHeader("X-Node-Token")
 
-		if nodeId == "" || token == "" {
+		if nodeId == "" || (signature == "" && token == "") {
 			c.JSON(http.StatusUnauthorized, gin.H{
 				"success": false,
 				"msg":     "missing node credentials",
@@ -53,7 +73,23 @@ func (h *NodeHandler) authMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		node, err := h.nodeService.AuthenticateNode(nodeId, token)
+		var node *model.Node
+		var err error
+		if signature != "" {
+			var body []byte
+			body, err = c.GetRawData()
+			if err == nil {
+				// 签名覆盖请求体，读取后放回供后续处理使用
+				c.Request.Body = io.NopCloser(bytes.NewReader(body))
+				node, err = h.nodeService.VerifyNodeRequest(nodeId, c.Request.Method, c.Request.URL.RequestURI(), body,
+					c.GetHeader("X-Node-Timestamp"), c.GetHeader("X-Node-Nonce"), signature)
+			}
+		} else {
+			node, err = h.nodeService.AuthenticateNode(nodeId, token)
+		}
+		if err == nil {
+			err = h.nodeService.VerifyNodeClientCert(nodeId, c.Request.TLS)
+		}
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{
 				"success": false,
@@ -79,6 +115,8 @@ type RegisterRequest struct {
 	ExternalHost string `json:"externalHost"`
 	ExternalPort int    `json:"externalPort"`
 	Version      string `json:"version"`
+	// 客户端证书签名请求 (PEM)，主节点支持 mTLS 时签发客户端证书
+	CSR string `json:"csr"`
 }
 
 // register 处理节点注册
@@ -123,30 +161,164 @@ func (h *NodeHandler) register(c *gin.Context) {
 		return
 	}
 
+	obj := gin.H{
+		"nodeId": node.NodeId,
+		"token":  node.Token,
+		// 节点密钥，之后的请求都用它签名
+		"secret": node.Secret,
+	}
+	// 配置签名公钥，从节点注册时固定
+	if signKey, err := h.nodeService.NodeSignPublicKey(); err == nil {
+		obj["signKey"] = signKey
+	} else {
+		logger.Warning("Failed to load node config signing key: ", err)
+	}
+	if req.CSR != "" && h.nodeService.NodeTLSEnabled() {
+		cert, ca, err := h.nodeService.IssueNodeCert(node.NodeId, req.CSR)
+		if err != nil {
+			// 注册已完成，从节点可稍后通过 /node/cert 申请证书
+			logger.Warning("Issue node certificate failed: ", err)
+		} else {
+			obj["clientCert"] = cert
+			obj["caCert"] = ca
+		}
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"msg":     "registered",
+		"obj":     obj,
+	})
+}
+
+// CertRequest 客户端证书申请
+type CertRequest struct {
+	CSR string `json:"csr" binding:"required"`
+}
+
+// issueCert 为已注册的从节点签发 (或续签) 客户端证书，之前的证书被吊销
+func (h *NodeHandler) issueCert(c *gin.Context) {
+	nodeId := c.GetString("nodeId")
+
+	var req CertRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"msg":     "invalid request: " + err.Error(),
+		})
+		return
+	}
+
+	cert, ca, err := h.nodeService.IssueNodeCert(nodeId, req.CSR)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"msg":     err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
 		"obj": gin.H{
-			"nodeId": node.NodeId,
-			"token":  node.Token,
+			"clientCert": cert,
+			"caCert":     ca,
 		},
 	})
 }
 
 // getConfigVersion 获取配置版本
+// hash 为节点配置内容的哈希；version 为最近一次配置变更的时间，供旧版从节点使用
 func (h *NodeHandler) getConfigVersion(c *gin.Context) {
-	version := h.nodeService.GetConfigVersion()
+	nodeId := c.GetString("nodeId")
+	hash, err := h.nodeService.GetConfigVersion(nodeId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"msg":     err.Error(),
+		})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
-		"version": version,
+		"hash":    hash,
+		"version": h.nodeService.GetConfigUpdateTime(),
 	})
 }
 
-// getConfig 获取完整配置
+// watchConfig 等待配置变更 (长轮询)
+// 带 commands=1 时有新的远程命令也会提前返回
+// 从节点带上本地版本 (hash) 请求，主节点在版本变化时立即返回，否则保持到超时
+// 不带 hash 的旧版从节点按 version (LastUpdate 时间戳) 等待
+func (h *NodeHandler) watchConfig(c *gin.Context) {
+	nodeId := c.GetString("nodeId")
+	hash, watchHash := c.GetQuery("hash")
+	version, _ := strconv.ParseInt(c.Query("version"), 10, 64)
+	watchCommands := c.Query("commands") == "1"
+
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+	if watchCommands {
+		signal := h.nodeService.NodeCommandSignal(nodeId)
+		pending, err := h.nodeService.HasPendingNodeCommands(nodeId)
+		if err != nil {
+			logger.Warning("Failed to check node commands: ", err)
+		}
+		if pending {
+			cancel()
+		}
+		go func() {
+			select {
+			case <-signal:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	}
+
+	var result gin.H
+	if watchHash {
+		current, err := h.nodeService.WaitConfigVersion(ctx, nodeId, hash)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"success": false,
+				"msg":     err.Error(),
+			})
+			return
+		}
+		result = gin.H{
+			"success": true,
+			"hash":    current,
+			"version": h.nodeService.GetConfigUpdateTime(),
+			"changed": current != hash,
+		}
+	} else {
+		current := h.nodeService.WaitConfigChange(ctx, version)
+		result = gin.H{
+			"success": true,
+			"version": current,
+			"changed": current != version,
+		}
+	}
+	if watchCommands {
+		pending, err := h.nodeService.HasPendingNodeCommands(nodeId)
+		if err != nil {
+			logger.Warning("Failed to check node commands: ", err)
+		}
+		result["commands"] = pending
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// getConfig 获取配置
+// 带 since 参数时返回自该版本以来的增量，无法计算增量时返回全量配置
+// If-None-Match 与当前版本相同时返回 304，不重复下发配置
 func (h *NodeHandler) getConfig(c *gin.Context) {
 	nodeId := c.GetString("nodeId")
+	since := c.Query("since")
 
-	configData, err := h.nodeService.GetFullConfig()
+	// 先用缓存的版本判断是否变化，未变化时不计算增量也不更新同步时间
+	version, err := h.nodeService.GetConfigVersion(nodeId)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
@@ -154,18 +326,50 @@ func (h *NodeHandler) getConfig(c *gin.Context) {
 		})
 		return
 	}
+	if match := strings.TrimPrefix(c.GetHeader("If-None-Match"), "W/"); match == strconv.Quote(version) {
+		c.Header("ETag", match)
+		c.Status(http.StatusNotModified)
+		return
+	}
 
-	// 更新节点最后同步时间
-	h.nodeService.UpdateLastSync(nodeId)
+	configData, err := h.nodeService.GetConfigDiff(nodeId, since)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"msg":     err.Error(),
+		})
+		return
+	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"obj":     configData,
+	// 更新节点最后同步时间
+	hash := configData["hash"].(string)
+	h.nodeService.UpdateLastSync(nodeId, hash)
+	c.Header("ETag", strconv.Quote(hash))
+
+	// 对配置的原始 JSON 和配置序号签名，原样返回以便从节点按相同字节校验
+	payload, err := json.Marshal(configData)
+	if err == nil {
+		var serial int64
+		var signature string
+		serial, signature, err = h.nodeService.SignNodeConfig(nodeId, payload)
+		if err == nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success":   true,
+				"obj":       json.RawMessage(payload),
+				"serial":    serial,
+				"signature": signature,
+			})
+			return
+		}
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{
+		"success": false,
+		"msg":     err.Error(),
 	})
 }
 
-// StatsRequest 统计上报请求
-type StatsRequest []struct {
+// StatsItem 单条统计数据
+type StatsItem struct {
 	DateTime  int64  `json:"dateTime"`
 	Resource  string `json:"resource"`
 	Tag       string `json:"tag"`
@@ -173,12 +377,27 @@ type StatsRequest []struct {
 	Traffic   int64  `json:"traffic"`
 }
 
+// StatsRequest 统计上报请求 (按批次上报，主节点按批次 ID 去重)
+type StatsRequest struct {
+	BatchId string      `json:"batchId"`
+	Stats   []StatsItem `json:"stats"`
+}
+
 // reportStats 处理统计上报
+// 兼容旧版从节点直接上报数组的格式 (无批次 ID，不去重)
 func (h *NodeHandler) reportStats(c *gin.Context) {
 	nodeId := c.GetString("nodeId")
 
 	var req StatsRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
+	body, err := c.GetRawData()
+	if err == nil {
+		if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '[' {
+			err = json.Unmarshal(body, &req.Stats)
+		} else {
+			err = json.Unmarshal(body, &req)
+		}
+	}
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"success": false,
 			"msg":     "invalid request: " + err.Error(),
@@ -187,8 +406,8 @@ func (h *NodeHandler) reportStats(c *gin.Context) {
 	}
 
 	// 转换为 model.Stats
-	stats := make([]model.Stats, len(req))
-	for i, s := range req {
+	stats := make([]model.Stats, len(req.Stats))
+	for i, s := range req.Stats {
 		stats[i] = model.Stats{
 			DateTime:  s.DateTime,
 			Resource:  s.Resource,
@@ -199,7 +418,7 @@ func (h *NodeHandler) reportStats(c *gin.Context) {
 		}
 	}
 
-	err := h.nodeService.SaveNodeStats(nodeId, stats)
+	duplicate, err := h.nodeService.SaveNodeStats(nodeId, req.BatchId, stats)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
@@ -210,6 +429,10 @@ func (h *NodeHandler) reportStats(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
+		"obj": gin.H{
+			"batchId":   req.BatchId,
+			"duplicate": duplicate,
+		},
 	})
 }
 
@@ -221,6 +444,8 @@ type OnlinesRequest struct {
 		SourceIP    string `json:"sourceIP"`
 		ConnectedAt int64  `json:"connectedAt"`
 	} `json:"onlines"`
+	// 因设备数超限被拒绝的连接数 (client -> 次数)
+	Rejected map[string]int64 `json:"rejected"`
 }
 
 // reportOnlines 处理在线状态上报
@@ -256,8 +481,32 @@ func (h *NodeHandler) reportOnlines(c *gin.Context) {
 		return
 	}
 
+	err = h.clientService.AddDeviceRejected(req.Rejected)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"msg":     err.Error(),
+		})
+		return
+	}
+
+	// 下发其他节点上的在线设备和超限策略，从节点据此做全集群设备数限制
+	devices, err := h.nodeService.GetRemoteDevices(nodeId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"msg":     err.Error(),
+		})
+		return
+	}
+	policy, _ := h.settingService.GetDeviceLimitPolicy()
+
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
+		"obj": gin.H{
+			"devices": devices,
+			"policy":  policy,
+		},
 	})
 }
 
@@ -269,6 +518,19 @@ type HeartbeatRequest struct {
 	Version      string  `json:"version"`
 	ExternalHost string  `json:"externalHost"`
 	ExternalPort int     `json:"externalPort"`
+	// 配置推送通道状态: connected / disconnected
+	ConfigStream string `json:"configStream"`
+	// 从节点本地覆盖配置与同步配置的 tag 冲突
+	Conflicts []service.OverlayConflict `json:"conflicts"`
+	// 自上次心跳以来的入站上传 / 下载流量
+	Upload   int64 `json:"upload"`
+	Download int64 `json:"download"`
+	// 从节点支持通过心跳接收远程命令
+	Commands bool `json:"commands"`
+	// 从节点最近一次配置签名校验失败的原因
+	ConfigError string `json:"configError"`
+	// 从节点已应用配置的序号
+	ConfigSerial int64 `json:"configSerial"`
 }
 
 // heartbeat 处理心跳
@@ -284,7 +546,20 @@ func (h *NodeHandler) heartbeat(c *gin.Context) {
 		return
 	}
 
-	err := h.nodeService.Heartbeat(nodeId, req.CPU, req.Memory, req.Connections, req.Version, req.ExternalHost, req.ExternalPort)
+	err := h.nodeService.Heartbeat(nodeId, service.HeartbeatInfo{
+		CPU:          req.CPU,
+		Memory:       req.Memory,
+		Connections:  req.Connections,
+		Version:      req.Version,
+		ExternalHost: req.ExternalHost,
+		ExternalPort: req.ExternalPort,
+		ConfigStream: req.ConfigStream,
+		Conflicts:    req.Conflicts,
+		Upload:       req.Upload,
+		Download:     req.Download,
+		ConfigError:  req.ConfigError,
+		ConfigSerial: req.ConfigSerial,
+	})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
@@ -293,8 +568,93 @@ func (h *NodeHandler) heartbeat(c *gin.Context) {
 		return
 	}
 
+	result := gin.H{
+		"success": true,
+		"time":    h.nodeService.GetConfigUpdateTime(),
+	}
+	// 下发待确认的节点密钥：用注册 token 认证 (尚无节点密钥) 的请求直接下发；
+	// 管理员轮换密钥时，用当前密钥认证的请求获得用当前密钥加密的新密钥
+	if node, ok := c.Get("node"); ok {
+		if node.(*model.Node).Secret == "" && node.(*model.Node).NextSecret != "" {
+			result["secret"] = node.(*model.Node).NextSecret
+		} else if sealed, err := h.nodeService.SealedNextSecret(node.(*model.Node)); err != nil {
+			logger.Warning("Failed to seal node secret: ", err)
+		} else if sealed != "" {
+			result["nextSecret"] = sealed
+		}
+	}
+	// 下发排空状态
+	if node, ok := c.Get("node"); ok && node.(*model.Node).Draining {
+		result["drain"] = service.NodeDrain{
+			Since: node.(*model.Node).DrainSince,
+			Grace: node.(*model.Node).DrainGrace,
+		}
+	}
+	// 流量预算用满时下发处理方式
+	if node, ok := c.Get("node"); ok && service.NodeBudgetExceeded(node.(*model.Node)) {
+		result["budget"] = service.NodeBudgetAction(node.(*model.Node))
+	}
+	// 下发待执行的远程命令
+	if req.Commands {
+		commands, err := h.nodeService.TakeNodeCommands(nodeId)
+		if err != nil {
+			logger.Warning("Failed to get node commands: ", err)
+		} else if len(commands) > 0 {
+			result["commands"] = commands
+		}
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// takeCommands 取走待执行的远程命令并标记为已下发 (从节点经推送通道得知有新命令后调用)
+func (h *NodeHandler) takeCommands(c *gin.Context) {
+	nodeId := c.GetString("nodeId")
+
+	commands, err := h.nodeService.TakeNodeCommands(nodeId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"msg":     err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"obj":     commands,
+	})
+}
+
+// CommandResultRequest 远程命令结果
+type CommandResultRequest struct {
+	Id      uint   `json:"id" binding:"required"`
+	Success bool   `json:"success"`
+	Result  string `json:"result"`
+}
+
+// reportCommandResult 接收从节点回报的远程命令结果
+func (h *NodeHandler) reportCommandResult(c *gin.Context) {
+	nodeId := c.GetString("nodeId")
+
+	var req CommandResultRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"msg":     "invalid request: " + err.Error(),
+		})
+		return
+	}
+
+	// 结果被拒绝时从节点不再重试，因此使用 200 返回
+	if err := h.nodeService.CompleteNodeCommand(nodeId, req.Id, req.Success, req.Result); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"msg":     err.Error(),
+		})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
-		"time":    h.nodeService.GetConfigVersion(),
 	})
 }
@@ -212,6 +212,11 @@ func GetNodeToken() string {
 	return nodeToken
 }
 
+// GetMasterSignKey 获取运维指定的主节点配置签名公钥 (base64)，注册早于配置签名的从节点用它固定公钥
+func GetMasterSignKey() string {
+	return os.Getenv("SUI_MASTER_SIGN_KEY")
+}
+
 // GetExternalHost 获取外部主机地址
 func GetExternalHost() string {
 	if envHost := os.Getenv("SUI_EXTERNAL_HOST"); envHost != "" {
@@ -53,6 +53,7 @@ type Box struct {
 	statsTracker    *StatsTracker
 	connTracker     *ConnTracker
 	timeTracker     *UserTimeTracker
+	speedLimiter    *SpeedLimiter
 	done            chan struct{}
 }
 
@@ -331,6 +332,9 @@ func NewBox(options Options) (*Box, error) {
 		statsTracker = NewStatsTracker()
 	}
 	router.AppendTracker(statsTracker)
+	if speedLimiter == nil {
+		speedLimiter = NewSpeedLimiter()
+	}
 	if connTracker == nil {
 		connTracker = NewConnTracker()
 	}
@@ -400,6 +404,7 @@ func NewBox(options Options) (*Box, error) {
 		statsTracker:    statsTracker,
 		connTracker:     connTracker,
 		timeTracker:     timeTracker,
+		speedLimiter:    speedLimiter,
 		done:            make(chan struct{}),
 	}, nil
 }
@@ -553,3 +558,7 @@ func (s *Box) ConnTracker() *ConnTracker {
 func (s *Box) TimeTracker() *UserTimeTracker {
 	return s.timeTracker
 }
+
+func (s *Box) SpeedLimiter() *SpeedLimiter {
+	return s.speedLimiter
+}
@@ -26,6 +26,7 @@ var (
 	statsTracker     *StatsTracker
 	connTracker      *ConnTracker
 	timeTracker      *UserTimeTracker
+	speedLimiter     *SpeedLimiter
 	factory          log.Factory
 )
 
@@ -4,10 +4,13 @@ import (
 	"context"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gofrs/uuid/v5"
 	"github.com/sagernet/sing-box/adapter"
+	"github.com/sagernet/sing/common/buf"
+	M "github.com/sagernet/sing/common/metadata"
 	"github.com/sagernet/sing/common/network"
 )
 
@@ -19,18 +22,35 @@ type ConnectionInfo struct {
 	Type       string // "tcp" or "udp"
 	// UAP 扩展字段
 	User        string // 用户名 (从 metadata.User)
-	SourceIP    string // 来源 IP (从 metadata.Source)
+	SourceIP    string // 来源 IP (从 metadata.Source，不含端口)
 	ConnectedAt int64  // 连接时间戳
+	// 最近一次读写的时间戳 (用于关闭空闲连接)
+	lastActive atomic.Int64
+}
+
+// LastActive 最近一次读写的时间戳
+func (i *ConnectionInfo) LastActive() int64 {
+	return i.lastActive.Load()
 }
 
 type ConnTracker struct {
 	access      sync.Mutex
 	connections map[string]*ConnectionInfo
+	// 设备数限制
+	deviceLimits  map[string]int             // user -> 设备上限
+	remoteDevices map[string]map[string]bool // user -> 其他节点上的来源 IP
+	evictOldest   bool                       // 超限时踢掉最早的设备而不是拒绝新设备
+	rejected      map[string]int64           // user -> 被拒绝的连接数
+	// 只允许这些用户建立新连接 (nil 表示不限制)，无用户的连接不受影响
+	allowedUsers map[string]bool
 }
 
 func NewConnTracker() *ConnTracker {
 	return &ConnTracker{
-		connections: make(map[string]*ConnectionInfo),
+		connections:   make(map[string]*ConnectionInfo),
+		deviceLimits:  make(map[string]int),
+		remoteDevices: make(map[string]map[string]bool),
+		rejected:      make(map[string]int64),
 	}
 }
 
@@ -46,13 +66,18 @@ func (c *ConnTracker) RoutedConnection(ctx context.Context, conn net.Conn, metad
 		Inbound:     metadata.Inbound,
 		Type:        "tcp",
 		User:        metadata.User,
-		SourceIP:    metadata.Source.String(),
+		SourceIP:    sourceIP(metadata),
 		ConnectedAt: time.Now().Unix(),
 	}
 
-	c.trackConnection(connID, connInfo)
+	connInfo.lastActive.Store(connInfo.ConnectedAt)
 
-	return c.createWrappedConn(conn, connID)
+	if !c.admitConnection(connID, connInfo) {
+		conn.Close()
+		return conn
+	}
+
+	return c.createWrappedConn(speedLimiter.NewConn(conn, metadata.User), connInfo)
 }
 
 func (c *ConnTracker) RoutedPacketConnection(ctx context.Context, conn network.PacketConn, metadata adapter.InboundContext, matchedRule adapter.Rule, matchOutbound adapter.Outbound) network.PacketConn {
@@ -63,13 +88,18 @@ func (c *ConnTracker) RoutedPacketConnection(ctx context.Context, conn network.P
 		Inbound:     metadata.Inbound,
 		Type:        "udp",
 		User:        metadata.User,
-		SourceIP:    metadata.Source.String(),
+		SourceIP:    sourceIP(metadata),
 		ConnectedAt: time.Now().Unix(),
 	}
 
-	c.trackConnection(connID, connInfo)
+	connInfo.lastActive.Store(connInfo.ConnectedAt)
+
+	if !c.admitConnection(connID, connInfo) {
+		conn.Close()
+		return conn
+	}
 
-	return c.createWrappedPacketConn(conn, connID)
+	return c.createWrappedPacketConn(speedLimiter.NewPacketConn(conn, metadata.User), connInfo)
 }
 
 func (c *ConnTracker) CloseConnByInbound(inbound string) int {
@@ -92,10 +122,54 @@ func (c *ConnTracker) CloseConnByInbound(inbound string) int {
 	return closedCount
 }
 
-func (c *ConnTracker) trackConnection(connID string, connInfo *ConnectionInfo) {
+// SetAllowedUsers 限制只有 users 中的用户可以使用本节点，并关闭其他用户的现有连接
+// users 为 nil 时取消限制
+func (c *ConnTracker) SetAllowedUsers(users map[string]bool) int {
 	c.access.Lock()
 	defer c.access.Unlock()
-	c.connections[connID] = connInfo
+
+	c.allowedUsers = users
+	if users == nil {
+		return 0
+	}
+	closedCount := 0
+	for connID, connInfo := range c.connections {
+		if connInfo.User == "" || users[connInfo.User] {
+			continue
+		}
+		if connInfo.Conn != nil {
+			connInfo.Conn.Close()
+		}
+		if connInfo.PacketConn != nil {
+			connInfo.PacketConn.Close()
+		}
+		delete(c.connections, connID)
+		closedCount++
+	}
+	return closedCount
+}
+
+// CloseIdleConnections 关闭超过 idle 秒没有读写的连接，idle 为 0 时关闭所有连接
+func (c *ConnTracker) CloseIdleConnections(idle int64) int {
+	c.access.Lock()
+	defer c.access.Unlock()
+
+	deadline := time.Now().Unix() - idle
+	closedCount := 0
+	for connID, connInfo := range c.connections {
+		if idle > 0 && connInfo.LastActive() > deadline {
+			continue
+		}
+		if connInfo.Conn != nil {
+			connInfo.Conn.Close()
+		}
+		if connInfo.PacketConn != nil {
+			connInfo.PacketConn.Close()
+		}
+		delete(c.connections, connID)
+		closedCount++
+	}
+	return closedCount
 }
 
 func (c *ConnTracker) untrackConnection(connID string) {
@@ -104,27 +178,40 @@ func (c *ConnTracker) untrackConnection(connID string) {
 	delete(c.connections, connID)
 }
 
-func (c *ConnTracker) createWrappedConn(conn net.Conn, connID string) *wrappedConn {
+func (c *ConnTracker) createWrappedConn(conn net.Conn, info *ConnectionInfo) *wrappedConn {
 	return &wrappedConn{
-		Conn:   conn,
-		connID: connID,
+		Conn: conn,
+		info: info,
 	}
 }
 
-func (c *ConnTracker) createWrappedPacketConn(conn network.PacketConn, connID string) *wrappedPacketConn {
+func (c *ConnTracker) createWrappedPacketConn(conn network.PacketConn, info *ConnectionInfo) *wrappedPacketConn {
 	return &wrappedPacketConn{
 		PacketConn: conn,
-		connID:     connID,
+		info:       info,
 	}
 }
 
 type wrappedConn struct {
 	net.Conn
-	connID string
+	info *ConnectionInfo
+}
+
+func (w *wrappedConn) Read(p []byte) (int, error) {
+	n, err := w.Conn.Read(p)
+	if n > 0 {
+		w.info.lastActive.Store(time.Now().Unix())
+	}
+	return n, err
+}
+
+func (w *wrappedConn) Write(p []byte) (int, error) {
+	w.info.lastActive.Store(time.Now().Unix())
+	return w.Conn.Write(p)
 }
 
 func (w *wrappedConn) Close() error {
-	connTracker.untrackConnection(w.connID)
+	connTracker.untrackConnection(w.info.ID)
 	return w.Conn.Close()
 }
 
@@ -134,11 +221,24 @@ func (w *wrappedConn) Upstream() any {
 
 type wrappedPacketConn struct {
 	network.PacketConn
-	connID string
+	info *ConnectionInfo
+}
+
+func (w *wrappedPacketConn) ReadPacket(buffer *buf.Buffer) (M.Socksaddr, error) {
+	destination, err := w.PacketConn.ReadPacket(buffer)
+	if err == nil {
+		w.info.lastActive.Store(time.Now().Unix())
+	}
+	return destination, err
+}
+
+func (w *wrappedPacketConn) WritePacket(buffer *buf.Buffer, destination M.Socksaddr) error {
+	w.info.lastActive.Store(time.Now().Unix())
+	return w.PacketConn.WritePacket(buffer, destination)
 }
 
 func (w *wrappedPacketConn) Close() error {
-	connTracker.untrackConnection(w.connID)
+	connTracker.untrackConnection(w.info.ID)
 	return w.PacketConn.Close()
 }
 
@@ -188,13 +288,15 @@ func (c *ConnTracker) GetUniqueDeviceCount(user string) int {
 	return len(devices)
 }
 
-// CheckDeviceLimit 检查用户是否超出设备限制
+// CheckDeviceLimit 检查用户是否超出设备限制 (包含其他节点上的设备)
 // 返回 true 表示未超限，可以建立新连接
 func (c *ConnTracker) CheckDeviceLimit(user string, limit int) bool {
 	if limit <= 0 {
 		return true // 0 表示无限制
 	}
-	return c.GetUniqueDeviceCount(user) < limit
+	c.access.Lock()
+	defer c.access.Unlock()
+	return c.countDevices(user) < limit
 }
 
 // GetOnlineUsers 获取当前在线的所有用户列表
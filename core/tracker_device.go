@@ -0,0 +1,161 @@
+package core
+
+import (
+	"github.com/sagernet/sing-box/adapter"
+)
+
+// sourceIP 获取连接来源 IP (同一设备的不同连接端口不同，设备按 IP 区分)
+func sourceIP(metadata adapter.InboundContext) string {
+	if !metadata.Source.Addr.IsValid() {
+		return ""
+	}
+	return metadata.Source.Addr.Unmap().String()
+}
+
+// SetDeviceLimits 更新用户设备数限制，未出现在 limits 中的用户不限制
+func (c *ConnTracker) SetDeviceLimits(limits map[string]int, evictOldest bool) {
+	c.access.Lock()
+	defer c.access.Unlock()
+
+	c.deviceLimits = make(map[string]int, len(limits))
+	for user, limit := range limits {
+		if limit > 0 {
+			c.deviceLimits[user] = limit
+		}
+	}
+	c.evictOldest = evictOldest
+}
+
+// SetRemoteDevices 更新用户在其他节点上的在线设备 (user -> 来源 IP 列表)
+// 主节点由 ClientOnline 记录汇总，从节点由主节点在 /node/onlines 响应中下发
+func (c *ConnTracker) SetRemoteDevices(devices map[string][]string) {
+	c.access.Lock()
+	defer c.access.Unlock()
+
+	c.remoteDevices = make(map[string]map[string]bool, len(devices))
+	for user, ips := range devices {
+		set := make(map[string]bool, len(ips))
+		for _, ip := range ips {
+			if ip != "" {
+				set[ip] = true
+			}
+		}
+		c.remoteDevices[user] = set
+	}
+}
+
+// GetLocalDevices 获取本节点上各用户的在线设备 (user -> 来源 IP 列表)
+func (c *ConnTracker) GetLocalDevices() map[string][]string {
+	c.access.Lock()
+	defer c.access.Unlock()
+
+	seen := make(map[string]bool)
+	result := make(map[string][]string)
+	for _, connInfo := range c.connections {
+		if connInfo.User == "" || connInfo.SourceIP == "" {
+			continue
+		}
+		key := connInfo.User + "|" + connInfo.SourceIP
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		result[connInfo.User] = append(result[connInfo.User], connInfo.SourceIP)
+	}
+	return result
+}
+
+// GetAndResetRejected 获取并清零各用户因设备超限被拒绝的连接数
+func (c *ConnTracker) GetAndResetRejected() map[string]int64 {
+	c.access.Lock()
+	defer c.access.Unlock()
+
+	result := c.rejected
+	c.rejected = make(map[string]int64)
+	return result
+}
+
+// admitConnection 按允许的用户和设备数限制决定是否接受新连接，接受时同时记录连接
+func (c *ConnTracker) admitConnection(connID string, connInfo *ConnectionInfo) bool {
+	c.access.Lock()
+	defer c.access.Unlock()
+
+	if c.allowedUsers != nil && connInfo.User != "" && !c.allowedUsers[connInfo.User] {
+		return false
+	}
+
+	limit := c.deviceLimits[connInfo.User]
+	if limit <= 0 || connInfo.SourceIP == "" || c.hasDevice(connInfo.User, connInfo.SourceIP) {
+		c.connections[connID] = connInfo
+		return true
+	}
+
+	if c.countDevices(connInfo.User) >= limit && c.evictOldest {
+		c.evictOldestDevice(connInfo.User)
+	}
+	if c.countDevices(connInfo.User) >= limit {
+		c.rejected[connInfo.User]++
+		return false
+	}
+
+	c.connections[connID] = connInfo
+	return true
+}
+
+// hasDevice 判断设备是否已在线 (本节点或其他节点)，调用方需持有锁
+func (c *ConnTracker) hasDevice(user string, ip string) bool {
+	if c.remoteDevices[user][ip] {
+		return true
+	}
+	for _, connInfo := range c.connections {
+		if connInfo.User == user && connInfo.SourceIP == ip {
+			return true
+		}
+	}
+	return false
+}
+
+// countDevices 统计用户全集群在线设备数，调用方需持有锁
+func (c *ConnTracker) countDevices(user string) int {
+	devices := make(map[string]bool)
+	for ip := range c.remoteDevices[user] {
+		devices[ip] = true
+	}
+	for _, connInfo := range c.connections {
+		if connInfo.User == user && connInfo.SourceIP != "" {
+			devices[connInfo.SourceIP] = true
+		}
+	}
+	return len(devices)
+}
+
+// evictOldestDevice 关闭用户在本节点上最早接入的设备的所有连接，调用方需持有锁
+// 其他节点上的设备无法在本地踢出
+func (c *ConnTracker) evictOldestDevice(user string) {
+	oldestIP := ""
+	var oldestAt int64
+	for _, connInfo := range c.connections {
+		if connInfo.User != user || connInfo.SourceIP == "" || c.remoteDevices[user][connInfo.SourceIP] {
+			continue
+		}
+		if oldestIP == "" || connInfo.ConnectedAt < oldestAt {
+			oldestIP = connInfo.SourceIP
+			oldestAt = connInfo.ConnectedAt
+		}
+	}
+	if oldestIP == "" {
+		return
+	}
+
+	for connID, connInfo := range c.connections {
+		if connInfo.User == user && connInfo.SourceIP == oldestIP {
+			if connInfo.Conn != nil {
+				connInfo.Conn.Close()
+			}
+			if connInfo.PacketConn != nil {
+				connInfo.PacketConn.Close()
+			}
+			delete(c.connections, connID)
+		}
+	}
+}
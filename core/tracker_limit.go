@@ -0,0 +1,186 @@
+package core
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/sagernet/sing/common/buf"
+	M "github.com/sagernet/sing/common/metadata"
+	"github.com/sagernet/sing/common/network"
+)
+
+// bytesPerMbps 1 Mbps 对应的每秒字节数
+const bytesPerMbps = 125000
+
+// tokenBucket 令牌桶 (允许透支，透支部分通过等待偿还)
+type tokenBucket struct {
+	access sync.Mutex
+	rate   float64 // 每秒字节数，0 表示不限速
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) setRate(rate float64) {
+	b.access.Lock()
+	defer b.access.Unlock()
+	if b.rate == rate {
+		return
+	}
+	b.rate = rate
+	b.tokens = rate
+	b.last = time.Now()
+}
+
+// wait 消耗 n 个令牌，令牌不足时阻塞到透支被偿还
+func (b *tokenBucket) wait(n int) {
+	b.access.Lock()
+	if b.rate <= 0 || n <= 0 {
+		b.access.Unlock()
+		return
+	}
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	// 突发上限为 1 秒的流量
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+	b.last = now
+	b.tokens -= float64(n)
+	var delay time.Duration
+	if b.tokens < 0 {
+		delay = time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+	b.access.Unlock()
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+// userLimiter 单个用户的上下行令牌桶 (该用户所有连接共享)
+type userLimiter struct {
+	up   tokenBucket
+	down tokenBucket
+}
+
+// SpeedLimiter 用户带宽限制器
+type SpeedLimiter struct {
+	access   sync.Mutex
+	limits   map[string]int // user -> Mbps
+	limiters map[string]*userLimiter
+}
+
+// NewSpeedLimiter 创建带宽限制器
+func NewSpeedLimiter() *SpeedLimiter {
+	return &SpeedLimiter{
+		limits:   make(map[string]int),
+		limiters: make(map[string]*userLimiter),
+	}
+}
+
+// SetLimits 更新全部用户的带宽限制 (Mbps)
+// 未出现在 limits 中的用户视为不限速，已建立的连接立即按新限制生效
+func (l *SpeedLimiter) SetLimits(limits map[string]int) {
+	l.access.Lock()
+	defer l.access.Unlock()
+
+	l.limits = make(map[string]int, len(limits))
+	for user, mbps := range limits {
+		if mbps > 0 {
+			l.limits[user] = mbps
+		}
+	}
+	for user, limiter := range l.limiters {
+		rate := float64(l.limits[user]) * bytesPerMbps
+		limiter.up.setRate(rate)
+		limiter.down.setRate(rate)
+	}
+}
+
+// GetLimit 获取用户当前带宽限制 (Mbps)，0 表示不限速
+func (l *SpeedLimiter) GetLimit(user string) int {
+	l.access.Lock()
+	defer l.access.Unlock()
+	return l.limits[user]
+}
+
+func (l *SpeedLimiter) loadOrCreate(user string) *userLimiter {
+	l.access.Lock()
+	defer l.access.Unlock()
+
+	limiter, loaded := l.limiters[user]
+	if loaded {
+		return limiter
+	}
+	limiter = &userLimiter{}
+	rate := float64(l.limits[user]) * bytesPerMbps
+	limiter.up.setRate(rate)
+	limiter.down.setRate(rate)
+	l.limiters[user] = limiter
+	return limiter
+}
+
+// NewConn 为用户的 TCP 连接套上限速
+func (l *SpeedLimiter) NewConn(conn net.Conn, user string) net.Conn {
+	if user == "" {
+		return conn
+	}
+	return &limitedConn{
+		Conn:    conn,
+		limiter: l.loadOrCreate(user),
+	}
+}
+
+// NewPacketConn 为用户的 UDP 连接套上限速
+func (l *SpeedLimiter) NewPacketConn(conn network.PacketConn, user string) network.PacketConn {
+	if user == "" {
+		return conn
+	}
+	return &limitedPacketConn{
+		PacketConn: conn,
+		limiter:    l.loadOrCreate(user),
+	}
+}
+
+type limitedConn struct {
+	net.Conn
+	limiter *userLimiter
+}
+
+func (c *limitedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.limiter.up.wait(n)
+	return n, err
+}
+
+func (c *limitedConn) Write(p []byte) (int, error) {
+	c.limiter.down.wait(len(p))
+	return c.Conn.Write(p)
+}
+
+func (c *limitedConn) Upstream() any {
+	return c.Conn
+}
+
+type limitedPacketConn struct {
+	network.PacketConn
+	limiter *userLimiter
+}
+
+func (c *limitedPacketConn) ReadPacket(buffer *buf.Buffer) (M.Socksaddr, error) {
+	destination, err := c.PacketConn.ReadPacket(buffer)
+	if err == nil {
+		c.limiter.up.wait(buffer.Len())
+	}
+	return destination, err
+}
+
+func (c *limitedPacketConn) WritePacket(buffer *buf.Buffer, destination M.Socksaddr) error {
+	c.limiter.down.wait(buffer.Len())
+	return c.PacketConn.WritePacket(buffer, destination)
+}
+
+func (c *limitedPacketConn) Upstream() any {
+	return c.PacketConn
+}
@@ -23,22 +23,32 @@ func (c *CronJob) Start(loc *time.Location, trafficAge int) error {
 		c.cron.AddJob("@every 10s", NewStatsJob(trafficAge > 0))
 		// Start expiry job (流量/过期时间检查)
 		c.cron.AddJob("@every 1m", NewDepleteJob())
-		// Start deleting old stats
-		if trafficAge > 0 {
-			c.cron.AddJob("@daily", NewDelStatsJob(trafficAge))
-		}
+		// Start deleting old stats and subscription access logs
+		c.cron.AddJob("@daily", NewDelStatsJob(trafficAge))
 		// Start core if it is not running
 		c.cron.AddJob("@every 5s", NewCheckCoreJob())
 
 		// UAP 新增任务
 		// 时长累计 (每 10 秒)
 		c.cron.AddJob("@every 10s", NewTimeTrackJob())
+		// 设备数限制 (每 10 秒)
+		c.cron.AddJob("@every 10s", NewDeviceLimitJob())
 		// 时长超限检查 (每 1 分钟)
 		c.cron.AddJob("@every 1m", NewTimeDepleteJob())
 		// 流量/时长重置 (每天)
 		c.cron.AddJob("@daily", NewResetJob())
 		// 节点状态检查 (每 30 秒，仅主节点)
 		c.cron.AddJob("@every 30s", NewNodeStatusJob())
+		// 节点外部可达性探测 (每 1 分钟，仅主节点)
+		c.cron.AddJob("@every 1m", NewNodeProbeJob())
+		// 节点统计降采样 (每小时，仅主节点)
+		c.cron.AddJob("@hourly", NewNodeStatsJob())
+		// 节点流量预算按月重置 (每小时检查，仅主节点)
+		c.cron.AddJob("@hourly", NewNodeBudgetJob())
+		// 订阅共享检测 (每 5 分钟，从节点不运行)
+		c.cron.AddJob("@every 5m", NewSubShareJob())
+		// 外部订阅缓存后台刷新 (每 1 分钟)
+		c.cron.AddJob("@every 1m", NewExternalSubJob())
 	}()
 
 	return nil
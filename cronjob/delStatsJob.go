@@ -7,6 +7,7 @@ import (
 
 type DelStatsJob struct {
 	service.StatsService
+	service.SubAccessService
 	trafficAge int
 }
 
@@ -17,6 +18,13 @@ func NewDelStatsJob(ta int) *DelStatsJob {
 }
 
 func (s *DelStatsJob) Run() {
+	// 订阅获取记录按 subLogAge 清理，与流量统计是否开启无关
+	if err := s.SubAccessService.DelOldSubAccess(); err != nil {
+		logger.Warning("Deleting old subscription access logs failed: ", err)
+	}
+	if s.trafficAge <= 0 {
+		return
+	}
 	err := s.StatsService.DelOldStats(s.trafficAge)
 	if err != nil {
 		logger.Warning("Deleting old statistics failed: ", err)
@@ -0,0 +1,23 @@
+package cronjob
+
+import (
+	"github.com/alireza0/s-ui/logger"
+	"github.com/alireza0/s-ui/service"
+)
+
+// DeviceLimitJob 设备数限制任务
+// 每 10 秒执行一次，同步集群在线设备并保存被拒绝的连接数
+type DeviceLimitJob struct {
+	service.StatsService
+}
+
+func NewDeviceLimitJob() *DeviceLimitJob {
+	return new(DeviceLimitJob)
+}
+
+func (j *DeviceLimitJob) Run() {
+	err := j.StatsService.UpdateDeviceLimits()
+	if err != nil {
+		logger.Warning("Update device limits failed: ", err)
+	}
+}
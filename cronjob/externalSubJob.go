@@ -0,0 +1,17 @@
+package cronjob
+
+import (
+	"github.com/alireza0/s-ui/service"
+)
+
+type ExternalSubJob struct {
+	service.ExternalSubService
+}
+
+func NewExternalSubJob() *ExternalSubJob {
+	return &ExternalSubJob{}
+}
+
+func (s *ExternalSubJob) Run() {
+	s.ExternalSubService.RefreshExternalSubs()
+}
@@ -0,0 +1,25 @@
+package cronjob
+
+import (
+	"github.com/alireza0/s-ui/config"
+	"github.com/alireza0/s-ui/logger"
+	"github.com/alireza0/s-ui/service"
+)
+
+type NodeBudgetJob struct {
+	service.NodeService
+}
+
+func NewNodeBudgetJob() *NodeBudgetJob {
+	return &NodeBudgetJob{}
+}
+
+func (s *NodeBudgetJob) Run() {
+	// 仅在主节点模式下运行
+	if !config.IsMaster() {
+		return
+	}
+	if err := s.NodeService.ResetNodeBudgets(); err != nil {
+		logger.Warning("Resetting node traffic budgets failed: ", err)
+	}
+}
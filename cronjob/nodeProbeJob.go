@@ -0,0 +1,28 @@
+package cronjob
+
+import (
+	"github.com/alireza0/s-ui/config"
+	"github.com/alireza0/s-ui/logger"
+	"github.com/alireza0/s-ui/service"
+)
+
+type NodeProbeJob struct {
+	service.NodeService
+	prober *service.NodeProber
+}
+
+func NewNodeProbeJob() *NodeProbeJob {
+	return &NodeProbeJob{
+		prober: service.NewNodeProber(),
+	}
+}
+
+func (s *NodeProbeJob) Run() {
+	// 仅在主节点模式下运行
+	if !config.IsMaster() {
+		return
+	}
+	if err := s.NodeService.ProbeNodes(s.prober); err != nil {
+		logger.Warning("Probing nodes failed: ", err)
+	}
+}
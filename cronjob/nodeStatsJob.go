@@ -0,0 +1,25 @@
+package cronjob
+
+import (
+	"github.com/alireza0/s-ui/config"
+	"github.com/alireza0/s-ui/logger"
+	"github.com/alireza0/s-ui/service"
+)
+
+type NodeStatsJob struct {
+	service.NodeService
+}
+
+func NewNodeStatsJob() *NodeStatsJob {
+	return &NodeStatsJob{}
+}
+
+func (s *NodeStatsJob) Run() {
+	// 仅在主节点模式下运行
+	if !config.IsMaster() {
+		return
+	}
+	if err := s.NodeService.DownsampleNodeStats(); err != nil {
+		logger.Warning("Downsampling node stats failed: ", err)
+	}
+}
@@ -2,11 +2,13 @@ package cronjob
 
 import (
 	"github.com/alireza0/s-ui/config"
+	"github.com/alireza0/s-ui/logger"
 	"github.com/alireza0/s-ui/service"
 )
 
 type NodeStatusJob struct {
 	service.NodeService
+	service.ClientService
 }
 
 func NewNodeStatusJob() *NodeStatusJob {
@@ -19,4 +21,13 @@ func (s *NodeStatusJob) Run() {
 		return
 	}
 	s.NodeService.UpdateNodeStatus()
+	if err := s.NodeService.DelOldStatsBatches(); err != nil {
+		logger.Warning("Deleting old stats batches failed: ", err)
+	}
+	if err := s.ClientService.DelOldTimeSlots(); err != nil {
+		logger.Warning("Deleting old online time slots failed: ", err)
+	}
+	if err := s.NodeService.ExpireNodeCommands(); err != nil {
+		logger.Warning("Expiring node commands failed: ", err)
+	}
 }
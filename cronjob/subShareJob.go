@@ -0,0 +1,25 @@
+package cronjob
+
+import (
+	"github.com/alireza0/s-ui/config"
+	"github.com/alireza0/s-ui/logger"
+	"github.com/alireza0/s-ui/service"
+)
+
+type SubShareJob struct {
+	service.SubAccessService
+}
+
+func NewSubShareJob() *SubShareJob {
+	return &SubShareJob{}
+}
+
+func (s *SubShareJob) Run() {
+	// 订阅由主节点 (或单机) 提供，从节点不检测
+	if config.IsWorker() {
+		return
+	}
+	if err := s.SubAccessService.DetectSubSharing(); err != nil {
+		logger.Warning("Detecting subscription sharing failed: ", err)
+	}
+}
@@ -84,11 +84,20 @@ func InitDB(dbPath string) error {
 		&model.Stats{},
 		&model.Client{},
 		&model.Changes{},
+		&model.SubAccess{},
 		// 多节点管理
 		&model.NodeToken{},
 		&model.Node{},
 		&model.NodeStats{},
 		&model.ClientOnline{},
+		&model.StatsOutbox{},
+		&model.NodeStatsBatch{},
+		&model.ClientTimeSlot{},
+		&model.LocalOverlay{},
+		&model.NodeProbe{},
+		&model.NodeCert{},
+		&model.NodeCommand{},
+		&model.NodeGroup{},
 		// UAP 扩展
 		&model.WebhookConfig{},
 		&model.ApiKey{},
@@ -96,6 +105,12 @@ func InitDB(dbPath string) error {
 	if err != nil {
 		return err
 	}
+	// 节点按 ID 关联邀请码，回填升级前注册的节点
+	err = db.Exec("UPDATE nodes SET token_id = (SELECT id FROM node_tokens WHERE node_tokens.token = nodes.token) " +
+		"WHERE token_id = 0 AND token IN (SELECT token FROM node_tokens)").Error
+	if err != nil {
+		return err
+	}
 	err = initUser()
 	if err != nil {
 		return err
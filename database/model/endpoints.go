@@ -10,6 +10,8 @@ type Endpoint struct {
 	Tag     string          `json:"tag" form:"tag" gorm:"unique"`
 	Options json.RawMessage `json:"-" form:"-"`
 	Ext     json.RawMessage `json:"ext" form:"ext"`
+	// 运行该端点的节点 (节点 ID / "group:分组" / "master")，为空表示所有节点
+	Nodes json.RawMessage `json:"nodes" form:"nodes"`
 }
 
 func (o *Endpoint) UnmarshalJSON(data []byte) error {
@@ -30,6 +32,8 @@ func (o *Endpoint) UnmarshalJSON(data []byte) error {
 	delete(raw, "tag")
 	o.Ext, _ = json.MarshalIndent(raw["ext"], "", "  ")
 	delete(raw, "ext")
+	o.Nodes, _ = json.MarshalIndent(raw["nodes"], "", "  ")
+	delete(raw, "nodes")
 
 	// Remaining fields
 	o.Options, err = json.MarshalIndent(raw, "", "  ")
@@ -15,6 +15,8 @@ type Inbound struct {
 
 	Addrs   json.RawMessage `json:"addrs" form:"addrs"`
 	OutJson json.RawMessage `json:"out_json" form:"out_json"`
+	// 运行该入站的节点 (节点 ID / "group:分组" / "master")，为空表示所有节点
+	Nodes   json.RawMessage `json:"nodes" form:"nodes"`
 	Options json.RawMessage `json:"-" form:"-"`
 }
 
@@ -51,6 +53,10 @@ func (i *Inbound) UnmarshalJSON(data []byte) error {
 	i.OutJson, _ = json.MarshalIndent(raw["out_json"], "", "  ")
 	delete(raw, "out_json")
 
+	// Nodes
+	i.Nodes, _ = json.MarshalIndent(raw["nodes"], "", "  ")
+	delete(raw, "nodes")
+
 	// Remaining fields
 	i.Options, err = json.MarshalIndent(raw, "", "  ")
 	return err
@@ -88,6 +94,7 @@ func (i Inbound) MarshalFull() (*map[string]interface{}, error) {
 	combined["tls_id"] = i.TlsId
 	combined["addrs"] = i.Addrs
 	combined["out_json"] = i.OutJson
+	combined["nodes"] = i.Nodes
 
 	if i.Options != nil {
 		var restFields map[string]interface{}
@@ -46,6 +46,11 @@ type Client struct {
 	TrafficResetAt       int64  `json:"trafficResetAt" form:"trafficResetAt" gorm:"default:0"`
 	SpeedLimit           int    `json:"speedLimit" form:"speedLimit" gorm:"default:0"`
 	DeviceLimit          int    `json:"deviceLimit" form:"deviceLimit" gorm:"default:0"`
+	DeviceRejected       int64  `json:"deviceRejected" form:"deviceRejected" gorm:"default:0"`
+	// 可使用的节点分组，为空时可使用所有非会员节点 (会员客户端可使用所有节点)
+	NodeGroups json.RawMessage `json:"nodeGroups" form:"nodeGroups"`
+	// 订阅令牌，与凭据 UUID 分开，可轮换 (只通过外部 API 修改)
+	SubToken string `json:"subToken" form:"subToken" gorm:"index"`
 }
 
 type Stats struct {
@@ -75,3 +80,14 @@ type Tokens struct {
 	UserId uint   `json:"userId" form:"userId"`
 	User   *User  `json:"user" gorm:"foreignKey:UserId;references:Id"`
 }
+
+// SubAccess 订阅获取记录，用于审计和共享检测
+type SubAccess struct {
+	Id         uint64 `json:"id" gorm:"primaryKey;autoIncrement"`
+	DateTime   int64  `json:"dateTime" gorm:"index"`
+	ClientId   uint   `json:"clientId" gorm:"index"`
+	ClientName string `json:"clientName"`
+	IP         string `json:"ip"`
+	UserAgent  string `json:"userAgent"`
+	Format     string `json:"format"`
+}
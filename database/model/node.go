@@ -11,38 +11,84 @@ type NodeToken struct {
 	UsedBy    string `json:"usedBy" form:"usedBy"`
 	ExpiresAt int64  `json:"expiresAt" form:"expiresAt"`
 	CreatedAt int64  `json:"createdAt" gorm:"autoCreateTime"`
+	// 可注册的节点数 (0 表示不限)，Used 表示已用满，UsedBy 为最近一次注册的节点
+	MaxUses  int `json:"maxUses" form:"maxUses" gorm:"default:1"`
+	UseCount int `json:"useCount" form:"useCount"`
+	// 注册的节点 ID 必须以该前缀开头 (为空不限制)
+	NodeIdPrefix string `json:"nodeIdPrefix" form:"nodeIdPrefix"`
+	// 使用该邀请码注册的节点的默认分组和国家
+	Group   string `json:"group" form:"group"`
+	Country string `json:"country" form:"country"`
+	Flag    string `json:"flag" form:"flag"`
+	// 已吊销的邀请码不能再注册节点
+	Revoked bool `json:"revoked" form:"revoked"`
 }
 
 // Node 节点注册信息
 type Node struct {
-	Id           uint            `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
-	NodeId       string          `json:"nodeId" form:"nodeId" gorm:"unique;not null"`
-	Name         string          `json:"name" form:"name" gorm:"not null"`
-	Address      string          `json:"address" form:"address"`
-	ExternalHost string          `json:"externalHost" form:"externalHost"`
-	ExternalPort int             `json:"externalPort" form:"externalPort" gorm:"default:0"`
-	Token        string          `json:"token" form:"token" gorm:"not null"`
-	Enable       bool            `json:"enable" form:"enable" gorm:"default:true"`
-	Status       string          `json:"status" form:"status" gorm:"default:'offline'"`
-	LastSeen     int64           `json:"lastSeen" form:"lastSeen"`
-	LastSync     int64           `json:"lastSync" form:"lastSync"`
-	Version      string          `json:"version" form:"version"`
-	SystemInfo   json.RawMessage `json:"systemInfo" form:"systemInfo" gorm:"type:text"`
-	CreatedAt    int64           `json:"createdAt" gorm:"autoCreateTime"`
-	UpdatedAt    int64           `json:"updatedAt" gorm:"autoUpdateTime"`
+	Id           uint   `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	NodeId       string `json:"nodeId" form:"nodeId" gorm:"unique;not null"`
+	Name         string `json:"name" form:"name" gorm:"not null"`
+	Address      string `json:"address" form:"address"`
+	ExternalHost string `json:"externalHost" form:"externalHost"`
+	ExternalPort int    `json:"externalPort" form:"externalPort" gorm:"default:0"`
+	Token        string `json:"token" form:"token" gorm:"not null"`
+	// 注册时使用的邀请码 (吊销并重新注册会更换 Token，按 ID 关联)
+	TokenId    uint            `json:"tokenId" form:"tokenId" gorm:"index"`
+	Enable     bool            `json:"enable" form:"enable" gorm:"default:true"`
+	Status     string          `json:"status" form:"status" gorm:"default:'offline'"`
+	LastSeen   int64           `json:"lastSeen" form:"lastSeen"`
+	LastSync   int64           `json:"lastSync" form:"lastSync"`
+	Version    string          `json:"version" form:"version"`
+	SystemInfo json.RawMessage `json:"systemInfo" form:"systemInfo" gorm:"type:text"`
+	CreatedAt  int64           `json:"createdAt" gorm:"autoCreateTime"`
+	UpdatedAt  int64           `json:"updatedAt" gorm:"autoUpdateTime"`
 	// UAP API 所需字段
 	Country   string `json:"country" form:"country"`
 	City      string `json:"city" form:"city"`
 	Flag      string `json:"flag" form:"flag"`
 	IsPremium bool   `json:"isPremium" form:"isPremium" gorm:"default:false"`
 	Latency   int    `json:"latency" form:"latency" gorm:"default:0"`
+	// 节点分组，多个分组用逗号分隔 (如 "hk,premium")；入站等可按 "group:分组" 分配给一组节点
+	Group string `json:"group" form:"group"`
+	// 主节点最近一次探测结果：外部不可达时在线节点标记为 degraded
+	Unreachable bool  `json:"unreachable" form:"unreachable"`
+	LastProbe   int64 `json:"lastProbe" form:"lastProbe"`
+	// 节点密钥，用于请求签名；NextSecret 为待从节点确认的新密钥，NextSecretExpiry 后失效
+	// 尚无密钥的节点在用注册 token 认证时获得 NextSecret；已有密钥的节点在轮换时获得用当前密钥加密的 NextSecret
+	Secret           string `json:"-" form:"-"`
+	NextSecret       string `json:"-" form:"-"`
+	NextSecretExpiry int64  `json:"-" form:"-"`
+	// 维护 (排空) 模式：继续同步和服务现有连接，但不出现在新生成的订阅中
+	// DrainGrace 秒内逐步关闭空闲连接，到期后关闭剩余连接 (0 表示不主动关闭)；DrainedAt 为连接数降为 0 的时间
+	Draining   bool  `json:"draining" form:"draining"`
+	DrainSince int64 `json:"drainSince" form:"drainSince"`
+	DrainGrace int64 `json:"drainGrace" form:"drainGrace"`
+	DrainedAt  int64 `json:"drainedAt" form:"drainedAt"`
+	// 月流量预算 (字节，0 表示不限)，每月 TrafficResetDay 日重置；TrafficUsed 为本周期已用流量
+	// 用满后按 BudgetAction 处理：remove 不再下发到订阅，premium 只服务会员客户端
+	TrafficBudget   int64  `json:"trafficBudget" form:"trafficBudget"`
+	TrafficResetDay int    `json:"trafficResetDay" form:"trafficResetDay"`
+	TrafficUsed     int64  `json:"trafficUsed" form:"trafficUsed"`
+	TrafficResetAt  int64  `json:"trafficResetAt" form:"trafficResetAt"`
+	BudgetAction    string `json:"budgetAction" form:"budgetAction"`
+	// 本周期已通知的最高预算阈值 (百分比)
+	BudgetAlert int `json:"budgetAlert" form:"budgetAlert"`
+	// 节点容量 (可承载的连接数，0 表示未设置)，用于订阅中的节点排序
+	Capacity int `json:"capacity" form:"capacity"`
+	// 从节点最近一次同步到的配置版本 (配置内容的哈希)
+	ConfigVersion string `json:"configVersion" form:"configVersion"`
+	// 下发给节点的配置序号，每次签名配置时递增，从节点拒绝序号不大于已应用配置的配置
+	ConfigSerial int64 `json:"-" form:"-"`
 }
 
-// NodeStats 节点统计快照
+// NodeStats 节点统计快照 (每次心跳一条，定时降采样)
 type NodeStats struct {
-	Id          uint64  `json:"id" gorm:"primaryKey;autoIncrement"`
-	NodeId      string  `json:"nodeId" gorm:"index;not null"`
-	DateTime    int64   `json:"dateTime" gorm:"index;not null"`
+	Id       uint64 `json:"id" gorm:"primaryKey;autoIncrement"`
+	NodeId   string `json:"nodeId" gorm:"index;not null"`
+	DateTime int64  `json:"dateTime" gorm:"index;not null"`
+	// 该条记录覆盖的秒数 (心跳间隔或降采样后的桶大小)
+	Interval    int64   `json:"interval" gorm:"default:0"`
 	CPU         float64 `json:"cpu"`
 	Memory      float64 `json:"memory"`
 	Connections int     `json:"connections"`
@@ -60,3 +106,94 @@ type ClientOnline struct {
 	ConnectedAt int64  `json:"connectedAt"`
 	LastSeen    int64  `json:"lastSeen" gorm:"not null"`
 }
+
+// StatsOutbox 从节点待上报的流量统计，主节点确认批次后删除
+type StatsOutbox struct {
+	Id        uint64 `json:"id" gorm:"primaryKey;autoIncrement"`
+	BatchId   string `json:"batchId" gorm:"index"`
+	DateTime  int64  `json:"dateTime"`
+	Resource  string `json:"resource"`
+	Tag       string `json:"tag"`
+	Direction bool   `json:"direction"`
+	Traffic   int64  `json:"traffic"`
+}
+
+// NodeStatsBatch 主节点已入账的从节点统计批次，用于重试时去重
+type NodeStatsBatch struct {
+	Id       uint64 `json:"id" gorm:"primaryKey;autoIncrement"`
+	NodeId   string `json:"nodeId" gorm:"uniqueIndex:idx_node_batch;not null"`
+	BatchId  string `json:"batchId" gorm:"uniqueIndex:idx_node_batch;not null"`
+	DateTime int64  `json:"dateTime" gorm:"index"`
+}
+
+// ClientTimeSlot 用户在某个采样时间段已计入的在线秒数
+// 主节点按墙钟时间合并多节点在线时长时使用，同一时间段只计一次
+type ClientTimeSlot struct {
+	Id         uint64 `json:"id" gorm:"primaryKey;autoIncrement"`
+	DateTime   int64  `json:"dateTime" gorm:"uniqueIndex:idx_client_slot;not null"`
+	ClientName string `json:"clientName" gorm:"uniqueIndex:idx_client_slot;not null"`
+	Seconds    int64  `json:"seconds"`
+}
+
+// LocalOverlay 从节点本地覆盖配置 (出站 / 端点 / 路由规则)
+// 不参与同步，全量同步清表时保留，启动 core 时合并到同步的配置中
+type LocalOverlay struct {
+	Id     uint   `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	Kind   string `json:"kind" form:"kind" gorm:"not null"`
+	Tag    string `json:"tag" form:"tag" gorm:"index"`
+	Enable bool   `json:"enable" form:"enable"`
+	Desc   string `json:"desc" form:"desc"`
+	// 完整的 sing-box 对象 (出站 / 端点 / 路由规则)
+	Options json.RawMessage `json:"options" form:"options"`
+}
+
+// NodeProbe 主节点对从节点外部地址的探测记录 (延迟和丢包历史)
+type NodeProbe struct {
+	Id       uint64 `json:"id" gorm:"primaryKey;autoIncrement"`
+	NodeId   string `json:"nodeId" gorm:"index;not null"`
+	DateTime int64  `json:"dateTime" gorm:"index;not null"`
+	// 探测目标: node (外部地址) 或入站 tag
+	Target  string `json:"target"`
+	Address string `json:"address"`
+	// 成功握手的平均延迟 (毫秒)，全部失败时为 0
+	Latency int `json:"latency"`
+	Sent    int `json:"sent"`
+	Lost    int `json:"lost"`
+}
+
+// NodeCert 主节点 CA 为从节点签发的客户端证书，删除节点或重新签发时吊销
+type NodeCert struct {
+	Id       uint   `json:"id" gorm:"primaryKey;autoIncrement"`
+	NodeId   string `json:"nodeId" gorm:"index;not null"`
+	Serial   string `json:"serial" gorm:"unique;not null"`
+	NotAfter int64  `json:"notAfter"`
+	Revoked  bool   `json:"revoked"`
+}
+
+// NodeCommand 主节点下发给从节点的远程命令 (命令队列)
+// 从节点通过心跳响应或配置推送通道获取，执行后回报结果
+type NodeCommand struct {
+	Id      uint            `json:"id" gorm:"primaryKey;autoIncrement"`
+	NodeId  string          `json:"nodeId" gorm:"index;not null"`
+	Command string          `json:"command" gorm:"not null"`
+	Args    json.RawMessage `json:"args"`
+	// pending / sent / success / failed / expired
+	Status     string `json:"status" gorm:"index"`
+	Result     string `json:"result"`
+	Actor      string `json:"actor"`
+	CreatedAt  int64  `json:"createdAt"`
+	SentAt     int64  `json:"sentAt"`
+	FinishedAt int64  `json:"finishedAt"`
+	// 最近一次下发的时间，未回报结果时按间隔重新下发
+	DeliveredAt int64 `json:"deliveredAt"`
+}
+
+// NodeGroup 命名的节点分组 (地区 / 等级 / 用途)
+// 节点通过 Node.Group 加入分组，客户端通过 Client.NodeGroups 获得分组的使用权
+type NodeGroup struct {
+	Id   uint   `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	Name string `json:"name" form:"name" gorm:"unique;not null"`
+	// region / tier / purpose
+	Kind string `json:"kind" form:"kind"`
+	Desc string `json:"desc" form:"desc"`
+}
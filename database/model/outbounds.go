@@ -3,9 +3,11 @@ package model
 import "encoding/json"
 
 type Outbound struct {
-	Id      uint            `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
-	Type    string          `json:"type" form:"type"`
-	Tag     string          `json:"tag" form:"tag" gorm:"unique"`
+	Id   uint   `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	Type string `json:"type" form:"type"`
+	Tag  string `json:"tag" form:"tag" gorm:"unique"`
+	// 运行该出站的节点 (节点 ID / "group:分组" / "master")，为空表示所有节点
+	Nodes   json.RawMessage `json:"nodes" form:"nodes"`
 	Options json.RawMessage `json:"-" form:"-"`
 }
 
@@ -25,6 +27,8 @@ func (o *Outbound) UnmarshalJSON(data []byte) error {
 	delete(raw, "type")
 	o.Tag = raw["tag"].(string)
 	delete(raw, "tag")
+	o.Nodes, _ = json.MarshalIndent(raw["nodes"], "", "  ")
+	delete(raw, "nodes")
 
 	// Remaining fields
 	o.Options, err = json.MarshalIndent(raw, "", "  ")
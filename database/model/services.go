@@ -13,6 +13,8 @@ type Service struct {
 	TlsId uint `json:"tls_id" form:"tls_id"`
 	Tls   *Tls `json:"tls" form:"tls" gorm:"foreignKey:TlsId;references:Id"`
 
+	// 运行该服务的节点 (节点 ID / "group:分组" / "master")，为空表示所有节点
+	Nodes   json.RawMessage `json:"nodes" form:"nodes"`
 	Options json.RawMessage `json:"-" form:"-"`
 }
 
@@ -40,6 +42,10 @@ func (i *Service) UnmarshalJSON(data []byte) error {
 	delete(raw, "tls_id")
 	delete(raw, "tls")
 
+	// Nodes
+	i.Nodes, _ = json.MarshalIndent(raw["nodes"], "", "  ")
+	delete(raw, "nodes")
+
 	// Remaining fields
 	i.Options, err = json.MarshalIndent(raw, "", "  ")
 	return err
@@ -75,6 +81,7 @@ func (i Service) MarshalFull() (*map[string]interface{}, error) {
 	combined["type"] = i.Type
 	combined["tag"] = i.Tag
 	combined["tls_id"] = i.TlsId
+	combined["nodes"] = i.Nodes
 
 	if i.Options != nil {
 		var restFields map[string]interface{}
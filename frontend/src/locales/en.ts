@@ -192,6 +192,21 @@ export default {
     clashSub: "Clash Subscription",
     mixedPort: "Mixed Inbound Port",
     tun: "Tun Inbound",
+    externalSub: {
+      title: "External Subscriptions",
+      description: "Cached external subscriptions referenced by clients. Failed fetches keep serving the last successful result.",
+      noData: "No external subscription has been fetched yet",
+      status: "Status",
+      links: "Links",
+      fetchedAt: "Last Success",
+      checkedAt: "Last Check",
+      lastUsed: "Last Used",
+      duration: "Fetch Time",
+      failures: "Failures",
+      ok: "OK",
+      stale: "Stale",
+      error: "Error",
+    },
   },
   client: {
     name: "Name",
@@ -604,6 +619,35 @@ export default {
       "30days": "30 Days",
       never: "Never Expire",
     },
+    commands: "Commands",
+    command: "Command",
+    sendCommand: "Send",
+    commandQueued: "Command queued",
+    secret: "Node Secret",
+    signKey: "Config Signing Key",
+    signKeyDesc: "Workers pin this key at registration. Workers registered before config signing need it in SUI_MASTER_SIGN_KEY.",
+    secretDesc: "Rotate issues a new secret to the worker over its next heartbeat, no re-registration needed. Revoke (when the secret leaked) invalidates the secret and the node token, and returns a new token to configure on the worker.",
+    rotateSecret: "Rotate",
+    revokeSecret: "Revoke & Re-register",
+    secretRotated: "New secret will be delivered with the next heartbeat",
+    secretRevoked: "Secret revoked, configure the copied token on the worker and restart it",
+    actor: "Actor",
+    createdAt: "Created At",
+    finishedAt: "Finished At",
+    commandList: {
+      restartCore: "Restart Core",
+      restartApp: "Restart App",
+      resync: "Full Resync",
+      logs: "Logs",
+      keypair: "Generate Keypair",
+    },
+    commandStatus: {
+      pending: "Pending",
+      sent: "Sent",
+      success: "Success",
+      failed: "Failed",
+      expired: "Expired",
+    },
   },
   apiKey: {
     title: "API Key",
@@ -192,6 +192,21 @@ export default {
     clashSub: "Clash 订阅",
     mixedPort: "混合入站端口",
     tun: "Tun 入站",
+    externalSub: {
+      title: "外部订阅",
+      description: "客户端引用的外部订阅缓存。获取失败时继续使用上一次成功的结果。",
+      noData: "尚未获取过外部订阅",
+      status: "状态",
+      links: "链接数",
+      fetchedAt: "最近成功",
+      checkedAt: "最近检查",
+      lastUsed: "最近使用",
+      duration: "获取耗时",
+      failures: "连续失败",
+      ok: "正常",
+      stale: "已过期",
+      error: "失败",
+    },
   },
   client: {
     name: "名称",
@@ -604,6 +619,35 @@ export default {
       "30days": "30 天",
       never: "永不过期",
     },
+    commands: "远程命令",
+    command: "命令",
+    sendCommand: "发送",
+    commandQueued: "命令已加入队列",
+    secret: "节点密钥",
+    signKey: "配置签名公钥",
+    signKeyDesc: "从节点注册时固定该公钥；注册早于配置签名的从节点需要通过 SUI_MASTER_SIGN_KEY 指定。",
+    secretDesc: "轮换：新密钥在下一次心跳时下发给从节点，不需要重新注册。吊销 (密钥泄露时)：节点密钥和节点 token 立即失效，返回新的 token，需要配置到从节点。",
+    rotateSecret: "轮换",
+    revokeSecret: "吊销并重新注册",
+    secretRotated: "新密钥将在下一次心跳时下发",
+    secretRevoked: "密钥已吊销，请将已复制的 token 配置到从节点并重启",
+    actor: "操作者",
+    createdAt: "创建时间",
+    finishedAt: "完成时间",
+    commandList: {
+      restartCore: "重启内核",
+      restartApp: "重启面板",
+      resync: "全量同步",
+      logs: "日志",
+      keypair: "生成密钥对",
+    },
+    commandStatus: {
+      pending: "待下发",
+      sent: "已下发",
+      success: "成功",
+      failed: "失败",
+      expired: "已过期",
+    },
   },
   apiKey: {
     title: "API 密钥",
@@ -4,7 +4,7 @@ import { push } from 'notivue'
 import { i18n } from '@/locales'
 import { Inbound } from '@/types/inbounds'
 import { Client } from '@/types/clients'
-import { Node, NodeToken, NodeOnlines } from '@/types/node'
+import { Node, NodeToken, NodeOnlines, NodeCommand } from '@/types/node'
 import { ApiKey, WebhookConfig } from '@/types/apikey'
 
 const Data = defineStore('Data', {
@@ -24,9 +24,11 @@ const Data = defineStore('Data', {
     // 节点管理 (UAP)
     nodeMode: 'standalone' as 'standalone' | 'master' | 'worker',
     isReadOnly: false,
+    nodeSignKey: '',
     nodes: <Node[]>[],
     nodeTokens: <NodeToken[]>[],
     nodeOnlines: <NodeOnlines[]>[],
+    nodeCommands: <NodeCommand[]>[],
     // API Key 管理
     apiKeys: <ApiKey[]>[],
     webhookConfig: <WebhookConfig>{ callbackUrl: '', callbackSecret: '', enable: false },
@@ -155,6 +157,7 @@ const Data = defineStore('Data', {
       if (msg.success) {
         this.nodeMode = msg.obj.mode ?? 'standalone'
         this.isReadOnly = msg.obj.isReadOnly ?? false
+        this.nodeSignKey = msg.obj.signKey ?? ''
       }
     },
     async loadNodes(): Promise<void> {
@@ -213,6 +216,29 @@ const Data = defineStore('Data', {
       }
       return false
     },
+    async rotateNodeSecret(id: number): Promise<boolean> {
+      const msg = await HttpUtils.post('api/rotateNodeSecret', { id })
+      if (msg.success) {
+        push.success({
+          title: i18n.global.t('success'),
+          message: i18n.global.t('node.secretRotated')
+        })
+        return true
+      }
+      return false
+    },
+    async revokeNodeSecret(id: number): Promise<string | null> {
+      const msg = await HttpUtils.post('api/revokeNodeSecret', { id })
+      if (msg.success) {
+        push.success({
+          title: i18n.global.t('success'),
+          message: i18n.global.t('node.secretRevoked')
+        })
+        await this.loadNodes()
+        return msg.obj
+      }
+      return null
+    },
     async deleteNode(id: number): Promise<boolean> {
       const msg = await HttpUtils.post('api/save', {
         object: 'nodes',
@@ -229,6 +255,23 @@ const Data = defineStore('Data', {
       }
       return false
     },
+    async loadNodeCommands(nodeId: string): Promise<void> {
+      const msg = await HttpUtils.get('api/nodeCommands', nodeId ? { nodeId } : {})
+      if (msg.success) {
+        this.nodeCommands = msg.obj ?? []
+      }
+    },
+    async sendNodeCommand(nodeId: string, command: string, args: object): Promise<boolean> {
+      const msg = await HttpUtils.post('api/nodeCommand', { nodeId, command, args: JSON.stringify(args) })
+      if (msg.success) {
+        push.success({
+          title: i18n.global.t('success'),
+          message: i18n.global.t('node.commandQueued')
+        })
+        return true
+      }
+      return false
+    },
     async syncNode(id: number): Promise<boolean> {
       // TODO: implement sync endpoint
       push.info({ message: 'Sync not implemented yet' })
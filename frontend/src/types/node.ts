@@ -1,5 +1,6 @@
 export interface Node {
   id: number
+  nodeId: string
   name: string
   address: string
   externalHost?: string
@@ -23,6 +24,22 @@ export interface NodeToken {
   usedBy?: string
 }
 
+export interface NodeCommand {
+  id: number
+  nodeId: string
+  command: string
+  args?: any
+  status: 'pending' | 'sent' | 'success' | 'failed' | 'expired'
+  result?: string
+  actor?: string
+  createdAt: number
+  sentAt?: number
+  finishedAt?: number
+  deliveredAt?: number
+}
+
+export const nodeCommandList = ['restartCore', 'restartApp', 'resync', 'logs', 'keypair']
+
 export interface NodeOnlines {
   nodeId: number
   nodeName: string
@@ -48,3 +65,13 @@ export const getStatusIcon = (status: string): string => {
     default: return 'mdi-help-circle'
   }
 }
+
+export const getCommandStatusColor = (status: string): string => {
+  switch (status) {
+    case 'success': return 'success'
+    case 'failed': return 'error'
+    case 'sent': return 'info'
+    case 'pending': return 'warning'
+    default: return 'grey'
+  }
+}
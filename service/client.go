@@ -6,6 +6,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/alireza0/s-ui/config"
 	"github.com/alireza0/s-ui/database"
 	"github.com/alireza0/s-ui/database/model"
 	"github.com/alireza0/s-ui/logger"
@@ -82,7 +83,8 @@ func (s *ClientService) Save(tx *gorm.DB, act string, data json.RawMessage, host
 				return nil, err
 			}
 		}
-		err = tx.Save(&client).Error
+		// 订阅令牌只能通过 RotateSubToken 修改，避免面板提交的旧值恢复已失效的订阅链接
+		err = tx.Omit("sub_token").Save(&client).Error
 		if err != nil {
 			return nil, err
 		}
@@ -110,7 +112,7 @@ func (s *ClientService) Save(tx *gorm.DB, act string, data json.RawMessage, host
 		if err != nil {
 			return nil, err
 		}
-		err = tx.Save(clients).Error
+		err = tx.Omit("sub_token").Save(clients).Error
 		if err != nil {
 			return nil, err
 		}
@@ -336,6 +338,9 @@ func (s *ClientService) DepleteClients() ([]uint, error) {
 	defer func() {
 		if err == nil {
 			tx.Commit()
+			if len(changes) > 0 {
+				notifyConfigChanged()
+			}
 		} else {
 			tx.Rollback()
 		}
@@ -451,18 +456,40 @@ func (s *ClientService) GetByUUID(uuid string) (*model.Client, error) {
 
 // ========== UAP 时长追踪方法 ==========
 
-// UpdateOnlineTime 从 core TimeTracker 获取时长并累加到数据库
+const (
+	// onlineTimeInterval 在线时长采样间隔 (秒)，与 TimeTrackJob 的执行间隔一致
+	onlineTimeInterval = 10
+	// onlineTimeResource 从节点上报在线时长使用的统计资源类型 (Traffic 字段为秒数)
+	onlineTimeResource = "online_time"
+	// onlineTimeSlotAge 时间段去重记录的保留时长 (秒)，更晚到达的上报按 sum 处理
+	onlineTimeSlotAge = 3600
+)
+
+// UpdateOnlineTime 采样当前在线用户，从 core TimeTracker 获取时长并累加到数据库
+// 从节点同时写入待上报队列，由主节点按 onlineTimePolicy 合并
 func (s *ClientService) UpdateOnlineTime() error {
 	if !corePtr.IsRunning() {
 		return nil
 	}
 
+	box := corePtr.GetInstance()
+	var users []string
+	for _, conn := range box.ConnTracker().GetConnections() {
+		if conn.User != "" {
+			users = append(users, conn.User)
+		}
+	}
+	box.TimeTracker().UpdateOnlineTime(users, onlineTimeInterval)
+
 	// 获取并重置时长追踪数据
-	timeData := corePtr.GetInstance().TimeTracker().GetAndResetTime()
+	timeData := box.TimeTracker().GetAndResetTime()
 	if len(timeData) == 0 {
 		return nil
 	}
 
+	now := time.Now().Unix()
+	dateTime := now - now%onlineTimeInterval
+
 	db := database.GetDB()
 	tx := db.Begin()
 	var err error
@@ -474,6 +501,12 @@ func (s *ClientService) UpdateOnlineTime() error {
 		}
 	}()
 
+	if !config.IsWorker() {
+		err = s.AddOnlineTime(tx, dateTime, timeData)
+		return err
+	}
+
+	// 从节点本地记录仅供参考 (同步配置时以主节点为准)
 	for userName, seconds := range timeData {
 		err = tx.Model(&model.Client{}).
 			Where("name = ?", userName).
@@ -483,6 +516,131 @@ func (s *ClientService) UpdateOnlineTime() error {
 		}
 	}
 
+	outbox := make([]model.StatsOutbox, 0, len(timeData))
+	for userName, seconds := range timeData {
+		outbox = append(outbox, model.StatsOutbox{
+			DateTime: dateTime,
+			Resource: onlineTimeResource,
+			Tag:      userName,
+			Traffic:  seconds,
+		})
+	}
+	err = tx.Create(&outbox).Error
+	return err
+}
+
+// AddOnlineTime 累加用户在 dateTime 开始的采样时间段内的在线秒数
+// onlineTimePolicy 为 max (或旧值 union) 时，同一用户同一时间段只按各节点上报的最大秒数计
+func (s *ClientService) AddOnlineTime(tx *gorm.DB, dateTime int64, timeData map[string]int64) error {
+	var settingService SettingService
+	policy, _ := settingService.GetOnlineTimePolicy()
+	slotMax := policy != "sum" && config.IsMaster() && dateTime > time.Now().Unix()-onlineTimeSlotAge
+
+	for userName, seconds := range timeData {
+		if slotMax {
+			var slots []model.ClientTimeSlot
+			err := tx.Where("date_time = ? AND client_name = ?", dateTime, userName).Find(&slots).Error
+			if err != nil {
+				return err
+			}
+			if len(slots) > 0 {
+				if seconds <= slots[0].Seconds {
+					continue
+				}
+				err = tx.Model(&model.ClientTimeSlot{}).Where("id = ?", slots[0].Id).Update("seconds", seconds).Error
+				if err != nil {
+					return err
+				}
+				seconds -= slots[0].Seconds
+			} else {
+				err = tx.Create(&model.ClientTimeSlot{
+					DateTime:   dateTime,
+					ClientName: userName,
+					Seconds:    seconds,
+				}).Error
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		err := tx.Model(&model.Client{}).
+			Where("name = ?", userName).
+			UpdateColumn("time_used", gorm.Expr("time_used + ?", seconds)).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DelOldTimeSlots 清理过期的在线时长时间段记录
+func (s *ClientService) DelOldTimeSlots() error {
+	oldTime := time.Now().Unix() - onlineTimeSlotAge
+	db := database.GetDB()
+	return db.Where("date_time < ?", oldTime).Delete(&model.ClientTimeSlot{}).Error
+}
+
+// UpdateUserLimits 将 Client.SpeedLimit / DeviceLimit 同步到 core
+// 限制按用户共享，已建立的连接即时生效，无需重启 inbound
+func (s *ClientService) UpdateUserLimits() error {
+	if !corePtr.IsRunning() {
+		return nil
+	}
+
+	var clients []model.Client
+	db := database.GetDB()
+	err := db.Model(model.Client{}).Select("name, speed_limit, device_limit").
+		Where("enable = ? AND (speed_limit > 0 OR device_limit > 0)", true).Scan(&clients).Error
+	if err != nil {
+		return err
+	}
+
+	speedLimits := make(map[string]int, len(clients))
+	deviceLimits := make(map[string]int, len(clients))
+	for _, client := range clients {
+		speedLimits[client.Name] = client.SpeedLimit
+		deviceLimits[client.Name] = client.DeviceLimit
+	}
+
+	var settingService SettingService
+	policy, err := settingService.GetDeviceLimitPolicy()
+	if err != nil {
+		return err
+	}
+
+	instance := corePtr.GetInstance()
+	instance.SpeedLimiter().SetLimits(speedLimits)
+	instance.ConnTracker().SetDeviceLimits(deviceLimits, policy == "evict")
+	return nil
+}
+
+// AddDeviceRejected 累加因设备超限被拒绝的连接数
+func (s *ClientService) AddDeviceRejected(rejected map[string]int64) error {
+	if len(rejected) == 0 {
+		return nil
+	}
+
+	db := database.GetDB()
+	tx := db.Begin()
+	var err error
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+
+	for userName, count := range rejected {
+		err = tx.Model(&model.Client{}).
+			Where("name = ?", userName).
+			UpdateColumn("device_rejected", gorm.Expr("device_rejected + ?", count)).Error
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -498,6 +656,9 @@ func (s *ClientService) DepleteTimeExceededClients() ([]uint, []model.Client, er
 	defer func() {
 		if err == nil {
 			tx.Commit()
+			if len(changes) > 0 {
+				notifyConfigChanged()
+			}
 		} else {
 			tx.Rollback()
 		}
@@ -562,6 +723,9 @@ func (s *ClientService) ResetTrafficByStrategy() ([]model.Client, []uint, error)
 	defer func() {
 		if err == nil {
 			tx.Commit()
+			if len(clients) > 0 {
+				notifyConfigChanged()
+			}
 		} else {
 			tx.Rollback()
 		}
@@ -635,6 +799,9 @@ func (s *ClientService) ResetTimeByStrategy() ([]model.Client, []uint, error) {
 	defer func() {
 		if err == nil {
 			tx.Commit()
+			if len(clients) > 0 {
+				notifyConfigChanged()
+			}
 		} else {
 			tx.Rollback()
 		}
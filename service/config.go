@@ -26,6 +26,7 @@ type ConfigService struct {
 	ServicesService
 	EndpointService
 	NodeService
+	OverlayService
 }
 
 type SingBoxConfig struct {
@@ -75,6 +76,10 @@ func (s *ConfigService) GetConfig(data string) (*SingBoxConfig, error) {
 	if err != nil {
 		return nil, err
 	}
+	err = s.OverlayService.applyOverlays(database.GetDB(), &singboxConfig)
+	if err != nil {
+		return nil, err
+	}
 	return &singboxConfig, nil
 }
 
@@ -96,6 +101,9 @@ func (s *ConfigService) StartCore(defaultConfig string) error {
 		return err
 	}
 	logger.Info("sing-box started")
+	if err := s.ClientService.UpdateUserLimits(); err != nil {
+		logger.Warning("update user limits failed: ", err)
+	}
 	return nil
 }
 
@@ -133,9 +141,14 @@ func (s *ConfigService) Save(obj string, act string, data json.RawMessage, initU
 	defer func() {
 		if err == nil {
 			tx.Commit()
+			notifyConfigChanged()
 			// Try to start core if it is not running
 			if !corePtr.IsRunning() {
 				s.StartCore("")
+			} else if obj == "clients" || obj == "settings" {
+				if err := s.ClientService.UpdateUserLimits(); err != nil {
+					logger.Warning("update user limits failed: ", err)
+				}
 			}
 		} else {
 			tx.Rollback()
@@ -175,6 +188,8 @@ func (s *ConfigService) Save(obj string, act string, data json.RawMessage, initU
 		err = s.SettingService.Save(tx, data)
 	case "nodes":
 		err = s.NodeService.Save(tx, act, data)
+	case "nodeGroups":
+		err = s.NodeService.SaveNodeGroup(tx, act, data)
 	default:
 		return nil, common.NewError("unknown object: ", obj)
 	}
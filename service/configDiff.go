@@ -0,0 +1,369 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/alireza0/s-ui/database"
+	"github.com/alireza0/s-ui/database/model"
+
+	"gorm.io/gorm"
+)
+
+// syncTables 从节点同步的数据表，按 id 增量更新
+var syncTables = []string{"tls", "inbounds", "outbounds", "endpoints", "services", "clients"}
+
+// syncVolatileFields 随统计不断变化、不影响从节点运行的字段，不参与版本计算
+var syncVolatileFields = map[string][]string{
+	"clients": {"up", "down", "timeUsed", "deviceRejected", "timeResetAt", "trafficResetAt"},
+}
+
+// maxConfigSnapshots 主节点保留的历史版本快照数，从节点的版本不在其中时需全量同步
+const maxConfigSnapshots = 32
+
+// configVersionTTL 缓存的配置版本最长使用时间，兜底没有更新 LastUpdate 的变更
+const configVersionTTL = time.Minute
+
+// configSnapshot 某个配置版本下每一行的摘要，用于计算增量
+// version 为所有摘要的哈希，配置内容不变时版本不变 (与主节点重启无关)
+type configSnapshot struct {
+	version string
+	rows    map[string]map[uint][32]byte
+	config  [32]byte
+}
+
+// cachedConfigVersion 节点当前配置版本的缓存，LastUpdate 变化或超过 configVersionTTL 后重新计算
+type cachedConfigVersion struct {
+	version    string
+	lastUpdate int64
+	computedAt time.Time
+}
+
+var (
+	// 节点 ID -> 历史快照 (每个节点的配置按分配范围渲染，快照分别保存)
+	// 快照只保存在内存中，主节点重启后从节点的第一次同步为全量
+	configSnapshots = make(map[string][]*configSnapshot)
+	// 节点 ID -> 当前配置版本
+	configVersions       = make(map[string]cachedConfigVersion)
+	configSnapshotsMutex sync.Mutex
+)
+
+// syncRows 按表组织的同步数据 (表名 -> id -> 行)
+type syncRows map[string]map[uint]json.RawMessage
+
+// GetConfigVersion 获取节点当前的配置版本 (渲染后配置内容的哈希)
+// LastUpdate 只作为变更通知，版本只在分配给该节点的配置实际变化时改变
+func (s *NodeService) GetConfigVersion(nodeId string) (string, error) {
+	lastUpdate := LastUpdate
+	configSnapshotsMutex.Lock()
+	cached, ok := configVersions[nodeId]
+	configSnapshotsMutex.Unlock()
+	if ok && cached.lastUpdate == lastUpdate && time.Since(cached.computedAt) < configVersionTTL {
+		return cached.version, nil
+	}
+
+	node, err := s.GetNodeByNodeId(nodeId)
+	if err != nil {
+		return "", err
+	}
+	rows, configData, err := s.loadSyncRows(database.GetDB(), node.NodeId, node.Group)
+	if err != nil {
+		return "", err
+	}
+	current := newConfigSnapshot(rows, configData)
+	cacheConfigVersion(nodeId, current.version, lastUpdate)
+	return current.version, nil
+}
+
+// GetConfigDiff 获取指定节点从 since 版本到当前版本的配置增量 (从节点同步用)
+// 只包含分配给该节点的入站、出站、端点和服务
+// since 为空或历史快照已不存在时返回全量配置 (full = true)
+func (s *NodeService) GetConfigDiff(nodeId string, since string) (map[string]interface{}, error) {
+	lastUpdate := LastUpdate
+	node, err := s.GetNodeByNodeId(nodeId)
+	if err != nil {
+		return nil, err
+	}
+	rows, configData, err := s.loadSyncRows(database.GetDB(), node.NodeId, node.Group)
+	if err != nil {
+		return nil, err
+	}
+
+	current := newConfigSnapshot(rows, configData)
+	cacheConfigVersion(nodeId, current.version, lastUpdate)
+	base := saveConfigSnapshot(nodeId, current, since)
+	if base == nil {
+		return fullSyncConfig(current.version, lastUpdate, rows, configData), nil
+	}
+
+	changed := make(map[string][]json.RawMessage)
+	deleted := make(map[string][]uint)
+	for _, table := range syncTables {
+		for _, id := range sortedIds(rows[table]) {
+			if hash, ok := base.rows[table][id]; !ok || hash != current.rows[table][id] {
+				changed[table] = append(changed[table], rows[table][id])
+			}
+		}
+		for id := range base.rows[table] {
+			if _, ok := rows[table][id]; !ok {
+				deleted[table] = append(deleted[table], id)
+			}
+		}
+	}
+
+	// version 为旧版从节点使用的 LastUpdate 时间戳
+	result := map[string]interface{}{
+		"full":    false,
+		"hash":    current.version,
+		"version": lastUpdate,
+		"since":   since,
+		"changed": changed,
+		"deleted": deleted,
+	}
+	if base.config != current.config {
+		result["config"] = configData
+	}
+	return result, nil
+}
+
+// loadSyncRows 读取分配给指定节点的同步数据，行格式保留 id 等数据库字段
+func (s *NodeService) loadSyncRows(db *gorm.DB, nodeId string, group string) (syncRows, json.RawMessage, error) {
+	rows := make(syncRows)
+	for _, table := range syncTables {
+		rows[table] = make(map[uint]json.RawMessage)
+	}
+
+	var inbounds []model.Inbound
+	if err := db.Find(&inbounds).Error; err != nil {
+		return nil, nil, err
+	}
+	for _, inbound := range inbounds {
+		if !InNodeScope(inbound.Nodes, nodeId, group) {
+			continue
+		}
+		full, err := inbound.MarshalFull()
+		if err != nil {
+			return nil, nil, err
+		}
+		if rows["inbounds"][inbound.Id], err = json.Marshal(full); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var services []model.Service
+	if err := db.Find(&services).Error; err != nil {
+		return nil, nil, err
+	}
+	for _, service := range services {
+		if !InNodeScope(service.Nodes, nodeId, group) {
+			continue
+		}
+		full, err := service.MarshalFull()
+		if err != nil {
+			return nil, nil, err
+		}
+		if rows["services"][service.Id], err = json.Marshal(full); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var outbounds []model.Outbound
+	if err := db.Find(&outbounds).Error; err != nil {
+		return nil, nil, err
+	}
+	for _, outbound := range outbounds {
+		if !InNodeScope(outbound.Nodes, nodeId, group) {
+			continue
+		}
+		data, err := marshalSyncRow(outbound.Options, map[string]interface{}{
+			"id":    outbound.Id,
+			"type":  outbound.Type,
+			"tag":   outbound.Tag,
+			"nodes": outbound.Nodes,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		rows["outbounds"][outbound.Id] = data
+	}
+
+	// Endpoint.MarshalJSON 会把 warp 转换为 wireguard，这里需要保留原始类型
+	var endpoints []model.Endpoint
+	if err := db.Find(&endpoints).Error; err != nil {
+		return nil, nil, err
+	}
+	for _, endpoint := range endpoints {
+		if !InNodeScope(endpoint.Nodes, nodeId, group) {
+			continue
+		}
+		data, err := marshalSyncRow(endpoint.Options, map[string]interface{}{
+			"id":    endpoint.Id,
+			"type":  endpoint.Type,
+			"tag":   endpoint.Tag,
+			"ext":   endpoint.Ext,
+			"nodes": endpoint.Nodes,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		rows["endpoints"][endpoint.Id] = data
+	}
+
+	var clients []model.Client
+	if err := db.Find(&clients).Error; err != nil {
+		return nil, nil, err
+	}
+	for _, client := range clients {
+		data, err := json.Marshal(client)
+		if err != nil {
+			return nil, nil, err
+		}
+		rows["clients"][client.Id] = data
+	}
+
+	var tlsConfigs []model.Tls
+	if err := db.Find(&tlsConfigs).Error; err != nil {
+		return nil, nil, err
+	}
+	for _, tls := range tlsConfigs {
+		data, err := json.Marshal(tls)
+		if err != nil {
+			return nil, nil, err
+		}
+		rows["tls"][tls.Id] = data
+	}
+
+	var settingService SettingService
+	configStr, err := settingService.GetConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return rows, json.RawMessage(configStr), nil
+}
+
+// marshalSyncRow 合并固定字段和 Options 中的动态字段
+func marshalSyncRow(options json.RawMessage, fixed map[string]interface{}) (json.RawMessage, error) {
+	combined := make(map[string]interface{})
+	if options != nil {
+		if err := json.Unmarshal(options, &combined); err != nil {
+			return nil, err
+		}
+	}
+	for k, v := range fixed {
+		combined[k] = v
+	}
+	return json.Marshal(combined)
+}
+
+// fullSyncConfig 生成全量同步数据
+func fullSyncConfig(version string, lastUpdate int64, rows syncRows, configData json.RawMessage) map[string]interface{} {
+	result := map[string]interface{}{
+		"full":    true,
+		"hash":    version,
+		"version": lastUpdate,
+		"config":  configData,
+	}
+	for _, table := range syncTables {
+		list := make([]json.RawMessage, 0, len(rows[table]))
+		for _, id := range sortedIds(rows[table]) {
+			list = append(list, rows[table][id])
+		}
+		result[table] = list
+	}
+	return result
+}
+
+func newConfigSnapshot(rows syncRows, configData json.RawMessage) *configSnapshot {
+	snapshot := &configSnapshot{
+		rows:   make(map[string]map[uint][32]byte, len(rows)),
+		config: sha256.Sum256(configData),
+	}
+
+	// 按固定顺序汇总各行摘要得到版本
+	h := sha256.New()
+	var id [8]byte
+	for _, table := range syncTables {
+		hashes := make(map[uint][32]byte, len(rows[table]))
+		h.Write([]byte(table))
+		for _, rowId := range sortedIds(rows[table]) {
+			hash := syncRowHash(table, rows[table][rowId])
+			hashes[rowId] = hash
+			binary.BigEndian.PutUint64(id[:], uint64(rowId))
+			h.Write(id[:])
+			h.Write(hash[:])
+		}
+		snapshot.rows[table] = hashes
+	}
+	h.Write(snapshot.config[:])
+	snapshot.version = hex.EncodeToString(h.Sum(nil)[:16])
+	return snapshot
+}
+
+// syncRowHash 计算一行同步数据的摘要，忽略 syncVolatileFields 中的字段
+func syncRowHash(table string, data json.RawMessage) [32]byte {
+	if fields := syncVolatileFields[table]; len(fields) > 0 {
+		var row map[string]json.RawMessage
+		if json.Unmarshal(data, &row) == nil {
+			for _, field := range fields {
+				delete(row, field)
+			}
+			if stripped, err := json.Marshal(row); err == nil {
+				data = stripped
+			}
+		}
+	}
+	return sha256.Sum256(data)
+}
+
+// cacheConfigVersion 记录节点当前的配置版本
+func cacheConfigVersion(nodeId string, version string, lastUpdate int64) {
+	configSnapshotsMutex.Lock()
+	defer configSnapshotsMutex.Unlock()
+	configVersions[nodeId] = cachedConfigVersion{
+		version:    version,
+		lastUpdate: lastUpdate,
+		computedAt: time.Now(),
+	}
+}
+
+// saveConfigSnapshot 记录当前版本快照并返回 since 版本的快照 (不存在时返回 nil)
+// 版本由内容决定，同一版本的快照相同，只保留一份
+func saveConfigSnapshot(nodeId string, current *configSnapshot, since string) *configSnapshot {
+	configSnapshotsMutex.Lock()
+	defer configSnapshotsMutex.Unlock()
+
+	snapshots := configSnapshots[nodeId]
+	var base *configSnapshot
+	exists := false
+	for _, snapshot := range snapshots {
+		if snapshot.version == current.version {
+			exists = true
+		}
+		if since != "" && snapshot.version == since {
+			base = snapshot
+		}
+	}
+	if !exists {
+		snapshots = append(snapshots, current)
+		if len(snapshots) > maxConfigSnapshots {
+			snapshots = snapshots[len(snapshots)-maxConfigSnapshots:]
+		}
+		configSnapshots[nodeId] = snapshots
+	}
+	return base
+}
+
+func sortedIds(rows map[uint]json.RawMessage) []uint {
+	ids := make([]uint, 0, len(rows))
+	for id := range rows {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
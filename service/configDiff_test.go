@@ -0,0 +1,127 @@
+package service
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+)
+
+func testSyncRows() syncRows {
+	return syncRows{
+		"inbounds": {
+			1: json.RawMessage(`{"id":1,"tag":"vless-in","listen_port":443}`),
+			2: json.RawMessage(`{"id":2,"tag":"trojan-in","listen_port":8443}`),
+		},
+		"clients": {
+			1: json.RawMessage(`{"id":1,"name":"alice","up":100,"down":200,"timeUsed":30}`),
+		},
+	}
+}
+
+func TestConfigSnapshotVersion(t *testing.T) {
+	config := json.RawMessage(`{"log":{"level":"info"}}`)
+	base := newConfigSnapshot(testSyncRows(), config)
+
+	tests := []struct {
+		name   string
+		modify func(rows syncRows) json.RawMessage
+		same   bool
+	}{
+		{"unchanged", func(rows syncRows) json.RawMessage { return config }, true},
+		{"traffic counters", func(rows syncRows) json.RawMessage {
+			rows["clients"][1] = json.RawMessage(`{"id":1,"name":"alice","up":500,"down":900,"timeUsed":60}`)
+			return config
+		}, true},
+		{"key order", func(rows syncRows) json.RawMessage {
+			rows["clients"][1] = json.RawMessage(`{"down":200,"id":1,"name":"alice","timeUsed":30,"up":100}`)
+			return config
+		}, true},
+		{"client changed", func(rows syncRows) json.RawMessage {
+			rows["clients"][1] = json.RawMessage(`{"id":1,"name":"alice","up":100,"down":200,"timeUsed":30,"enable":false}`)
+			return config
+		}, false},
+		{"inbound changed", func(rows syncRows) json.RawMessage {
+			rows["inbounds"][2] = json.RawMessage(`{"id":2,"tag":"trojan-in","listen_port":9443}`)
+			return config
+		}, false},
+		{"row added", func(rows syncRows) json.RawMessage {
+			rows["outbounds"] = map[uint]json.RawMessage{1: json.RawMessage(`{"id":1,"tag":"direct"}`)}
+			return config
+		}, false},
+		{"row deleted", func(rows syncRows) json.RawMessage {
+			delete(rows["inbounds"], 2)
+			return config
+		}, false},
+		{"row moved to another id", func(rows syncRows) json.RawMessage {
+			rows["inbounds"][3] = rows["inbounds"][2]
+			delete(rows["inbounds"], 2)
+			return config
+		}, false},
+		{"config changed", func(rows syncRows) json.RawMessage {
+			return json.RawMessage(`{"log":{"level":"debug"}}`)
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows := testSyncRows()
+			current := newConfigSnapshot(rows, tt.modify(rows))
+			if (current.version == base.version) != tt.same {
+				t.Fatalf("version %s, base %s, want same = %t", current.version, base.version, tt.same)
+			}
+		})
+	}
+}
+
+func TestSaveConfigSnapshot(t *testing.T) {
+	const nodeId = "config-diff-test"
+	t.Cleanup(func() {
+		configSnapshotsMutex.Lock()
+		delete(configSnapshots, nodeId)
+		configSnapshotsMutex.Unlock()
+	})
+
+	// snapshot(i) 为第 i 个配置版本
+	snapshot := func(i int) *configSnapshot {
+		return newConfigSnapshot(testSyncRows(), json.RawMessage(`{"serial":`+strconv.Itoa(i)+`}`))
+	}
+	first, second := snapshot(0), snapshot(1)
+
+	tests := []struct {
+		name    string
+		current *configSnapshot
+		since   string
+		want    *configSnapshot
+	}{
+		{"first sync", first, "", nil},
+		{"unknown since", second, "unknown", nil},
+		{"same version", second, second.version, second},
+		{"previous version", snapshot(2), first.version, first},
+		{"older version still kept", snapshot(3), second.version, second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := saveConfigSnapshot(nodeId, tt.current, tt.since)
+			if got != tt.want {
+				t.Fatalf("saveConfigSnapshot() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// 超过 maxConfigSnapshots 个版本后最早的快照被淘汰，只能全量同步
+	for i := 4; i < maxConfigSnapshots+4; i++ {
+		saveConfigSnapshot(nodeId, snapshot(i), "")
+	}
+	if got := saveConfigSnapshot(nodeId, snapshot(maxConfigSnapshots+4), first.version); got != nil {
+		t.Fatalf("evicted snapshot returned: %v", got)
+	}
+	if got := saveConfigSnapshot(nodeId, snapshot(maxConfigSnapshots+4), snapshot(maxConfigSnapshots+3).version); got == nil {
+		t.Fatal("recent snapshot not found")
+	}
+
+	configSnapshotsMutex.Lock()
+	count := len(configSnapshots[nodeId])
+	configSnapshotsMutex.Unlock()
+	if count != maxConfigSnapshots {
+		t.Fatalf("kept %d snapshots, want %d", count, maxConfigSnapshots)
+	}
+}
@@ -25,10 +25,11 @@ func (o *EndpointService) GetAll() (*[]map[string]interface{}, error) {
 	var data []map[string]interface{}
 	for _, endpoint := range endpoints {
 		epData := map[string]interface{}{
-			"id":   endpoint.Id,
-			"type": endpoint.Type,
-			"tag":  endpoint.Tag,
-			"ext":  endpoint.Ext,
+			"id":    endpoint.Id,
+			"type":  endpoint.Type,
+			"tag":   endpoint.Tag,
+			"ext":   endpoint.Ext,
+			"nodes": endpoint.Nodes,
 		}
 		if endpoint.Options != nil {
 			var restFields map[string]json.RawMessage
@@ -52,6 +53,9 @@ func (o *EndpointService) GetAllConfig(db *gorm.DB) ([]json.RawMessage, error) {
 		return nil, err
 	}
 	for _, endpoint := range endpoints {
+		if !runsLocally(endpoint.Nodes) {
+			continue
+		}
 		endpointJson, err := endpoint.MarshalJSON()
 		if err != nil {
 			return nil, err
@@ -107,9 +111,11 @@ func (s *EndpointService) Save(tx *gorm.DB, act string, data json.RawMessage) er
 					return err
 				}
 			}
-			err = corePtr.AddEndpoint(configData)
-			if err != nil {
-				return err
+			if runsLocally(endpoint.Nodes) {
+				err = corePtr.AddEndpoint(configData)
+				if err != nil {
+					return err
+				}
 			}
 		}
 
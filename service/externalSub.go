@@ -0,0 +1,247 @@
+package service
+
+import (
+	"crypto/tls"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alireza0/s-ui/logger"
+	"github.com/alireza0/s-ui/util"
+	"github.com/alireza0/s-ui/util/common"
+)
+
+// 外部订阅缓存
+// 客户端的 sub 类型链接指向外部订阅，按 TTL 缓存获取结果：过期后继续返回旧结果并在后台刷新 (stale-while-revalidate)，
+// 获取失败时保留上一次成功的结果。首次获取同步等待，最长为超时时间；长时间未使用的订阅不再刷新并从缓存中移除
+
+// externalSubIdle 超过该时长未被订阅使用的外部订阅从缓存中移除
+const externalSubIdle = 24 * time.Hour
+
+// externalSubEntry 一个外部订阅的缓存和获取状态
+type externalSubEntry struct {
+	links      []string
+	fetchedAt  time.Time // 最近一次成功获取的时间
+	checkedAt  time.Time // 最近一次获取的时间 (无论成功与否)
+	lastUsed   time.Time
+	lastError  string
+	failures   int // 连续失败次数
+	duration   time.Duration
+	refreshing bool
+	ready      chan struct{} // 首次获取完成后关闭
+}
+
+var (
+	externalSubs      = make(map[string]*externalSubEntry)
+	externalSubsMutex sync.Mutex
+)
+
+// 获取外部订阅共用的连接池，按是否校验证书分开，避免每次获取都新建 Transport 而遗留空闲连接
+var (
+	externalSubTransport = &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		IdleConnTimeout: 90 * time.Second,
+	}
+	externalSubInsecureTransport = &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		IdleConnTimeout: 90 * time.Second,
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}
+)
+
+// ExternalSubStatus 外部订阅的健康状态 (面板展示)
+type ExternalSubStatus struct {
+	URL       string `json:"url"`
+	Links     int    `json:"links"`
+	FetchedAt int64  `json:"fetchedAt"`
+	CheckedAt int64  `json:"checkedAt"`
+	LastUsed  int64  `json:"lastUsed"`
+	LastError string `json:"lastError,omitempty"`
+	Failures  int    `json:"failures"`
+	Duration  int64  `json:"duration"` // 最近一次获取耗时 (毫秒)
+	Stale     bool   `json:"stale"`
+}
+
+// externalSubOptions 获取外部订阅的设置
+type externalSubOptions struct {
+	ttl      time.Duration
+	timeout  time.Duration
+	maxSize  int64
+	insecure bool
+}
+
+type ExternalSubService struct {
+}
+
+// getOptions 读取设置
+func (s *ExternalSubService) getOptions() externalSubOptions {
+	var settingService SettingService
+	options := externalSubOptions{
+		ttl:     10 * time.Minute,
+		timeout: 10 * time.Second,
+		maxSize: 1 << 20,
+	}
+	if ttl, err := settingService.getInt("subExternalTTL"); err == nil && ttl > 0 {
+		options.ttl = time.Duration(ttl) * time.Second
+	}
+	if timeout, err := settingService.getInt("subExternalTimeout"); err == nil && timeout > 0 {
+		options.timeout = time.Duration(timeout) * time.Second
+	}
+	if maxSize, err := settingService.getInt("subExternalMaxSize"); err == nil && maxSize > 0 {
+		options.maxSize = int64(maxSize)
+	}
+	options.insecure, _ = settingService.getBool("subExternalInsecure")
+	return options
+}
+
+// GetExternalLinks 获取外部订阅中的链接
+func (s *ExternalSubService) GetExternalLinks(url string) []string {
+	options := s.getOptions()
+	now := time.Now()
+
+	externalSubsMutex.Lock()
+	entry, exists := externalSubs[url]
+	if !exists {
+		entry = &externalSubEntry{ready: make(chan struct{})}
+		externalSubs[url] = entry
+	}
+	entry.lastUsed = now
+	cold := entry.fetchedAt.IsZero() && entry.checkedAt.IsZero()
+	stale := now.Sub(entry.checkedAt) >= options.ttl
+	if (!exists || stale) && !entry.refreshing {
+		entry.refreshing = true
+		go s.refresh(url, entry, options)
+	}
+	ready := entry.ready
+	externalSubsMutex.Unlock()
+
+	// 首次获取时等待结果，最长为超时时间
+	if cold {
+		select {
+		case <-ready:
+		case <-time.After(options.timeout):
+		}
+	}
+
+	externalSubsMutex.Lock()
+	defer externalSubsMutex.Unlock()
+	return entry.links
+}
+
+// refresh 获取外部订阅并更新缓存，失败时保留旧结果
+func (s *ExternalSubService) refresh(url string, entry *externalSubEntry, options externalSubOptions) {
+	start := time.Now()
+	links, err := fetchExternalSub(url, options)
+
+	externalSubsMutex.Lock()
+	defer externalSubsMutex.Unlock()
+	entry.refreshing = false
+	entry.checkedAt = time.Now()
+	entry.duration = entry.checkedAt.Sub(start)
+	if err != nil {
+		entry.failures++
+		entry.lastError = err.Error()
+		logger.Warning("sub: fetching external subscription failed (", entry.failures, " times): ", err)
+	} else {
+		entry.links = links
+		entry.fetchedAt = entry.checkedAt
+		entry.failures = 0
+		entry.lastError = ""
+	}
+	select {
+	case <-entry.ready:
+	default:
+		close(entry.ready)
+	}
+}
+
+// fetchExternalSub 获取并解析外部订阅，限制超时和响应大小，默认校验证书
+func fetchExternalSub(url string, options externalSubOptions) ([]string, error) {
+	transport := externalSubTransport
+	if options.insecure {
+		transport = externalSubInsecureTransport
+	}
+	client := &http.Client{
+		Timeout:   options.timeout,
+		Transport: transport,
+	}
+	response, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, common.NewErrorf("unexpected status: %s", response.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, options.maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > options.maxSize {
+		return nil, common.NewErrorf("response exceeds %d bytes", options.maxSize)
+	}
+
+	// Convert if the content is Base64 encoded
+	var links []string
+	for _, link := range strings.Split(util.StrOrBase64Encoded(string(body)), "\n") {
+		if link = strings.TrimSpace(link); link != "" {
+			links = append(links, link)
+		}
+	}
+	return links, nil
+}
+
+// RefreshExternalSubs 后台刷新过期的外部订阅，移除长时间未使用的订阅
+func (s *ExternalSubService) RefreshExternalSubs() {
+	options := s.getOptions()
+	now := time.Now()
+
+	externalSubsMutex.Lock()
+	defer externalSubsMutex.Unlock()
+	for url, entry := range externalSubs {
+		if now.Sub(entry.lastUsed) >= externalSubIdle {
+			delete(externalSubs, url)
+			continue
+		}
+		if !entry.refreshing && now.Sub(entry.checkedAt) >= options.ttl {
+			entry.refreshing = true
+			go s.refresh(url, entry, options)
+		}
+	}
+}
+
+// GetExternalSubStatus 获取缓存中外部订阅的健康状态
+func (s *ExternalSubService) GetExternalSubStatus() []ExternalSubStatus {
+	ttl := s.getOptions().ttl
+	now := time.Now()
+
+	externalSubsMutex.Lock()
+	defer externalSubsMutex.Unlock()
+	result := make([]ExternalSubStatus, 0, len(externalSubs))
+	for url, entry := range externalSubs {
+		status := ExternalSubStatus{
+			URL:       url,
+			Links:     len(entry.links),
+			LastUsed:  entry.lastUsed.Unix(),
+			LastError: entry.lastError,
+			Failures:  entry.failures,
+			Duration:  entry.duration.Milliseconds(),
+			Stale:     entry.fetchedAt.IsZero() || now.Sub(entry.fetchedAt) >= ttl,
+		}
+		if !entry.fetchedAt.IsZero() {
+			status.FetchedAt = entry.fetchedAt.Unix()
+		}
+		if !entry.checkedAt.IsZero() {
+			status.CheckedAt = entry.checkedAt.Unix()
+		}
+		result = append(result, status)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].URL < result[j].URL
+	})
+	return result
+}
@@ -59,6 +59,7 @@ func (s *InboundService) GetAll() (*[]map[string]interface{}, error) {
 			"type":   inbound.Type,
 			"tag":    inbound.Tag,
 			"tls_id": inbound.TlsId,
+			"nodes":  inbound.Nodes,
 		}
 		if inbound.Options != nil {
 			var restFields map[string]json.RawMessage
@@ -131,7 +132,10 @@ func (s *InboundService) Save(tx *gorm.DB, act string, data json.RawMessage, ini
 					return err
 				}
 			}
+		}
 
+		// 未分配给本机的入站只保存配置，不加入 core
+		if corePtr.IsRunning() && runsLocally(inbound.Nodes) {
 			inboundConfig, err := inbound.MarshalJSON()
 			if err != nil {
 				return err
@@ -229,6 +233,9 @@ func (s *InboundService) GetAllConfig(db *gorm.DB) ([]json.RawMessage, error) {
 		return nil, err
 	}
 	for _, inbound := range inbounds {
+		if !runsLocally(inbound.Nodes) {
+			continue
+		}
 		inboundJson, err := inbound.MarshalJSON()
 		if err != nil {
 			return nil, err
@@ -344,6 +351,9 @@ func (s *InboundService) RestartInbounds(tx *gorm.DB, ids []uint) error {
 		}
 		// Close all existing connections
 		corePtr.GetInstance().ConnTracker().CloseConnByInbound(inbound.Tag)
+		if !runsLocally(inbound.Nodes) {
+			continue
+		}
 
 		inboundConfig, err := inbound.MarshalJSON()
 		if err != nil {
@@ -0,0 +1,25 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alireza0/s-ui/database"
+	"github.com/alireza0/s-ui/logger"
+
+	"github.com/op/go-logging"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitLogger(logging.WARNING)
+	os.Exit(m.Run())
+}
+
+// initTestDB 在临时目录中初始化数据库，每个测试使用独立的数据库
+func initTestDB(t *testing.T) {
+	t.Helper()
+	if err := database.InitDB(filepath.Join(t.TempDir(), "s-ui.db")); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,11 +1,16 @@
 package service
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/alireza0/s-ui/config"
 	"github.com/alireza0/s-ui/database"
 	"github.com/alireza0/s-ui/database/model"
 	"github.com/alireza0/s-ui/logger"
@@ -19,19 +24,49 @@ type NodeService struct{}
 
 // ========== Token 管理 ==========
 
+// NodeTokenOptions 邀请码的使用次数、节点 ID 前缀和注册节点的默认属性
+type NodeTokenOptions struct {
+	MaxUses      int
+	NodeIdPrefix string
+	Group        string
+	Country      string
+	Flag         string
+}
+
 // GenerateToken 生成节点邀请码
-func (s *NodeService) GenerateToken(name string, expiresAt int64) (*model.NodeToken, error) {
+// MaxUses 大于 1 或为 0 (不限) 的邀请码可供自动扩容的节点共用
+func (s *NodeService) GenerateToken(name string, expiresAt int64, options NodeTokenOptions) (*model.NodeToken, error) {
+	if options.MaxUses < 0 {
+		return nil, common.NewError("invalid max uses: ", options.MaxUses)
+	}
+	err := s.ValidateNodeGroups(NodeGroupNames(options.Group))
+	if err != nil {
+		return nil, err
+	}
 	token := generateSecureToken(32)
 	nodeToken := &model.NodeToken{
-		Token:     token,
-		Name:      name,
-		ExpiresAt: expiresAt,
+		Token:        token,
+		Name:         name,
+		ExpiresAt:    expiresAt,
+		MaxUses:      options.MaxUses,
+		NodeIdPrefix: options.NodeIdPrefix,
+		Group:        options.Group,
+		Country:      options.Country,
+		Flag:         options.Flag,
 	}
 	db := database.GetDB()
-	err := db.Create(nodeToken).Error
+	err = db.Create(nodeToken).Error
 	if err != nil {
 		return nil, err
 	}
+	// 零值不会写入，创建时使用了列默认值 1
+	if options.MaxUses == 0 {
+		nodeToken.MaxUses = 0
+		err = db.Model(nodeToken).Update("max_uses", 0).Error
+		if err != nil {
+			return nil, err
+		}
+	}
 	return nodeToken, nil
 }
 
@@ -52,8 +87,49 @@ func (s *NodeService) DeleteToken(id uint) error {
 	return db.Where("id = ?", id).Delete(&model.NodeToken{}).Error
 }
 
+// RevokeToken 吊销邀请码，disableNodes 为 true 时同时禁用用它注册的所有节点
+func (s *NodeService) RevokeToken(id uint, disableNodes bool) error {
+	var err error
+	db := database.GetDB()
+	tx := db.Begin()
+	defer func() {
+		if err == nil {
+			tx.Commit()
+		} else {
+			tx.Rollback()
+		}
+	}()
+
+	var nodeToken model.NodeToken
+	if err = tx.Where("id = ?", id).First(&nodeToken).Error; err != nil {
+		return err
+	}
+	if err = tx.Model(&model.NodeToken{}).Where("id = ?", id).Update("revoked", true).Error; err != nil {
+		return err
+	}
+	if disableNodes {
+		result := tx.Model(&model.Node{}).Where("token_id = ?", nodeToken.Id).Update("enable", false)
+		if err = result.Error; err != nil {
+			return err
+		}
+		logger.Info("Node token revoked, disabled ", result.RowsAffected, " nodes")
+	}
+	return nil
+}
+
+// GetTokenNodes 获取使用邀请码注册的节点
+func (s *NodeService) GetTokenNodes(id uint) ([]model.Node, error) {
+	db := database.GetDB()
+	nodes := []model.Node{}
+	err := db.Where("token_id = ?", id).Order("created_at DESC").Find(&nodes).Error
+	if err != nil {
+		return nil, err
+	}
+	return nodes, nil
+}
+
 // ValidateToken 验证邀请码有效性
-func (s *NodeService) ValidateToken(token string) (*model.NodeToken, error) {
+func (s *NodeService) ValidateToken(token string, nodeId string) (*model.NodeToken, error) {
 	db := database.GetDB()
 	var nodeToken model.NodeToken
 	err := db.Where("token = ?", token).First(&nodeToken).Error
@@ -61,11 +137,20 @@ func (s *NodeService) ValidateToken(token string) (*model.NodeToken, error) {
 		return nil, common.NewError("invalid token")
 	}
 
-	// 检查是否已使用
+	if nodeToken.Revoked {
+		return nil, common.NewError("token revoked")
+	}
+
+	// 检查是否已用满
 	if nodeToken.Used {
 		return nil, common.NewError("token already used")
 	}
 
+	// 检查节点 ID 前缀
+	if !strings.HasPrefix(nodeId, nodeToken.NodeIdPrefix) {
+		return nil, common.NewError("node id must start with ", nodeToken.NodeIdPrefix)
+	}
+
 	// 检查是否过期
 	if nodeToken.ExpiresAt > 0 && nodeToken.ExpiresAt < time.Now().Unix() {
 		return nil, common.NewError("token expired")
@@ -74,12 +159,24 @@ func (s *NodeService) ValidateToken(token string) (*model.NodeToken, error) {
 	return &nodeToken, nil
 }
 
-// MarkTokenUsed 标记 Token 为已使用
+// MarkTokenUsed 记录一次邀请码使用，达到可注册次数后标记为已用满
+// 条件更新保证并发注册不会超过可注册次数
 func (s *NodeService) MarkTokenUsed(tx *gorm.DB, tokenId uint, nodeId string) error {
-	return tx.Model(&model.NodeToken{}).Where("id = ?", tokenId).Updates(map[string]interface{}{
-		"used":    true,
-		"used_by": nodeId,
-	}).Error
+	result := tx.Model(&model.NodeToken{}).
+		Where("id = ? AND used = ? AND revoked = ?", tokenId, false, false).
+		Where("max_uses = 0 OR use_count < max_uses").
+		Updates(map[string]interface{}{
+			"use_count": gorm.Expr("use_count + 1"),
+			"used":      gorm.Expr("max_uses > 0 AND use_count + 1 >= max_uses"),
+			"used_by":   nodeId,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return common.NewError("token already used")
+	}
+	return nil
 }
 
 // ========== Node 管理 ==========
@@ -132,7 +229,7 @@ func (s *NodeService) GetEnabledOnlineNodes() ([]model.Node, error) {
 // RegisterNode 注册节点 (从节点调用)
 func (s *NodeService) RegisterNode(token, nodeId, name, address, externalHost string, externalPort int, version string) (*model.Node, error) {
 	// 验证 Token
-	nodeToken, err := s.ValidateToken(token)
+	nodeToken, err := s.ValidateToken(token, nodeId)
 	if err != nil {
 		return nil, err
 	}
@@ -169,9 +266,15 @@ func (s *NodeService) RegisterNode(token, nodeId, name, address, externalHost st
 		ExternalHost: externalHost,
 		ExternalPort: externalPort,
 		Token:        token,
+		TokenId:      nodeToken.Id,
+		Secret:       GenerateNodeSecret(),
 		Status:       "online",
 		LastSeen:     time.Now().Unix(),
 		Version:      version,
+		// 邀请码预设的默认属性
+		Group:   nodeToken.Group,
+		Country: nodeToken.Country,
+		Flag:    nodeToken.Flag,
 	}
 
 	err = tx.Create(node).Error
@@ -215,8 +318,15 @@ func (s *NodeService) Save(tx *gorm.DB, act string, data json.RawMessage) error
 		if err != nil {
 			return err
 		}
+		if !nodeBudgetActions[node.BudgetAction] {
+			return common.NewError("unknown budget action: ", node.BudgetAction)
+		}
+		var old model.Node
+		if err = tx.Select("traffic_budget").Where("id = ?", node.Id).First(&old).Error; err != nil {
+			return err
+		}
 		// 只允许更新部分字段
-		err = tx.Model(&model.Node{}).Where("id = ?", node.Id).Updates(map[string]interface{}{
+		updates := map[string]interface{}{
 			"name":          node.Name,
 			"external_host": node.ExternalHost,
 			"external_port": node.ExternalPort,
@@ -225,7 +335,18 @@ func (s *NodeService) Save(tx *gorm.DB, act string, data json.RawMessage) error
 			"city":          node.City,
 			"flag":          node.Flag,
 			"is_premium":    node.IsPremium,
-		}).Error
+			"group":         node.Group,
+			// 流量预算
+			"traffic_budget":    max(node.TrafficBudget, 0),
+			"traffic_reset_day": min(max(node.TrafficResetDay, 1), 31),
+			"budget_action":     node.BudgetAction,
+			"capacity":          max(node.Capacity, 0),
+		}
+		// 预算调整后重新计算通知阈值
+		if old.TrafficBudget != node.TrafficBudget {
+			updates["budget_alert"] = 0
+		}
+		err = tx.Model(&model.Node{}).Where("id = ?", node.Id).Updates(updates).Error
 	case "del":
 		var id uint
 		err = json.Unmarshal(data, &id)
@@ -240,11 +361,21 @@ func (s *NodeService) Save(tx *gorm.DB, act string, data json.RawMessage) error
 		// 删除相关数据
 		tx.Where("node_id = ?", node.NodeId).Delete(&model.ClientOnline{})
 		tx.Where("node_id = ?", node.NodeId).Delete(&model.NodeStats{})
-		// 可选：重置 token 允许复用
-		tx.Model(&model.NodeToken{}).Where("used_by = ?", node.NodeId).Updates(map[string]interface{}{
-			"used":    false,
-			"used_by": "",
-		})
+		tx.Where("node_id = ?", node.NodeId).Delete(&model.NodeProbe{})
+		tx.Where("node_id = ?", node.NodeId).Delete(&model.NodeCommand{})
+		// 吊销节点的客户端证书
+		if err = s.RevokeNodeCerts(tx, node.NodeId); err != nil {
+			return err
+		}
+		// 释放邀请码的一次使用，允许复用
+		err = tx.Model(&model.NodeToken{}).Where("id = ? AND use_count > 0", node.TokenId).Updates(map[string]interface{}{
+			"used":      false,
+			"use_count": gorm.Expr("use_count - 1"),
+			"used_by":   gorm.Expr("CASE WHEN used_by = ? THEN '' ELSE used_by END", node.NodeId),
+		}).Error
+		if err != nil {
+			return err
+		}
 		// 删除节点
 		err = tx.Where("id = ?", id).Delete(&model.Node{}).Error
 	default:
@@ -254,47 +385,102 @@ func (s *NodeService) Save(tx *gorm.DB, act string, data json.RawMessage) error
 	return err
 }
 
-// ========== 认证 ==========
+// ========== 心跳和状态 ==========
+
+// HeartbeatInfo 从节点心跳上报的状态
+type HeartbeatInfo struct {
+	CPU          float64
+	Memory       float64
+	Connections  int
+	Version      string
+	ExternalHost string
+	ExternalPort int
+	// 配置推送通道的连接状态 (connected / disconnected)
+	ConfigStream string
+	// 本地覆盖配置与同步配置的 tag 冲突，保存在 system_info 中供管理员查看
+	Conflicts []OverlayConflict
+	// 自上次心跳以来的入站上传 / 下载流量
+	Upload   int64
+	Download int64
+	// 从节点配置签名校验失败的原因，保存在 system_info 中供管理员查看
+	ConfigError string
+	// 从节点已应用配置的序号
+	ConfigSerial int64
+}
 
-// AuthenticateNode 验证节点请求
-func (s *NodeService) AuthenticateNode(nodeId, token string) (*model.Node, error) {
+// Heartbeat 处理节点心跳
+// 更新节点状态，并将本次心跳记录为一条 NodeStats 时间序列数据
+func (s *NodeService) Heartbeat(nodeId string, info HeartbeatInfo) error {
+	var err error
 	db := database.GetDB()
+	tx := db.Begin()
+	defer func() {
+		if err == nil {
+			tx.Commit()
+		} else {
+			tx.Rollback()
+		}
+	}()
+
 	var node model.Node
-	err := db.Where("node_id = ? AND token = ?", nodeId, token).First(&node).Error
+	err = tx.Select("node_id", "last_seen", "unreachable", "draining", "drained_at").Where("node_id = ?", nodeId).First(&node).Error
 	if err != nil {
-		return nil, common.NewError("invalid node credentials")
-	}
-	if !node.Enable {
-		return nil, common.NewError("node is disabled")
+		return err
 	}
-	return &node, nil
-}
+	now := time.Now().Unix()
 
-// ========== 心跳和状态 ==========
+	// 心跳正常但主节点探测外部不可达时标记为 degraded
+	status := "online"
+	if node.Unreachable {
+		status = "degraded"
+	}
 
-// Heartbeat 处理节点心跳
-func (s *NodeService) Heartbeat(nodeId string, cpu, memory float64, connections int, version, externalHost string, externalPort int) error {
-	db := database.GetDB()
 	systemInfo, _ := json.Marshal(map[string]interface{}{
-		"cpu":         cpu,
-		"memory":      memory,
-		"connections": connections,
+		"cpu":          info.CPU,
+		"memory":       info.Memory,
+		"connections":  info.Connections,
+		"configStream": info.ConfigStream,
+		"conflicts":    info.Conflicts,
+		"configError":  info.ConfigError,
 	})
+	if info.ConfigError != "" {
+		logger.Warning("Node ", nodeId, " rejected config: ", info.ConfigError)
+	}
 	updates := map[string]interface{}{
-		"status":        "online",
-		"last_seen":     time.Now().Unix(),
-		"version":       version,
+		"status":        status,
+		"last_seen":     now,
+		"version":       info.Version,
 		"system_info":   systemInfo,
-		"external_port": externalPort,
+		"external_port": info.ExternalPort,
 	}
 	// 更新外部地址（如果提供）
-	if externalHost != "" {
-		updates["external_host"] = externalHost
+	if info.ExternalHost != "" {
+		updates["external_host"] = info.ExternalHost
 	}
+	// 排空中的节点连接数降为 0 时记录完成时间
+	nodeDrainUpdates(&node, info.Connections, now, updates)
 	// 使用 Select 强制更新 external_port（即使为 0）
-	return db.Model(&model.Node{}).Where("node_id = ?", nodeId).
-		Select("status", "last_seen", "version", "system_info", "external_port", "external_host").
+	err = tx.Model(&model.Node{}).Where("node_id = ?", nodeId).
+		Select("status", "last_seen", "version", "system_info", "external_port", "external_host", "drained_at").
 		Updates(updates).Error
+	if err != nil {
+		return err
+	}
+	if err = s.raiseNodeConfigSerial(tx, nodeId, info.ConfigSerial); err != nil {
+		return err
+	}
+
+	err = tx.Create(&model.NodeStats{
+		NodeId:      nodeId,
+		DateTime:    now,
+		Interval:    heartbeatInterval(node.LastSeen, now),
+		CPU:         info.CPU,
+		Memory:      info.Memory,
+		Connections: info.Connections,
+		Upload:      info.Upload,
+		Download:    info.Download,
+	}).Error
+	return err
 }
 
 // UpdateNodeStatus 更新节点状态 (定时任务调用)
@@ -304,7 +490,7 @@ func (s *NodeService) UpdateNodeStatus() error {
 
 	// 超过 60 秒未心跳，标记为 offline
 	err := db.Model(&model.Node{}).
-		Where("status = ? AND last_seen < ?", "online", now-60).
+		Where("status IN ? AND last_seen < ?", []string{"online", "degraded"}, now-60).
 		Update("status", "offline").Error
 	if err != nil {
 		return err
@@ -319,125 +505,264 @@ func (s *NodeService) UpdateNodeStatus() error {
 
 // ========== 配置同步 ==========
 
-// GetConfigVersion 获取配置版本 (使用 LastUpdate 时间戳)
-func (s *NodeService) GetConfigVersion() int64 {
+// GetConfigUpdateTime 获取最近一次配置变更的时间 (旧版从节点用作配置版本)
+func (s *NodeService) GetConfigUpdateTime() int64 {
 	return LastUpdate
 }
 
-// GetFullConfig 获取完整配置 (从节点同步用)
-func (s *NodeService) GetFullConfig() (map[string]interface{}, error) {
-	db := database.GetDB()
-	result := make(map[string]interface{})
+// configWatchTimeout 从节点等待配置变更的最长时间 (需小于从节点请求超时)
+const configWatchTimeout = 50 * time.Second
 
-	// 获取 Inbounds
-	var inbounds []model.Inbound
-	if err := db.Preload("Tls").Find(&inbounds).Error; err != nil {
-		return nil, err
-	}
-	result["inbounds"] = inbounds
+var (
+	configWatchMutex sync.Mutex
+	configWatchChan  = make(chan struct{})
+)
 
-	// 获取 Outbounds
-	var outbounds []model.Outbound
-	if err := db.Find(&outbounds).Error; err != nil {
-		return nil, err
-	}
-	result["outbounds"] = outbounds
+// notifyConfigChanged 唤醒所有等待配置变更的从节点，需在 LastUpdate 更新且事务提交后调用
+func notifyConfigChanged() {
+	configWatchMutex.Lock()
+	defer configWatchMutex.Unlock()
+	close(configWatchChan)
+	configWatchChan = make(chan struct{})
+}
 
-	// 获取 Clients
-	var clients []model.Client
-	if err := db.Find(&clients).Error; err != nil {
-		return nil, err
+// WaitConfigVersion 等待节点的配置版本与 version 不同 (长轮询)
+// 每次收到变更通知时重新计算版本，与该节点无关的变更不会唤醒从节点
+// 版本变化、超时或请求结束时返回当前版本
+func (s *NodeService) WaitConfigVersion(ctx context.Context, nodeId string, version string) (string, error) {
+	timer := time.NewTimer(configWatchTimeout)
+	defer timer.Stop()
+
+	for {
+		configWatchMutex.Lock()
+		ch := configWatchChan
+		configWatchMutex.Unlock()
+
+		current, err := s.GetConfigVersion(nodeId)
+		if err != nil || current != version {
+			return current, err
+		}
+		select {
+		case <-ch:
+		case <-timer.C:
+			return current, nil
+		case <-ctx.Done():
+			return current, nil
+		}
 	}
-	result["clients"] = clients
+}
 
-	// 获取 TLS
-	var tls []model.Tls
-	if err := db.Find(&tls).Error; err != nil {
-		return nil, err
+// WaitConfigChange 等待 LastUpdate 与 version 不同 (旧版从节点的长轮询)
+// 版本变化、超时或请求结束时返回当前版本
+func (s *NodeService) WaitConfigChange(ctx context.Context, version int64) int64 {
+	timer := time.NewTimer(configWatchTimeout)
+	defer timer.Stop()
+
+	for {
+		configWatchMutex.Lock()
+		ch := configWatchChan
+		configWatchMutex.Unlock()
+
+		if LastUpdate != version {
+			return LastUpdate
+		}
+		select {
+		case <-ch:
+		case <-timer.C:
+			return LastUpdate
+		case <-ctx.Done():
+			return LastUpdate
+		}
 	}
-	result["tls"] = tls
+}
 
-	// 获取 Services
-	var services []model.Service
-	if err := db.Find(&services).Error; err != nil {
-		return nil, err
+// UpdateLastSync 更新节点最后同步时间和已同步的配置版本
+func (s *NodeService) UpdateLastSync(nodeId string, version string) error {
+	db := database.GetDB()
+	return db.Model(&model.Node{}).Where("node_id = ?", nodeId).Updates(map[string]interface{}{
+		"last_sync":      time.Now().Unix(),
+		"config_version": version,
+	}).Error
+}
+
+// ========== 节点分配 ==========
+
+// NodeScopeMaster 节点分配列表中表示主节点的标识
+const NodeScopeMaster = "master"
+
+// nodeScopeGroupPrefix 节点分配列表中节点分组的前缀，如 "group:hk"
+const nodeScopeGroupPrefix = "group:"
+
+// ParseNodeScope 解析入站/出站/端点/服务的节点分配列表，为空表示分配给所有节点
+func ParseNodeScope(nodes json.RawMessage) []string {
+	var scope []string
+	if len(nodes) > 0 {
+		json.Unmarshal(nodes, &scope)
 	}
-	result["services"] = services
+	return scope
+}
 
-	// 获取 Endpoints
-	var endpoints []model.Endpoint
-	if err := db.Find(&endpoints).Error; err != nil {
-		return nil, err
+// InNodeScope 判断节点是否在分配列表中，nodeId 为 NodeScopeMaster 时判断主节点
+// group 为节点的分组 (可为逗号分隔的多个分组)
+func InNodeScope(nodes json.RawMessage, nodeId string, group string) bool {
+	scope := ParseNodeScope(nodes)
+	if len(scope) == 0 {
+		return true
+	}
+	groups := NodeGroupNames(group)
+	for _, item := range scope {
+		if item == nodeId {
+			return true
+		}
+		if name, ok := strings.CutPrefix(item, nodeScopeGroupPrefix); ok && slices.Contains(groups, name) {
+			return true
+		}
 	}
-	result["endpoints"] = endpoints
+	return false
+}
 
-	// 获取系统配置
-	var settingService SettingService
-	configStr, err := settingService.GetConfig()
+// GetInboundNodeScopes 获取所有入站的节点分配 (入站 tag -> 分配列表)
+func (s *NodeService) GetInboundNodeScopes() (map[string]json.RawMessage, error) {
+	db := database.GetDB()
+	var inbounds []model.Inbound
+	err := db.Model(model.Inbound{}).Select("tag", "nodes").Find(&inbounds).Error
 	if err != nil {
 		return nil, err
 	}
-	result["config"] = json.RawMessage(configStr)
-
-	// 版本号
-	result["version"] = LastUpdate
+	scopes := make(map[string]json.RawMessage, len(inbounds))
+	for _, inbound := range inbounds {
+		scopes[inbound.Tag] = inbound.Nodes
+	}
+	return scopes, nil
+}
 
-	return result, nil
+// ServedByMaster 判断是否明确分配给了主节点
+// 未分配 (所有节点) 时订阅中仍只包含从节点，与未引入节点分配前保持一致
+func ServedByMaster(nodes json.RawMessage) bool {
+	return len(ParseNodeScope(nodes)) > 0 && InNodeScope(nodes, NodeScopeMaster, "")
 }
 
-// UpdateLastSync 更新节点最后同步时间
-func (s *NodeService) UpdateLastSync(nodeId string) error {
-	db := database.GetDB()
-	return db.Model(&model.Node{}).Where("node_id = ?", nodeId).Update("last_sync", time.Now().Unix()).Error
+// runsLocally 判断本机 core 是否需要运行该入站/出站/端点/服务
+// 从节点收到的配置已按分配过滤；主节点只运行分配给主节点 (或未分配) 的部分
+func runsLocally(nodes json.RawMessage) bool {
+	if !config.IsMaster() {
+		return true
+	}
+	return InNodeScope(nodes, NodeScopeMaster, "")
 }
 
 // ========== 统计上报 ==========
 
 // SaveNodeStats 保存从节点上报的统计数据
-func (s *NodeService) SaveNodeStats(nodeId string, stats []model.Stats) error {
-	if len(stats) == 0 {
-		return nil
-	}
-
+// batchId 非空时按 (nodeId, batchId) 去重，重复上报的批次直接确认而不重复计入流量
+func (s *NodeService) SaveNodeStats(nodeId string, batchId string, stats []model.Stats) (bool, error) {
+	var err error
+	var budgetEvent *NodeBudgetEventData
 	db := database.GetDB()
 	tx := db.Begin()
 	defer func() {
-		if r := recover(); r != nil {
+		if err == nil {
+			tx.Commit()
+			if budgetEvent != nil {
+				sendNodeBudgetEvent(budgetEvent)
+			}
+		} else {
 			tx.Rollback()
 		}
 	}()
 
+	if batchId != "" {
+		var count int64
+		err = tx.Model(&model.NodeStatsBatch{}).Where("node_id = ? AND batch_id = ?", nodeId, batchId).Count(&count).Error
+		if err != nil {
+			return false, err
+		}
+		if count > 0 {
+			return true, nil
+		}
+		err = tx.Create(&model.NodeStatsBatch{
+			NodeId:   nodeId,
+			BatchId:  batchId,
+			DateTime: time.Now().Unix(),
+		}).Error
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if len(stats) == 0 {
+		return false, nil
+	}
+
+	// 在线时长单独合并，不作为流量统计保存
+	var clientService ClientService
+	traffic := make([]model.Stats, 0, len(stats))
+	onlineTime := make(map[int64]map[string]int64)
+	for _, stat := range stats {
+		if stat.Resource != onlineTimeResource {
+			traffic = append(traffic, stat)
+			continue
+		}
+		if onlineTime[stat.DateTime] == nil {
+			onlineTime[stat.DateTime] = make(map[string]int64)
+		}
+		onlineTime[stat.DateTime][stat.Tag] += stat.Traffic
+	}
+	for dateTime, timeData := range onlineTime {
+		err = clientService.AddOnlineTime(tx, dateTime, timeData)
+		if err != nil {
+			return false, err
+		}
+	}
+	stats = traffic
+	if len(stats) == 0 {
+		return false, nil
+	}
+
 	// 使用索引访问以修改原始切片
+	var budgetTraffic int64
 	for i := range stats {
 		stats[i].NodeId = nodeId
+		// 入站和出站流量计入节点流量预算
+		if stats[i].Resource == "inbound" || stats[i].Resource == "outbound" {
+			budgetTraffic += stats[i].Traffic
+		}
 		// 更新 Client 流量
 		if stats[i].Resource == "user" {
 			if stats[i].Direction {
-				err := tx.Model(&model.Client{}).Where("name = ?", stats[i].Tag).
+				err = tx.Model(&model.Client{}).Where("name = ?", stats[i].Tag).
 					UpdateColumn("up", gorm.Expr("up + ?", stats[i].Traffic)).Error
-				if err != nil {
-					tx.Rollback()
-					return err
-				}
 			} else {
-				err := tx.Model(&model.Client{}).Where("name = ?", stats[i].Tag).
+				err = tx.Model(&model.Client{}).Where("name = ?", stats[i].Tag).
 					UpdateColumn("down", gorm.Expr("down + ?", stats[i].Traffic)).Error
-				if err != nil {
-					tx.Rollback()
-					return err
-				}
+			}
+			if err != nil {
+				return false, err
 			}
 		}
 	}
 
+	if budgetTraffic > 0 {
+		budgetEvent, err = addNodeBudgetTraffic(tx, nodeId, budgetTraffic)
+		if err != nil {
+			return false, err
+		}
+	}
+
 	// 保存统计记录
-	if err := tx.Create(&stats).Error; err != nil {
-		tx.Rollback()
-		return err
+	err = tx.Create(&stats).Error
+	if err != nil {
+		return false, err
 	}
+	return false, nil
+}
 
-	tx.Commit()
-	return nil
+// DelOldStatsBatches 清理过期的统计批次去重记录
+func (s *NodeService) DelOldStatsBatches() error {
+	// 保留 30 天，远大于从节点重试同一批次的时间窗口
+	oldTime := time.Now().AddDate(0, 0, -30).Unix()
+	db := database.GetDB()
+	return db.Where("date_time < ?", oldTime).Delete(&model.NodeStatsBatch{}).Error
 }
 
 // SaveOnlineStatus 保存从节点上报的在线状态
@@ -475,6 +800,37 @@ func (s *NodeService) SaveOnlineStatus(nodeId string, onlines []model.ClientOnli
 	return nil
 }
 
+// GetRemoteDevices 获取某个从节点之外的全集群在线设备 (用于从节点设备数限制)
+// 仅包含设置了设备数限制的用户，主节点本地连接也计算在内
+func (s *NodeService) GetRemoteDevices(nodeId string) (map[string][]string, error) {
+	var statsService StatsService
+	devices, err := statsService.GetOnlineDevices(nodeId)
+	if err != nil {
+		return nil, err
+	}
+	if corePtr.IsRunning() {
+		for user, ips := range corePtr.GetInstance().ConnTracker().GetLocalDevices() {
+			for _, ip := range ips {
+				devices[user] = appendUnique(devices[user], ip)
+			}
+		}
+	}
+
+	var limited []string
+	db := database.GetDB()
+	err = db.Model(&model.Client{}).Where("enable = ? AND device_limit > 0", true).Pluck("name", &limited).Error
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string][]string, len(limited))
+	for _, user := range limited {
+		if ips, ok := devices[user]; ok {
+			result[user] = ips
+		}
+	}
+	return result, nil
+}
+
 // ========== 辅助函数 ==========
 
 // generateSecureToken 生成安全随机 Token
@@ -0,0 +1,260 @@
+package service
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/alireza0/s-ui/database"
+	"github.com/alireza0/s-ui/database/model"
+	"github.com/alireza0/s-ui/logger"
+	"github.com/alireza0/s-ui/util/common"
+)
+
+// 节点请求签名
+// 从节点用注册时获得的节点密钥对 方法、路径 (含查询参数)、请求体哈希、时间戳和随机数 做 HMAC-SHA256，
+// 主节点校验签名、时间偏差和随机数是否重复使用
+const (
+	// 允许的时钟偏差 (秒)，超出时拒绝请求；随机数在该窗口内不可重复
+	nodeAuthMaxSkew = 300
+	// 节点密钥长度 (字节)
+	nodeSecretLength = 32
+	// 待下发节点密钥的有效期 (秒)，过期后重新生成
+	nodeNextSecretTTL = 600
+	// 加密下发新密钥时派生密钥的前缀
+	nodeSecretSealContext = "s-ui node secret v1\n"
+)
+
+var (
+	// 已使用的随机数 (节点 ID + 随机数 -> 过期时间)
+	nodeNonces      = make(map[string]int64)
+	nodeNoncesMutex sync.Mutex
+)
+
+// NodeRequestSignature 计算节点请求签名
+func NodeRequestSignature(secret, method, uri string, body []byte, timestamp, nonce string) string {
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(method + "\n" + uri + "\n" + hex.EncodeToString(bodyHash[:]) + "\n" + timestamp + "\n" + nonce))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func verifyNodeSignature(secret, method, uri string, body []byte, timestamp, nonce, signature string) bool {
+	if secret == "" {
+		return false
+	}
+	expected := NodeRequestSignature(secret, method, uri, body, timestamp, nonce)
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+// GenerateNodeSecret 生成节点密钥
+func GenerateNodeSecret() string {
+	return generateSecureToken(nodeSecretLength)
+}
+
+// VerifyNodeRequest 校验节点请求签名
+// 使用未过期的待下发密钥签名时说明从节点已收到新密钥，新密钥正式生效
+func (s *NodeService) VerifyNodeRequest(nodeId, method, uri string, body []byte, timestamp, nonce, signature string) (*model.Node, error) {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || nonce == "" || signature == "" {
+		return nil, common.NewError("invalid node signature")
+	}
+	now := time.Now().Unix()
+	if ts < now-nodeAuthMaxSkew || ts > now+nodeAuthMaxSkew {
+		return nil, common.NewErrorf("request time out of range, server time %d", now)
+	}
+
+	db := database.GetDB()
+	var node model.Node
+	err = db.Where("node_id = ?", nodeId).First(&node).Error
+	if err != nil {
+		return nil, common.NewError("invalid node credentials")
+	}
+	if !node.Enable {
+		return nil, common.NewError("node is disabled")
+	}
+
+	promote := false
+	if !verifyNodeSignature(node.Secret, method, uri, body, timestamp, nonce, signature) {
+		if node.NextSecretExpiry < now || !verifyNodeSignature(node.NextSecret, method, uri, body, timestamp, nonce, signature) {
+			return nil, common.NewError("invalid node credentials")
+		}
+		promote = true
+	}
+
+	// 签名有效后再记录随机数，避免伪造请求占用随机数
+	if !useNodeNonce(nodeId, nonce, now) {
+		return nil, common.NewError("replayed node request")
+	}
+
+	if promote {
+		err = db.Model(&model.Node{}).Where("id = ? AND next_secret = ?", node.Id, node.NextSecret).
+			Updates(map[string]interface{}{
+				"secret":             node.NextSecret,
+				"next_secret":        "",
+				"next_secret_expiry": 0,
+			}).Error
+		if err != nil {
+			return nil, err
+		}
+		node.Secret, node.NextSecret, node.NextSecretExpiry = node.NextSecret, "", 0
+		logger.Info("Node secret rotated: ", nodeId)
+	}
+	return &node, nil
+}
+
+// useNodeNonce 记录随机数，已使用过时返回 false
+func useNodeNonce(nodeId, nonce string, now int64) bool {
+	nodeNoncesMutex.Lock()
+	defer nodeNoncesMutex.Unlock()
+
+	key := nodeId + ":" + nonce
+	if expiry, ok := nodeNonces[key]; ok && expiry >= now {
+		return false
+	}
+	// 时间戳超出窗口的请求会被直接拒绝，过期的随机数可以清理
+	for k, expiry := range nodeNonces {
+		if expiry < now {
+			delete(nodeNonces, k)
+		}
+	}
+	nodeNonces[key] = now + 2*nodeAuthMaxSkew
+	return true
+}
+
+// AuthenticateNode 使用注册 token 验证节点请求 (旧版从节点和密钥被轮换的节点)
+// 只对尚未分配节点密钥的节点有效，同时生成待下发的节点密钥 (过期后重新生成)，从节点收到后改用签名认证
+func (s *NodeService) AuthenticateNode(nodeId, token string) (*model.Node, error) {
+	db := database.GetDB()
+	var node model.Node
+	err := db.Where("node_id = ? AND token = ?", nodeId, token).First(&node).Error
+	if err != nil || node.Secret != "" {
+		return nil, common.NewError("invalid node credentials")
+	}
+	if !node.Enable {
+		return nil, common.NewError("node is disabled")
+	}
+	now := time.Now().Unix()
+	if node.NextSecret == "" || node.NextSecretExpiry < now {
+		node.NextSecret = GenerateNodeSecret()
+		node.NextSecretExpiry = now + nodeNextSecretTTL
+		err = db.Model(&model.Node{}).Where("id = ?", node.Id).Updates(map[string]interface{}{
+			"next_secret":        node.NextSecret,
+			"next_secret_expiry": node.NextSecretExpiry,
+		}).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &node, nil
+}
+
+// nodeSecretCipher 由当前节点密钥派生的 AES-GCM，用于向从节点下发新密钥
+func nodeSecretCipher(secret string) (cipher.AEAD, error) {
+	key := sha256.Sum256([]byte(nodeSecretSealContext + secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealNodeSecret 用当前节点密钥加密新密钥 (节点 ID 作为附加数据)，只有持有当前密钥的从节点能解密
+func sealNodeSecret(secret, nodeId, next string) (string, error) {
+	aead, err := nodeSecretCipher(secret)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, []byte(next), []byte(nodeId))), nil
+}
+
+// openNodeSecret 解密主节点下发的新密钥，密文被篡改或密钥不符时返回错误
+func openNodeSecret(secret, nodeId, sealed string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return "", err
+	}
+	aead, err := nodeSecretCipher(secret)
+	if err != nil {
+		return "", err
+	}
+	if len(data) < aead.NonceSize() {
+		return "", common.NewError("invalid sealed node secret")
+	}
+	next, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(nodeId))
+	if err != nil {
+		return "", common.NewError("invalid sealed node secret")
+	}
+	return string(next), nil
+}
+
+// RotateNodeSecret 轮换节点密钥 (管理 API)，不需要重新注册
+// 当前密钥继续有效；新密钥用当前密钥加密后通过心跳下发，从节点第一次用新密钥签名的请求使新密钥生效、旧密钥失效
+func (s *NodeService) RotateNodeSecret(id uint) error {
+	db := database.GetDB()
+	var node model.Node
+	if err := db.Where("id = ?", id).First(&node).Error; err != nil {
+		return common.NewError("node not found")
+	}
+	if node.Secret == "" {
+		return common.NewError("node has no secret yet, it will get one with the next heartbeat")
+	}
+	return db.Model(&model.Node{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"next_secret":        GenerateNodeSecret(),
+		"next_secret_expiry": time.Now().Unix() + nodeNextSecretTTL,
+	}).Error
+}
+
+// SealedNextSecret 获取下发给用当前密钥认证的从节点的新密钥 (已加密)，没有待轮换的密钥时返回空
+// 新密钥过期仍未生效说明从节点没有收到，重新生成并下发
+func (s *NodeService) SealedNextSecret(node *model.Node) (string, error) {
+	if node.Secret == "" || node.NextSecret == "" {
+		return "", nil
+	}
+	now := time.Now().Unix()
+	if node.NextSecretExpiry < now {
+		next, expiry := GenerateNodeSecret(), now+nodeNextSecretTTL
+		err := database.GetDB().Model(&model.Node{}).Where("id = ? AND next_secret = ?", node.Id, node.NextSecret).
+			Updates(map[string]interface{}{
+				"next_secret":        next,
+				"next_secret_expiry": expiry,
+			}).Error
+		if err != nil {
+			return "", err
+		}
+		node.NextSecret, node.NextSecretExpiry = next, expiry
+	}
+	return sealNodeSecret(node.Secret, node.NodeId, node.NextSecret)
+}
+
+// RevokeNodeSecret 吊销节点密钥并生成新的注册 token (管理 API，节点密钥泄露时使用)
+// 旧密钥和旧 token 立即失效；从节点配置新 token 并重启后用 token 认证，新密钥通过心跳下发
+// 只用旧密钥认证的请求拿不到新密钥，泄露的旧密钥无法用来接管节点
+func (s *NodeService) RevokeNodeSecret(id uint) (string, error) {
+	token := generateSecureToken(32)
+	db := database.GetDB()
+	result := db.Model(&model.Node{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"token":              token,
+		"secret":             "",
+		"next_secret":        "",
+		"next_secret_expiry": 0,
+	})
+	if result.Error != nil {
+		return "", result.Error
+	}
+	if result.RowsAffected == 0 {
+		return "", common.NewError("node not found")
+	}
+	return token, nil
+}
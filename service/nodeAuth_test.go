@@ -0,0 +1,121 @@
+package service
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestVerifyNodeSignature(t *testing.T) {
+	const (
+		secret    = "node-secret"
+		method    = "POST"
+		uri       = "/app/node/heartbeat?x=1"
+		timestamp = "1702900000"
+		nonce     = "9f2c1a7b"
+	)
+	body := []byte(`{"status":"online"}`)
+	signature := NodeRequestSignature(secret, method, uri, body, timestamp, nonce)
+
+	tests := []struct {
+		name      string
+		secret    string
+		method    string
+		uri       string
+		body      []byte
+		timestamp string
+		nonce     string
+		signature string
+		want      bool
+	}{
+		{"valid", secret, method, uri, body, timestamp, nonce, signature, true},
+		{"wrong secret", "other-secret", method, uri, body, timestamp, nonce, signature, false},
+		{"empty secret", "", method, uri, body, timestamp, nonce, NodeRequestSignature("", method, uri, body, timestamp, nonce), false},
+		{"different method", secret, "GET", uri, body, timestamp, nonce, signature, false},
+		{"different path", secret, method, "/app/node/config", body, timestamp, nonce, signature, false},
+		{"different query", secret, method, "/app/node/heartbeat?x=2", body, timestamp, nonce, signature, false},
+		{"tampered body", secret, method, uri, []byte(`{"status":"offline"}`), timestamp, nonce, signature, false},
+		{"missing body", secret, method, uri, nil, timestamp, nonce, signature, false},
+		{"different timestamp", secret, method, uri, body, "1702900001", nonce, signature, false},
+		{"different nonce", secret, method, uri, body, timestamp, "9f2c1a7c", signature, false},
+		{"empty signature", secret, method, uri, body, timestamp, nonce, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := verifyNodeSignature(tt.secret, tt.method, tt.uri, tt.body, tt.timestamp, tt.nonce, tt.signature)
+			if got != tt.want {
+				t.Fatalf("verifyNodeSignature() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNodeRequestSignatureStable(t *testing.T) {
+	a := NodeRequestSignature("secret", "GET", "/node/config", nil, "1", "n")
+	b := NodeRequestSignature("secret", "GET", "/node/config", []byte{}, "1", "n")
+	if a != b {
+		t.Fatalf("nil and empty body signatures differ: %s != %s", a, b)
+	}
+	if len(a) != 64 {
+		t.Fatalf("signature length = %d, want 64 hex characters", len(a))
+	}
+}
+
+func TestOpenNodeSecret(t *testing.T) {
+	const (
+		secret = "current-secret"
+		nodeId = "node-1"
+		next   = "next-secret"
+	)
+	sealed, err := sealNodeSecret(secret, nodeId, next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := append([]byte(nil), data...)
+	tampered[len(tampered)-1] ^= 1
+
+	tests := []struct {
+		name    string
+		secret  string
+		nodeId  string
+		sealed  string
+		want    string
+		wantErr bool
+	}{
+		{"valid", secret, nodeId, sealed, next, false},
+		{"wrong secret", "other-secret", nodeId, sealed, "", true},
+		{"wrong node", secret, "node-2", sealed, "", true},
+		{"tampered", secret, nodeId, base64.StdEncoding.EncodeToString(tampered), "", true},
+		{"truncated", secret, nodeId, base64.StdEncoding.EncodeToString(data[:8]), "", true},
+		{"not base64", secret, nodeId, "not base64!", "", true},
+		{"empty", secret, nodeId, "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := openNodeSecret(tt.secret, tt.nodeId, tt.sealed)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("openNodeSecret() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Fatalf("openNodeSecret() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSealNodeSecretNonce(t *testing.T) {
+	a, err := sealNodeSecret("secret", "node-1", "next")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := sealNodeSecret("secret", "node-1", "next")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a == b {
+		t.Fatal("sealing the same secret twice produced the same ciphertext")
+	}
+}
@@ -0,0 +1,211 @@
+package service
+
+import (
+	"time"
+
+	"github.com/alireza0/s-ui/database"
+	"github.com/alireza0/s-ui/database/model"
+	"github.com/alireza0/s-ui/logger"
+
+	"gorm.io/gorm"
+)
+
+// 流量预算用满后的处理方式
+const (
+	BudgetActionRemove  = "remove"  // 不再下发到订阅
+	BudgetActionPremium = "premium" // 只服务会员客户端
+)
+
+var nodeBudgetActions = map[string]bool{
+	"":                  true,
+	BudgetActionRemove:  true,
+	BudgetActionPremium: true,
+}
+
+// 预算通知阈值 (百分比)
+var nodeBudgetThresholds = []int{80, 95, 100}
+
+// NodeBudgetEventData 节点流量预算事件数据
+type NodeBudgetEventData struct {
+	NodeId    string `json:"nodeId"`
+	Name      string `json:"name"`
+	Budget    int64  `json:"budget"`
+	Used      int64  `json:"used"`
+	Threshold int    `json:"threshold,omitempty"`
+	Action    string `json:"action,omitempty"`
+}
+
+// NodeBudgetAction 节点流量预算用满后的处理方式，未设置时从订阅中移除
+func NodeBudgetAction(node *model.Node) string {
+	if node.BudgetAction == "" {
+		return BudgetActionRemove
+	}
+	return node.BudgetAction
+}
+
+// NodeBudgetExceeded 节点本周期流量预算是否已用满
+func NodeBudgetExceeded(node *model.Node) bool {
+	return node.TrafficBudget > 0 && node.TrafficUsed >= node.TrafficBudget
+}
+
+// ========== 主节点 ==========
+
+// addNodeBudgetTraffic 累加节点本周期已用流量，跨过新的通知阈值时返回待发送的事件
+// 统计入站和出站流量的上下行之和，即经过节点网卡的全部流量
+func addNodeBudgetTraffic(tx *gorm.DB, nodeId string, traffic int64) (*NodeBudgetEventData, error) {
+	err := tx.Model(&model.Node{}).Where("node_id = ?", nodeId).
+		UpdateColumn("traffic_used", gorm.Expr("traffic_used + ?", traffic)).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var node model.Node
+	err = tx.Select("node_id", "name", "traffic_budget", "traffic_used", "budget_action", "budget_alert").
+		Where("node_id = ?", nodeId).First(&node).Error
+	if err != nil {
+		return nil, err
+	}
+	if node.TrafficBudget <= 0 {
+		return nil, nil
+	}
+
+	threshold := 0
+	for _, t := range nodeBudgetThresholds {
+		if node.TrafficUsed*100 >= node.TrafficBudget*int64(t) {
+			threshold = t
+		}
+	}
+	if threshold <= node.BudgetAlert {
+		return nil, nil
+	}
+	err = tx.Model(&model.Node{}).Where("node_id = ?", nodeId).UpdateColumn("budget_alert", threshold).Error
+	if err != nil {
+		return nil, err
+	}
+	return &NodeBudgetEventData{
+		NodeId:    node.NodeId,
+		Name:      node.Name,
+		Budget:    node.TrafficBudget,
+		Used:      node.TrafficUsed,
+		Threshold: threshold,
+		Action:    NodeBudgetAction(&node),
+	}, nil
+}
+
+// sendNodeBudgetEvent 发送流量预算阈值事件
+func sendNodeBudgetEvent(data *NodeBudgetEventData) {
+	event := EventNodeBudgetWarning
+	if data.Threshold >= 100 {
+		event = EventNodeBudgetExceeded
+		logger.Warning("Node traffic budget exhausted: ", data.NodeId, ", action: ", data.Action)
+	} else {
+		logger.Info("Node traffic budget reached ", data.Threshold, "%: ", data.NodeId)
+	}
+	var webhookService WebhookService
+	webhookService.SendCallback(event, data)
+}
+
+// budgetResetDate 某月的预算重置日 0 点，重置日超出当月天数时取月末
+func budgetResetDate(year int, month time.Month, day int, loc *time.Location) time.Time {
+	first := time.Date(year, month, 1, 0, 0, 0, 0, loc)
+	lastDay := first.AddDate(0, 1, -1).Day()
+	return first.AddDate(0, 0, min(max(day, 1), lastDay)-1)
+}
+
+// nodeBudgetPeriodStart now 所在预算周期的开始时间
+func nodeBudgetPeriodStart(resetDay int, now time.Time) time.Time {
+	start := budgetResetDate(now.Year(), now.Month(), resetDay, now.Location())
+	if start.After(now) {
+		start = budgetResetDate(now.Year(), now.Month()-1, resetDay, now.Location())
+	}
+	return start
+}
+
+// ResetNodeBudgets 进入新的预算周期时清零节点已用流量
+// 首次设置预算的节点 (TrafficResetAt 为 0) 只开始计费周期，不发送重置事件
+func (s *NodeService) ResetNodeBudgets() error {
+	db := database.GetDB()
+	var nodes []model.Node
+	err := db.Select("id", "node_id", "name", "traffic_budget", "traffic_reset_day", "traffic_used", "traffic_reset_at").
+		Where("traffic_budget > 0").Find(&nodes).Error
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var webhookService WebhookService
+	for _, node := range nodes {
+		start := nodeBudgetPeriodStart(node.TrafficResetDay, now)
+		if node.TrafficResetAt >= start.Unix() {
+			continue
+		}
+		// 只扣除读取时的用量，保留期间新入账的流量
+		err = db.Model(&model.Node{}).Where("id = ?", node.Id).Updates(map[string]interface{}{
+			"traffic_used":     gorm.Expr("max(traffic_used - ?, 0)", node.TrafficUsed),
+			"traffic_reset_at": now.Unix(),
+			"budget_alert":     0,
+		}).Error
+		if err != nil {
+			return err
+		}
+		if node.TrafficResetAt == 0 {
+			continue
+		}
+		logger.Info("Node traffic budget reset: ", node.NodeId)
+		webhookService.SendCallback(EventNodeBudgetReset, NodeBudgetEventData{
+			NodeId: node.NodeId,
+			Name:   node.Name,
+			Budget: node.TrafficBudget,
+			Used:   node.TrafficUsed,
+		})
+	}
+	return nil
+}
+
+// ========== 从节点 ==========
+
+// applyBudget 处理心跳响应中的预算状态
+// 预算用满且处理方式为 premium 时只允许会员客户端建立新连接，并断开其他客户端
+func (s *SyncService) applyBudget(raw interface{}) {
+	action, _ := raw.(string)
+	limited := action == BudgetActionPremium
+
+	s.mutex.Lock()
+	wasLimited := s.budgetLimited
+	s.budgetLimited = limited
+	s.mutex.Unlock()
+
+	if limited != wasLimited {
+		if limited {
+			logger.Warning("Node traffic budget exhausted, serving premium clients only")
+		} else {
+			logger.Info("Node traffic budget restored, serving all clients")
+		}
+	}
+	if !corePtr.IsRunning() {
+		return
+	}
+	tracker := corePtr.GetInstance().ConnTracker()
+	if !limited {
+		if wasLimited {
+			tracker.SetAllowedUsers(nil)
+		}
+		return
+	}
+
+	// 每次心跳重新读取，客户端变更和内核重启后都能生效
+	var names []string
+	db := database.GetDB()
+	err := db.Model(&model.Client{}).Where("enable = ? AND is_premium = ?", true, true).Pluck("name", &names).Error
+	if err != nil {
+		logger.Warning("Failed to load premium clients: ", err)
+		return
+	}
+	allowed := make(map[string]bool, len(names))
+	for _, name := range names {
+		allowed[name] = true
+	}
+	if closed := tracker.SetAllowedUsers(allowed); closed > 0 {
+		logger.Info("Budget limit closed ", closed, " non-premium connections")
+	}
+}
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBudgetResetDate(t *testing.T) {
+	tests := []struct {
+		name  string
+		year  int
+		month time.Month
+		day   int
+		want  string
+	}{
+		{"first day", 2024, time.March, 1, "2024-03-01"},
+		{"mid month", 2024, time.March, 15, "2024-03-15"},
+		{"day 31 in a 30 day month", 2024, time.April, 31, "2024-04-30"},
+		{"day 31 in february of a leap year", 2024, time.February, 31, "2024-02-29"},
+		{"day 30 in february", 2023, time.February, 30, "2023-02-28"},
+		{"day 0 clamps to first", 2024, time.March, 0, "2024-03-01"},
+		{"negative day clamps to first", 2024, time.March, -5, "2024-03-01"},
+		{"month 0 is december of the previous year", 2024, 0, 31, "2023-12-31"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := budgetResetDate(tt.year, tt.month, tt.day, time.UTC)
+			if got.Format(time.DateOnly) != tt.want || got.Hour() != 0 || got.Minute() != 0 {
+				t.Fatalf("budgetResetDate() = %v, want %s 00:00", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNodeBudgetPeriodStart(t *testing.T) {
+	at := func(value string) time.Time {
+		parsed, err := time.Parse(time.DateTime, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+
+	tests := []struct {
+		name     string
+		resetDay int
+		now      string
+		want     string
+	}{
+		{"after reset day", 15, "2024-03-20 12:00:00", "2024-03-15"},
+		{"on reset day", 15, "2024-03-15 00:00:00", "2024-03-15"},
+		{"before reset day", 15, "2024-03-14 23:59:59", "2024-02-15"},
+		{"first of month", 1, "2024-03-01 08:00:00", "2024-03-01"},
+		{"before reset day in january", 10, "2024-01-05 00:00:00", "2023-12-10"},
+		{"reset day 31 in march", 31, "2024-03-15 00:00:00", "2024-02-29"},
+		{"reset day 31 at end of april", 31, "2024-04-30 10:00:00", "2024-04-30"},
+		{"reset day 31 early may", 31, "2024-05-01 10:00:00", "2024-04-30"},
+		{"reset day 0 uses first", 0, "2024-03-20 00:00:00", "2024-03-01"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := nodeBudgetPeriodStart(tt.resetDay, at(tt.now))
+			if got.Format(time.DateOnly) != tt.want || got.Hour() != 0 {
+				t.Fatalf("nodeBudgetPeriodStart() = %v, want %s 00:00", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,357 @@
+package service
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/alireza0/s-ui/config"
+	"github.com/alireza0/s-ui/database"
+	"github.com/alireza0/s-ui/database/model"
+	"github.com/alireza0/s-ui/logger"
+	"github.com/alireza0/s-ui/util/common"
+
+	"gorm.io/gorm"
+)
+
+// 主节点 CA 与节点 mTLS
+// 主节点作为 CA 为每个从节点签发客户端证书，并为节点 API 签发服务端证书；
+// 从节点以 NodeTLSServerName 作为 SNI 连接主节点，只信任主节点 CA (证书固定)，
+// 主节点据此对节点 API 使用 CA 签发的证书并校验客户端证书
+const (
+	// NodeTLSServerName 从节点连接主节点时使用的 SNI，主节点据此区分节点 API 的 TLS 连接
+	NodeTLSServerName = "s-ui-master.node"
+	// 节点证书有效期，剩余不足 nodeCertRenewBefore 时从节点自动续签客户端证书，主节点续签服务端证书
+	nodeCertValidity    = 365 * 24 * time.Hour
+	nodeCertRenewBefore = 30 * 24 * time.Hour
+	nodeCAValidity      = 10 * 365 * 24 * time.Hour
+	// 没有客户端证书的从节点在心跳中重新申请的间隔
+	nodeCertRetryInterval = 10 * time.Minute
+)
+
+var (
+	// 串行化 CA 的首次生成
+	nodeCAMutex sync.Mutex
+	// 节点 API 的服务端证书 (每次启动由 CA 重新签发，即将过期时在握手时续签)
+	nodeServerCert      *tls.Certificate
+	nodeServerCertMutex sync.Mutex
+)
+
+// loadNodeCA 读取主节点 CA，不存在时生成
+func (s *NodeService) loadNodeCA() (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	nodeCAMutex.Lock()
+	defer nodeCAMutex.Unlock()
+
+	var settingService SettingService
+	certPEM, err := settingService.getString("nodeCaCert")
+	if err != nil {
+		return nil, nil, err
+	}
+	keyPEM, err := settingService.getString("nodeCaKey")
+	if err != nil {
+		return nil, nil, err
+	}
+	if certPEM != "" && keyPEM != "" {
+		return parseCertAndKey([]byte(certPEM), []byte(keyPEM))
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          newCertSerial(),
+		Subject:               pkix.Name{CommonName: "S-UI Node CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(nodeCAValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	certPEM = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	keyPEM = string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}))
+	if err = settingService.setString("nodeCaCert", certPEM); err != nil {
+		return nil, nil, err
+	}
+	if err = settingService.setString("nodeCaKey", keyPEM); err != nil {
+		return nil, nil, err
+	}
+	return parseCertAndKey([]byte(certPEM), []byte(keyPEM))
+}
+
+// NodeTLSEnabled 主节点是否自己终止 TLS (配置了面板证书)，只有这时才能对节点 API 做 mTLS
+// 经反向代理终止 TLS 时从节点无法通过 SNI 连到 CA 签发的证书，因此不签发客户端证书
+func (s *NodeService) NodeTLSEnabled() bool {
+	if !config.IsMaster() {
+		return false
+	}
+	var settingService SettingService
+	certFile, _ := settingService.GetCertFile()
+	keyFile, _ := settingService.GetKeyFile()
+	return certFile != "" && keyFile != ""
+}
+
+// IssueNodeCert 根据从节点的 CSR 签发客户端证书，返回证书和 CA 证书 (PEM)
+// 同一节点之前签发的证书全部吊销
+func (s *NodeService) IssueNodeCert(nodeId string, csrPEM string) (string, string, error) {
+	if !s.NodeTLSEnabled() {
+		return "", "", common.NewError("node mTLS is not available on this master")
+	}
+	block, _ := pem.Decode([]byte(csrPEM))
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return "", "", common.NewError("invalid certificate request")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return "", "", err
+	}
+	if err = csr.CheckSignature(); err != nil {
+		return "", "", err
+	}
+
+	caCert, caKey, err := s.loadNodeCA()
+	if err != nil {
+		return "", "", err
+	}
+	now := time.Now()
+	serial := newCertSerial()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		// 证书主体固定为节点 ID，不采用 CSR 中的主体
+		Subject:     pkix.Name{CommonName: nodeId},
+		NotBefore:   now.Add(-time.Hour),
+		NotAfter:    now.Add(nodeCertValidity),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, csr.PublicKey, caKey)
+	if err != nil {
+		return "", "", err
+	}
+
+	var txErr error
+	db := database.GetDB()
+	tx := db.Begin()
+	defer func() {
+		if txErr == nil {
+			tx.Commit()
+		} else {
+			tx.Rollback()
+		}
+	}()
+	txErr = s.RevokeNodeCerts(tx, nodeId)
+	if txErr != nil {
+		return "", "", txErr
+	}
+	txErr = tx.Create(&model.NodeCert{
+		NodeId:   nodeId,
+		Serial:   serial.Text(16),
+		NotAfter: template.NotAfter.Unix(),
+	}).Error
+	if txErr != nil {
+		return "", "", txErr
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw})
+	return string(certPEM), string(caPEM), nil
+}
+
+// RevokeNodeCerts 吊销节点的所有客户端证书
+func (s *NodeService) RevokeNodeCerts(tx *gorm.DB, nodeId string) error {
+	return tx.Model(&model.NodeCert{}).Where("node_id = ?", nodeId).Update("revoked", true).Error
+}
+
+// VerifyNodeClientCert 校验节点请求的客户端证书
+// 证书链已在 TLS 握手时由 CA 校验，这里检查证书属于该节点且未被吊销；
+// 开启 nodeRequireClientCert 后没有有效客户端证书的请求被拒绝
+func (s *NodeService) VerifyNodeClientCert(nodeId string, state *tls.ConnectionState) error {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		var settingService SettingService
+		required, _ := settingService.GetNodeRequireClientCert()
+		if required {
+			return common.NewError("client certificate required")
+		}
+		return nil
+	}
+
+	cert := state.VerifiedChains[0][0]
+	if cert.Subject.CommonName != nodeId {
+		return common.NewError("client certificate does not belong to node")
+	}
+	db := database.GetDB()
+	var count int64
+	err := db.Model(&model.NodeCert{}).
+		Where("node_id = ? AND serial = ? AND revoked = ?", nodeId, cert.SerialNumber.Text(16), false).
+		Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return common.NewError("client certificate revoked")
+	}
+	return nil
+}
+
+// NodeTLSConfig 节点 API 的 TLS 配置：CA 签发的服务端证书，并请求和校验客户端证书
+func (s *NodeService) NodeTLSConfig() (*tls.Config, error) {
+	caCert, caKey, err := s.loadNodeCA()
+	if err != nil {
+		return nil, err
+	}
+	if _, err = s.nodeServerCertificate(caCert, caKey); err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(caCert)
+	return &tls.Config{
+		// 每次握手时检查有效期，长期运行的主节点不会使用过期的证书
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return s.nodeServerCertificate(caCert, caKey)
+		},
+		ClientAuth: tls.VerifyClientCertIfGiven,
+		ClientCAs:  pool,
+	}, nil
+}
+
+// nodeServerCertificate 获取节点 API 的服务端证书，首次调用或剩余有效期不足 nodeCertRenewBefore 时由 CA 签发
+// 续签失败时继续使用未过期的旧证书
+func (s *NodeService) nodeServerCertificate(caCert *x509.Certificate, caKey *ecdsa.PrivateKey) (*tls.Certificate, error) {
+	nodeServerCertMutex.Lock()
+	defer nodeServerCertMutex.Unlock()
+	if nodeServerCert != nil && time.Until(nodeServerCert.Leaf.NotAfter) >= nodeCertRenewBefore {
+		return nodeServerCert, nil
+	}
+
+	cert, err := issueNodeServerCert(caCert, caKey)
+	if err != nil {
+		if nodeServerCert != nil && time.Now().Before(nodeServerCert.Leaf.NotAfter) {
+			logger.Warning("Failed to renew node server certificate: ", err)
+			return nodeServerCert, nil
+		}
+		return nil, err
+	}
+	nodeServerCert = cert
+	return nodeServerCert, nil
+}
+
+// issueNodeServerCert 由 CA 签发节点 API 的服务端证书
+func issueNodeServerCert(caCert *x509.Certificate, caKey *ecdsa.PrivateKey) (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: newCertSerial(),
+		Subject:      pkix.Name{CommonName: NodeTLSServerName},
+		DNSNames:     []string{NodeTLSServerName},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(nodeCertValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{
+		Certificate: [][]byte{der, caCert.Raw},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
+
+// ========== 从节点 ==========
+
+// NewNodeCertRequest 生成从节点私钥和证书签名请求 (PEM)
+func NewNodeCertRequest(nodeId string) (string, string, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: nodeId},
+	}, key)
+	if err != nil {
+		return "", "", err
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return "", "", err
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	csrPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+	return string(keyPEM), string(csrPEM), nil
+}
+
+// NodeClientTLSConfig 从节点连接主节点的 TLS 配置：只信任主节点 CA，客户端证书由 getCert 提供 (续签后无需重建连接池)
+func NodeClientTLSConfig(caPEM string, getCert func(*tls.CertificateRequestInfo) (*tls.Certificate, error)) (*tls.Config, error) {
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM([]byte(caPEM)) {
+		return nil, common.NewError("invalid master CA certificate")
+	}
+	return &tls.Config{
+		RootCAs:              pool,
+		ServerName:           NodeTLSServerName,
+		GetClientCertificate: getCert,
+	}, nil
+}
+
+// nodeCertNeedsRenew 客户端证书是否不存在、无法解析或即将过期
+func nodeCertNeedsRenew(certPEM string) bool {
+	block, _ := pem.Decode([]byte(certPEM))
+	if block == nil {
+		return true
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return true
+	}
+	return time.Until(cert.NotAfter) < nodeCertRenewBefore
+}
+
+func parseCertAndKey(certPEM, keyPEM []byte) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	certBlock, _ := pem.Decode(certPEM)
+	keyBlock, _ := pem.Decode(keyPEM)
+	if certBlock == nil || keyBlock == nil {
+		return nil, nil, common.NewError("invalid node CA")
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := x509.ParseECPrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+func newCertSerial() *big.Int {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return big.NewInt(time.Now().UnixNano())
+	}
+	return serial
+}
@@ -0,0 +1,549 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alireza0/s-ui/database"
+	"github.com/alireza0/s-ui/database/model"
+	"github.com/alireza0/s-ui/logger"
+	"github.com/alireza0/s-ui/util/common"
+
+	"gorm.io/gorm"
+)
+
+// 远程命令
+const (
+	NodeCommandRestartCore = "restartCore"
+	NodeCommandRestartApp  = "restartApp"
+	NodeCommandResync      = "resync"
+	NodeCommandLogs        = "logs"
+	NodeCommandKeypair     = "keypair"
+)
+
+// nodeCommands 支持的远程命令
+var nodeCommands = map[string]bool{
+	NodeCommandRestartCore: true,
+	NodeCommandRestartApp:  true,
+	NodeCommandResync:      true,
+	NodeCommandLogs:        true,
+	NodeCommandKeypair:     true,
+}
+
+// 远程命令状态
+const (
+	NodeCommandPending = "pending"
+	NodeCommandSent    = "sent"
+	NodeCommandSuccess = "success"
+	NodeCommandFailed  = "failed"
+	NodeCommandExpired = "expired"
+)
+
+const (
+	// 节点一直未取走的命令过期时间 (秒)
+	nodeCommandPendingTTL = 3600
+	// 已下发但未回报结果的命令过期时间 (秒，从首次下发开始计算)
+	nodeCommandSentTTL = 600
+	// 已下发但未回报结果的命令重新下发的间隔 (秒)
+	nodeCommandRedeliver = 60
+	// 已结束命令的保留天数
+	nodeCommandAge = 30
+	// 结果最大长度 (字节)
+	nodeCommandMaxResult = 64 * 1024
+	// 日志命令最多返回的行数
+	nodeCommandMaxLogs = 1000
+)
+
+// nodeCommandSignals 等待命令的从节点 (节点 ID -> 通知通道)，新命令入队时关闭通道唤醒推送请求
+var nodeCommandSignals = struct {
+	sync.Mutex
+	chans map[string]chan struct{}
+}{chans: make(map[string]chan struct{})}
+
+// NodeCommandSignal 获取节点的新命令通知通道
+func (s *NodeService) NodeCommandSignal(nodeId string) <-chan struct{} {
+	nodeCommandSignals.Lock()
+	defer nodeCommandSignals.Unlock()
+	ch, ok := nodeCommandSignals.chans[nodeId]
+	if !ok {
+		ch = make(chan struct{})
+		nodeCommandSignals.chans[nodeId] = ch
+	}
+	return ch
+}
+
+// notifyNodeCommand 唤醒等待新命令的从节点，需在事务提交后调用
+func notifyNodeCommand(nodeId string) {
+	nodeCommandSignals.Lock()
+	defer nodeCommandSignals.Unlock()
+	if ch, ok := nodeCommandSignals.chans[nodeId]; ok {
+		close(ch)
+		delete(nodeCommandSignals.chans, nodeId)
+	}
+}
+
+// ========== 主节点 ==========
+
+// QueueNodeCommand 为节点添加远程命令 (管理 API)，同时记录到变更日志
+func (s *NodeService) QueueNodeCommand(nodeId string, command string, args json.RawMessage, actor string) (*model.NodeCommand, error) {
+	if !nodeCommands[command] {
+		return nil, common.NewError("unknown node command: ", command)
+	}
+	if len(args) == 0 {
+		args = json.RawMessage("{}")
+	} else if !json.Valid(args) {
+		return nil, common.NewError("invalid command arguments")
+	}
+
+	var err error
+	db := database.GetDB()
+	tx := db.Begin()
+	defer func() {
+		if err == nil {
+			tx.Commit()
+			notifyNodeCommand(nodeId)
+		} else {
+			tx.Rollback()
+		}
+	}()
+
+	var count int64
+	if err = tx.Model(&model.Node{}).Where("node_id = ?", nodeId).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		err = common.NewError("node not found: ", nodeId)
+		return nil, err
+	}
+
+	now := time.Now().Unix()
+	cmd := &model.NodeCommand{
+		NodeId:    nodeId,
+		Command:   command,
+		Args:      args,
+		Status:    NodeCommandPending,
+		Actor:     actor,
+		CreatedAt: now,
+	}
+	if err = tx.Create(cmd).Error; err != nil {
+		return nil, err
+	}
+	if err = auditNodeCommand(tx, actor, "queue", cmd); err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
+
+// auditNodeCommand 记录命令变更
+func auditNodeCommand(tx *gorm.DB, actor string, action string, cmd *model.NodeCommand) error {
+	obj, err := json.Marshal(map[string]interface{}{
+		"id":      cmd.Id,
+		"nodeId":  cmd.NodeId,
+		"command": cmd.Command,
+		"args":    cmd.Args,
+		"status":  cmd.Status,
+	})
+	if err != nil {
+		return err
+	}
+	return tx.Create(&model.Changes{
+		DateTime: time.Now().Unix(),
+		Actor:    actor,
+		Key:      "nodeCommands",
+		Action:   action,
+		Obj:      obj,
+	}).Error
+}
+
+// ExpireNodeCommands 将超时的命令标记为过期，并清理过旧的命令记录 (定时任务调用)
+func (s *NodeService) ExpireNodeCommands() error {
+	db := database.GetDB()
+	now := time.Now().Unix()
+	err := db.Model(&model.NodeCommand{}).
+		Where("(status = ? AND created_at < ?) OR (status = ? AND sent_at < ?)",
+			NodeCommandPending, now-nodeCommandPendingTTL, NodeCommandSent, now-nodeCommandSentTTL).
+		Updates(map[string]interface{}{
+			"status":      NodeCommandExpired,
+			"finished_at": now,
+		}).Error
+	if err != nil {
+		return err
+	}
+	return db.Where("created_at < ?", now-nodeCommandAge*86400).Delete(&model.NodeCommand{}).Error
+}
+
+// deliverableNodeCommands 节点需要下发的命令：未过期的待下发命令，以及下发后超过 nodeCommandRedeliver 秒仍未回报结果的命令
+func deliverableNodeCommands(tx *gorm.DB, nodeId string, now int64) *gorm.DB {
+	return tx.Model(&model.NodeCommand{}).Where("node_id = ?", nodeId).
+		Where("(status = ? AND created_at >= ?) OR (status = ? AND sent_at >= ? AND delivered_at < ?)",
+			NodeCommandPending, now-nodeCommandPendingTTL, NodeCommandSent, now-nodeCommandSentTTL, now-nodeCommandRedeliver)
+}
+
+// HasPendingNodeCommands 节点是否有需要下发的命令
+func (s *NodeService) HasPendingNodeCommands(nodeId string) (bool, error) {
+	db := database.GetDB()
+	var count int64
+	err := deliverableNodeCommands(db, nodeId, time.Now().Unix()).Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// TakeNodeCommands 取出节点需要执行的命令并记录下发时间
+// 命令在从节点回报结果前视为未确认，下发响应丢失时会重新下发；从节点按命令 ID 去重，不会重复执行
+func (s *NodeService) TakeNodeCommands(nodeId string) ([]model.NodeCommand, error) {
+	var err error
+	db := database.GetDB()
+	tx := db.Begin()
+	defer func() {
+		if err == nil {
+			tx.Commit()
+		} else {
+			tx.Rollback()
+		}
+	}()
+
+	now := time.Now().Unix()
+	commands := []model.NodeCommand{}
+	err = deliverableNodeCommands(tx, nodeId, now).Order("id asc").Find(&commands).Error
+	if err != nil || len(commands) == 0 {
+		return commands, err
+	}
+
+	ids := make([]uint, len(commands))
+	for i := range commands {
+		ids[i] = commands[i].Id
+		if commands[i].Status == NodeCommandPending {
+			commands[i].Status = NodeCommandSent
+			commands[i].SentAt = now
+		}
+		commands[i].DeliveredAt = now
+	}
+	err = tx.Model(&model.NodeCommand{}).Where("id in ? AND status = ?", ids, NodeCommandPending).
+		Updates(map[string]interface{}{
+			"status":  NodeCommandSent,
+			"sent_at": now,
+		}).Error
+	if err != nil {
+		return nil, err
+	}
+	err = tx.Model(&model.NodeCommand{}).Where("id in ?", ids).Update("delivered_at", now).Error
+	if err != nil {
+		return nil, err
+	}
+	return commands, nil
+}
+
+// CompleteNodeCommand 记录从节点回报的命令结果
+func (s *NodeService) CompleteNodeCommand(nodeId string, id uint, success bool, result string) error {
+	var err error
+	db := database.GetDB()
+	tx := db.Begin()
+	defer func() {
+		if err == nil {
+			tx.Commit()
+		} else {
+			tx.Rollback()
+		}
+	}()
+
+	var cmd model.NodeCommand
+	err = tx.Where("id = ? AND node_id = ?", id, nodeId).First(&cmd).Error
+	if err != nil {
+		return err
+	}
+	// 已过期的命令仍接受结果，重复回报时忽略
+	if cmd.Status != NodeCommandSent && cmd.Status != NodeCommandExpired {
+		return nil
+	}
+
+	if len(result) > nodeCommandMaxResult {
+		result = result[:nodeCommandMaxResult]
+	}
+	cmd.Status = NodeCommandFailed
+	if success {
+		cmd.Status = NodeCommandSuccess
+	}
+	cmd.Result = result
+	cmd.FinishedAt = time.Now().Unix()
+	err = tx.Model(&model.NodeCommand{}).Where("id = ?", cmd.Id).Updates(map[string]interface{}{
+		"status":      cmd.Status,
+		"result":      cmd.Result,
+		"finished_at": cmd.FinishedAt,
+	}).Error
+	if err != nil {
+		return err
+	}
+	err = auditNodeCommand(tx, nodeId, cmd.Status, &cmd)
+	return err
+}
+
+// GetNodeCommands 获取命令记录 (最新的在前)，nodeId 为空时返回所有节点
+func (s *NodeService) GetNodeCommands(nodeId string, limit int) ([]model.NodeCommand, error) {
+	db := database.GetDB()
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	query := db.Model(&model.NodeCommand{})
+	if nodeId != "" {
+		query = query.Where("node_id = ?", nodeId)
+	}
+	commands := []model.NodeCommand{}
+	err := query.Order("id desc").Limit(limit).Find(&commands).Error
+	if err != nil {
+		return nil, err
+	}
+	return commands, nil
+}
+
+// ========== 从节点 ==========
+
+// nodeCommandResult 待回报的命令结果
+type nodeCommandResult struct {
+	Id      uint   `json:"id"`
+	Success bool   `json:"success"`
+	Result  string `json:"result"`
+}
+
+// doneNodeCommand 已执行的命令，主节点没有收到结果而重新下发时只重新回报结果
+type doneNodeCommand struct {
+	Result nodeCommandResult `json:"result"`
+	DoneAt int64             `json:"doneAt"`
+}
+
+// runCommands 依次执行主节点下发的命令并回报结果 (心跳和推送通道都可能下发命令)
+func (s *SyncService) runCommands(raw interface{}) {
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return
+	}
+	var commands []model.NodeCommand
+	if err = json.Unmarshal(data, &commands); err != nil {
+		logger.Warning("Invalid node commands: ", err)
+		return
+	}
+	if len(commands) == 0 {
+		return
+	}
+
+	s.commandMutex.Lock()
+	defer s.commandMutex.Unlock()
+	s.loadDoneCommands()
+	s.pruneDoneCommands()
+	for _, cmd := range commands {
+		if done, ok := s.doneCommands[cmd.Id]; ok {
+			s.reportCommandResult(done.Result)
+			continue
+		}
+		logger.Info("Running node command ", cmd.Id, ": ", cmd.Command)
+		if cmd.Command == NodeCommandRestartApp {
+			s.restartApp(cmd.Id)
+			continue
+		}
+		output, err := s.executeCommand(&cmd)
+		result := nodeCommandResult{Id: cmd.Id, Success: err == nil, Result: output}
+		if err != nil {
+			logger.Warning("Node command ", cmd.Command, " failed: ", err)
+			result.Result = err.Error()
+		}
+		s.finishCommand(result)
+	}
+}
+
+// restartApp 先保存并回报结果再重启，重启后主节点重新下发同一命令时不会再次重启
+func (s *SyncService) restartApp(id uint) {
+	s.finishCommand(nodeCommandResult{Id: id, Success: true, Result: "restarting in 3 seconds"})
+	var panelService PanelService
+	if err := panelService.RestartPanel(3 * time.Second); err != nil {
+		logger.Warning("Node command ", NodeCommandRestartApp, " failed: ", err)
+		s.finishCommand(nodeCommandResult{Id: id, Success: false, Result: err.Error()})
+	}
+}
+
+// finishCommand 保存已执行的命令后回报结果，调用时需持有 commandMutex
+func (s *SyncService) finishCommand(result nodeCommandResult) {
+	s.doneCommands[result.Id] = &doneNodeCommand{Result: result, DoneAt: time.Now().Unix()}
+	s.saveDoneCommands()
+	s.reportCommandResult(result)
+}
+
+// loadDoneCommands 首次执行命令时加载重启前保存的已执行命令，调用时需持有 commandMutex
+func (s *SyncService) loadDoneCommands() {
+	if s.doneCommands != nil {
+		return
+	}
+	s.doneCommands = make(map[uint]*doneNodeCommand)
+	data, err := s.configService.SettingService.GetNodeDoneCommands()
+	if err != nil {
+		logger.Warning("Failed to load executed node commands: ", err)
+		return
+	}
+	var done []*doneNodeCommand
+	if err = json.Unmarshal([]byte(data), &done); err != nil {
+		logger.Warning("Invalid executed node commands: ", err)
+		return
+	}
+	for _, d := range done {
+		s.doneCommands[d.Result.Id] = d
+	}
+}
+
+// saveDoneCommands 保存已执行的命令，调用时需持有 commandMutex
+func (s *SyncService) saveDoneCommands() {
+	done := make([]*doneNodeCommand, 0, len(s.doneCommands))
+	for _, d := range s.doneCommands {
+		done = append(done, d)
+	}
+	data, err := json.Marshal(done)
+	if err == nil {
+		err = s.configService.SettingService.SetNodeDoneCommands(string(data))
+	}
+	if err != nil {
+		logger.Warning("Failed to save executed node commands: ", err)
+	}
+}
+
+// pruneDoneCommands 清理主节点不会再下发的已执行命令，调用时需持有 commandMutex
+func (s *SyncService) pruneDoneCommands() {
+	pruned := false
+	for id, done := range s.doneCommands {
+		if time.Now().Unix()-done.DoneAt > 2*nodeCommandSentTTL {
+			delete(s.doneCommands, id)
+			pruned = true
+		}
+	}
+	if pruned {
+		s.saveDoneCommands()
+	}
+}
+
+// executeCommand 执行一条命令，返回输出
+func (s *SyncService) executeCommand(cmd *model.NodeCommand) (string, error) {
+	var args struct {
+		Count   int    `json:"count"`
+		Level   string `json:"level"`
+		Type    string `json:"type"`
+		Options string `json:"options"`
+	}
+	if len(cmd.Args) > 0 {
+		if err := json.Unmarshal(cmd.Args, &args); err != nil {
+			return "", common.NewError("invalid command arguments: ", err.Error())
+		}
+	}
+
+	switch cmd.Command {
+	case NodeCommandRestartCore:
+		if err := s.configService.RestartCore(); err != nil {
+			return "", err
+		}
+		return "core restarted", nil
+	case NodeCommandResync:
+		if err := s.fullSync(); err != nil {
+			return "", err
+		}
+		_, hash := s.getLocalVersion()
+		return "synced version " + hash, nil
+	case NodeCommandLogs:
+		count := args.Count
+		if count <= 0 {
+			count = 100
+		}
+		count = min(count, nodeCommandMaxLogs)
+		level := args.Level
+		if level == "" {
+			level = "info"
+		}
+		var serverService ServerService
+		return strings.Join(serverService.GetLogs(strconv.Itoa(count), level), "\n"), nil
+	case NodeCommandKeypair:
+		var serverService ServerService
+		return strings.Join(serverService.GenKeypair(args.Type, args.Options), "\n"), nil
+	}
+	return "", common.NewError("unknown node command: ", cmd.Command)
+}
+
+// fullSync 丢弃本地版本，从主节点全量同步配置
+func (s *SyncService) fullSync() error {
+	s.configMutex.Lock()
+	s.localVersion = 0
+	s.localHash = ""
+	s.configMutex.Unlock()
+	return s.syncConfig()
+}
+
+// reportCommandResult 回报命令结果，请求失败时保存下来在下一次心跳时重试 (主节点拒绝的结果不再重试)
+func (s *SyncService) reportCommandResult(result nodeCommandResult) {
+	resp, err := s.doRequest("POST", "/node/commands/result", result, true)
+	if err == nil {
+		if !resp.Success {
+			logger.Warning("Node command result rejected: ", resp.Msg)
+		}
+		return
+	}
+	logger.Warning("Failed to report node command result: ", err)
+	s.resultMutex.Lock()
+	defer s.resultMutex.Unlock()
+	results := append(s.loadPendingResults(), result)
+	s.savePendingResults(results)
+}
+
+// flushCommandResults 重试之前回报失败的命令结果 (包括重启前没有回报成功的结果)
+func (s *SyncService) flushCommandResults() {
+	s.resultMutex.Lock()
+	results := s.loadPendingResults()
+	if len(results) > 0 {
+		s.savePendingResults(nil)
+	}
+	s.resultMutex.Unlock()
+
+	for _, result := range results {
+		s.reportCommandResult(result)
+	}
+}
+
+// loadPendingResults 读取回报失败的命令结果，调用时需持有 resultMutex
+func (s *SyncService) loadPendingResults() []nodeCommandResult {
+	data, err := s.configService.SettingService.GetNodePendingResults()
+	if err != nil {
+		logger.Warning("Failed to load pending node command results: ", err)
+		return nil
+	}
+	var results []nodeCommandResult
+	if err = json.Unmarshal([]byte(data), &results); err != nil {
+		logger.Warning("Invalid pending node command results: ", err)
+		return nil
+	}
+	return results
+}
+
+// savePendingResults 保存回报失败的命令结果，调用时需持有 resultMutex
+func (s *SyncService) savePendingResults(results []nodeCommandResult) {
+	if results == nil {
+		results = []nodeCommandResult{}
+	}
+	data, err := json.Marshal(results)
+	if err == nil {
+		err = s.configService.SettingService.SetNodePendingResults(string(data))
+	}
+	if err != nil {
+		logger.Warning("Failed to save pending node command results: ", err)
+	}
+}
+
+// pullCommands 通过推送通道得知有新命令时主动获取
+func (s *SyncService) pullCommands() error {
+	resp, err := s.doRequest("POST", "/node/commands", nil, true)
+	if err != nil {
+		return err
+	}
+	if !resp.Success {
+		return fmt.Errorf("get commands failed: %s", resp.Msg)
+	}
+	s.runCommands(resp.Obj)
+	return nil
+}
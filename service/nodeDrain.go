@@ -0,0 +1,90 @@
+package service
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/alireza0/s-ui/database"
+	"github.com/alireza0/s-ui/database/model"
+	"github.com/alireza0/s-ui/logger"
+)
+
+// nodeDrainIdle 排空期间连续多少秒没有读写的连接视为空闲并关闭
+const nodeDrainIdle = 60
+
+// NodeDrain 下发给从节点的排空状态
+type NodeDrain struct {
+	Since int64 `json:"since"`
+	Grace int64 `json:"grace"`
+}
+
+// ========== 主节点 ==========
+
+// SetNodeDrain 开启或结束节点的维护 (排空) 模式
+// 排空中的节点继续同步配置和服务现有连接，但不再出现在新生成的订阅中
+func (s *NodeService) SetNodeDrain(id uint, drain bool, grace int64) error {
+	db := database.GetDB()
+	updates := map[string]interface{}{
+		"draining":    drain,
+		"drain_since": int64(0),
+		"drain_grace": int64(0),
+		"drained_at":  int64(0),
+	}
+	if drain {
+		updates["drain_since"] = time.Now().Unix()
+		updates["drain_grace"] = max(grace, 0)
+	}
+	return db.Model(&model.Node{}).Where("id = ?", id).Updates(updates).Error
+}
+
+// nodeDrainUpdates 根据心跳上报的连接数更新排空完成时间
+func nodeDrainUpdates(node *model.Node, connections int, now int64, updates map[string]interface{}) {
+	if !node.Draining {
+		return
+	}
+	if connections == 0 && node.DrainedAt == 0 {
+		updates["drained_at"] = now
+		logger.Info("Node drained, no connections left: ", node.NodeId)
+	} else if connections > 0 && node.DrainedAt != 0 {
+		updates["drained_at"] = int64(0)
+	}
+}
+
+// ========== 从节点 ==========
+
+// applyDrain 处理心跳响应中的排空状态，宽限期内关闭空闲连接，到期后关闭所有连接
+func (s *SyncService) applyDrain(raw interface{}) {
+	var drain *NodeDrain
+	if raw != nil {
+		data, err := json.Marshal(raw)
+		if err == nil {
+			json.Unmarshal(data, &drain)
+		}
+	}
+
+	s.mutex.Lock()
+	wasDraining := s.draining
+	s.draining = drain != nil
+	s.mutex.Unlock()
+
+	if drain == nil {
+		if wasDraining {
+			logger.Info("Node drain cancelled by master")
+		}
+		return
+	}
+	if !wasDraining {
+		logger.Info("Node is draining, grace period: ", drain.Grace, "s")
+	}
+	if drain.Grace <= 0 || !corePtr.IsRunning() {
+		return
+	}
+
+	idle := int64(nodeDrainIdle)
+	if time.Now().Unix() >= drain.Since+drain.Grace {
+		idle = 0
+	}
+	if closed := corePtr.GetInstance().ConnTracker().CloseIdleConnections(idle); closed > 0 {
+		logger.Info("Drain closed ", closed, " connections")
+	}
+}
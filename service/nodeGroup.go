@@ -0,0 +1,189 @@
+package service
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+
+	"github.com/alireza0/s-ui/database"
+	"github.com/alireza0/s-ui/database/model"
+	"github.com/alireza0/s-ui/util/common"
+
+	"gorm.io/gorm"
+)
+
+// 节点分组类型
+var nodeGroupKinds = map[string]bool{
+	"":        true,
+	"region":  true,
+	"tier":    true,
+	"purpose": true,
+}
+
+// NodeGroupNames 解析节点所属的分组 (Node.Group 逗号分隔)
+func NodeGroupNames(group string) []string {
+	var names []string
+	for _, name := range strings.Split(group, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// NodeEntitled 判断客户端是否可以使用节点
+// 会员节点只下发给会员客户端；客户端设置了可用分组时，只下发属于其中任一分组的节点
+func NodeEntitled(client *model.Client, node *model.Node) bool {
+	if node.IsPremium && !client.IsPremium {
+		return false
+	}
+	groups := ParseNodeScope(client.NodeGroups)
+	if len(groups) == 0 {
+		return true
+	}
+	for _, name := range NodeGroupNames(node.Group) {
+		if slices.Contains(groups, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetClientNodes 获取客户端可以使用的启用且在线的节点 (订阅生成使用)
+// 排空中的节点不下发；流量预算用满的节点按处理方式移除或只下发给会员客户端
+// 结果按设置中的权重排序，同时返回每个入站最多下发的节点数 (0 表示不限)；
+// 入站可能只分配给部分节点，截取需在按入站过滤之后进行
+func (s *NodeService) GetClientNodes(client *model.Client) ([]model.Node, int, error) {
+	nodes, err := s.GetEnabledOnlineNodes()
+	if err != nil {
+		return nil, 0, err
+	}
+	result := make([]model.Node, 0, len(nodes))
+	for i := range nodes {
+		if nodes[i].ExternalHost == "" || nodes[i].Draining || !NodeEntitled(client, &nodes[i]) {
+			continue
+		}
+		if NodeBudgetExceeded(&nodes[i]) && (NodeBudgetAction(&nodes[i]) != BudgetActionPremium || !client.IsPremium) {
+			continue
+		}
+		result = append(result, nodes[i])
+	}
+
+	var settingService SettingService
+	weights, err := settingService.GetSubNodeRanking()
+	if err != nil {
+		return nil, 0, err
+	}
+	return rankClientNodes(client, result, weights), weights.Limit, nil
+}
+
+// ValidateNodeGroups 检查分组是否都已定义 (外部 API 设置客户端可用分组时使用)
+func (s *NodeService) ValidateNodeGroups(names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+	db := database.GetDB()
+	var existing []string
+	err := db.Model(&model.NodeGroup{}).Where("name in ?", names).Pluck("name", &existing).Error
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if !slices.Contains(existing, name) {
+			return common.NewError("unknown node group: ", name)
+		}
+	}
+	return nil
+}
+
+// GetNodeGroups 获取所有节点分组
+func (s *NodeService) GetNodeGroups() ([]model.NodeGroup, error) {
+	db := database.GetDB()
+	groups := []model.NodeGroup{}
+	err := db.Order("kind asc, name asc").Find(&groups).Error
+	if err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+// SaveNodeGroup 保存节点分组 (管理 API 调用)
+// 分组名被节点和客户端引用，不允许修改；仍被引用的分组不允许删除
+func (s *NodeService) SaveNodeGroup(tx *gorm.DB, act string, data json.RawMessage) error {
+	var err error
+
+	switch act {
+	case "new", "edit":
+		var group model.NodeGroup
+		err = json.Unmarshal(data, &group)
+		if err != nil {
+			return err
+		}
+		group.Name = strings.TrimSpace(group.Name)
+		if group.Name == "" || strings.Contains(group.Name, ",") {
+			return common.NewError("invalid node group name: ", group.Name)
+		}
+		if !nodeGroupKinds[group.Kind] {
+			return common.NewError("unknown node group kind: ", group.Kind)
+		}
+		if act == "edit" {
+			var old model.NodeGroup
+			if err = tx.Where("id = ?", group.Id).First(&old).Error; err != nil {
+				return err
+			}
+			if old.Name != group.Name {
+				return common.NewError("node group name cannot be changed")
+			}
+		}
+		err = tx.Save(&group).Error
+	case "del":
+		var id uint
+		err = json.Unmarshal(data, &id)
+		if err != nil {
+			return err
+		}
+		var group model.NodeGroup
+		if err = tx.Where("id = ?", id).First(&group).Error; err != nil {
+			return err
+		}
+		var inUse bool
+		inUse, err = nodeGroupInUse(tx, group.Name)
+		if err != nil {
+			return err
+		}
+		if inUse {
+			return common.NewError("node group is in use: ", group.Name)
+		}
+		err = tx.Where("id = ?", id).Delete(&model.NodeGroup{}).Error
+	default:
+		return common.NewErrorf("unknown action: %s", act)
+	}
+
+	return err
+}
+
+// nodeGroupInUse 分组是否仍有节点加入或被客户端引用
+func nodeGroupInUse(tx *gorm.DB, name string) (bool, error) {
+	var groups []string
+	err := tx.Model(&model.Node{}).Where("`group` != ''").Pluck("group", &groups).Error
+	if err != nil {
+		return false, err
+	}
+	for _, group := range groups {
+		if slices.Contains(NodeGroupNames(group), name) {
+			return true, nil
+		}
+	}
+
+	var entitlements []json.RawMessage
+	err = tx.Model(&model.Client{}).Where("node_groups IS NOT NULL").Pluck("node_groups", &entitlements).Error
+	if err != nil {
+		return false, err
+	}
+	for _, entitlement := range entitlements {
+		if slices.Contains(ParseNodeScope(entitlement), name) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
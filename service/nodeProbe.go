@@ -0,0 +1,323 @@
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/alireza0/s-ui/database"
+	"github.com/alireza0/s-ui/database/model"
+	"github.com/alireza0/s-ui/logger"
+)
+
+// 节点探测参数
+const (
+	nodeProbeAttempts = 3
+	nodeProbeTimeout  = 5 * time.Second
+	// 探测历史保留天数
+	nodeProbeAge = 7
+	// 同时探测的节点数
+	nodeProbeConcurrency  = 16
+	nodeProbeRoundTimeout = 50 * time.Second
+)
+
+// nodeProbeTarget 节点自身的探测目标名称 (外部地址)
+const nodeProbeTarget = "node"
+
+// udpInboundTypes 基于 UDP 的入站，无法通过 TCP 握手探测
+var udpInboundTypes = map[string]bool{
+	"hysteria":  true,
+	"hysteria2": true,
+	"tuic":      true,
+}
+
+// ProbeResult 一次探测的结果
+type ProbeResult struct {
+	// 成功握手的平均延迟，全部失败时为 0
+	Latency time.Duration
+	Sent    int
+	Lost    int
+}
+
+// Reachable 是否至少有一次握手成功
+func (r ProbeResult) Reachable() bool {
+	return r.Sent > r.Lost
+}
+
+// NodeProber 对地址做若干次 TCP 握手 (可选 TLS 握手) 并统计延迟和丢包
+// 不依赖数据库，可直接对本地监听端口测试
+type NodeProber struct {
+	Attempts int
+	Timeout  time.Duration
+	// 为空时使用 net.Dialer
+	DialContext func(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// NewNodeProber 使用默认参数创建探测器
+func NewNodeProber() *NodeProber {
+	return &NodeProber{
+		Attempts: nodeProbeAttempts,
+		Timeout:  nodeProbeTimeout,
+	}
+}
+
+// Probe 探测地址，serverName 非空时在 TCP 连接建立后完成 TLS 握手
+func (p *NodeProber) Probe(ctx context.Context, address string, serverName string) ProbeResult {
+	result := ProbeResult{}
+	var total time.Duration
+	for i := 0; i < p.Attempts; i++ {
+		if ctx.Err() != nil {
+			break
+		}
+		result.Sent++
+		latency, err := p.handshake(ctx, address, serverName)
+		if err != nil {
+			// 整轮探测被取消时不计为丢包
+			if ctx.Err() != nil {
+				result.Sent--
+				break
+			}
+			result.Lost++
+			continue
+		}
+		total += latency
+	}
+	if succeeded := result.Sent - result.Lost; succeeded > 0 {
+		result.Latency = total / time.Duration(succeeded)
+	}
+	return result
+}
+
+func (p *NodeProber) handshake(ctx context.Context, address string, serverName string) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.Timeout)
+	defer cancel()
+
+	dial := p.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+
+	start := time.Now()
+	conn, err := dial(ctx, "tcp", address)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	if serverName != "" {
+		tlsConn := tls.Client(conn, &tls.Config{
+			ServerName: serverName,
+			// 只探测可达性，不校验证书 (自签名证书和 reality 同样需要能探测)
+			InsecureSkipVerify: true,
+		})
+		if err = tlsConn.HandshakeContext(ctx); err != nil {
+			return 0, err
+		}
+	}
+	return time.Since(start), nil
+}
+
+// nodeProbeSpec 某个节点需要探测的一个地址
+type nodeProbeSpec struct {
+	target     string
+	address    string
+	serverName string
+}
+
+// ProbeNodes 探测所有启用节点的外部地址，记录延迟和丢包历史 (定时任务调用)
+// 心跳正常但外部全部不可达的节点标记为 degraded，恢复可达后重新标记为 online
+func (s *NodeService) ProbeNodes(prober *NodeProber) error {
+	db := database.GetDB()
+	var nodes []model.Node
+	err := db.Where("enable = ?", 1).Find(&nodes).Error
+	if err != nil || len(nodes) == 0 {
+		return err
+	}
+
+	var settingService SettingService
+	useTLS, _ := settingService.GetNodeProbeTLS()
+	probeInbounds, _ := settingService.GetNodeProbeInbounds()
+
+	var inbounds []model.Inbound
+	if probeInbounds {
+		if err = db.Find(&inbounds).Error; err != nil {
+			return err
+		}
+	}
+
+	// 一轮探测需在下一轮 (每分钟) 开始前结束
+	ctx, cancel := context.WithTimeout(context.Background(), nodeProbeRoundTimeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, nodeProbeConcurrency)
+	for i := range nodes {
+		specs := nodeProbeSpecs(&nodes[i], inbounds, useTLS)
+		if len(specs) == 0 {
+			// 没有可探测的地址时清除之前的不可达标记
+			if nodes[i].Unreachable {
+				err = s.clearUnreachable(nodes[i].NodeId)
+				if err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		wg.Add(1)
+		go func(node *model.Node, specs []nodeProbeSpec) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			if err := s.probeNode(ctx, prober, node, specs); err != nil {
+				logger.Warning("probe node ", node.NodeId, " failed: ", err)
+			}
+		}(&nodes[i], specs)
+	}
+	wg.Wait()
+
+	return db.Where("date_time < ?", time.Now().AddDate(0, 0, -nodeProbeAge).Unix()).Delete(&model.NodeProbe{}).Error
+}
+
+// nodeProbeSpecs 获取节点的探测目标
+// 配置了外部端口时探测外部地址；开启入站探测时额外探测分配给该节点的 TCP 入站端口
+func nodeProbeSpecs(node *model.Node, inbounds []model.Inbound, useTLS bool) []nodeProbeSpec {
+	host := node.ExternalHost
+	if host == "" {
+		host = node.Address
+	}
+	if host == "" {
+		return nil
+	}
+
+	var specs []nodeProbeSpec
+	if node.ExternalPort > 0 {
+		spec := nodeProbeSpec{
+			target:  nodeProbeTarget,
+			address: net.JoinHostPort(host, strconv.Itoa(node.ExternalPort)),
+		}
+		if useTLS {
+			spec.serverName = host
+		}
+		specs = append(specs, spec)
+	}
+
+	for _, inbound := range inbounds {
+		if udpInboundTypes[inbound.Type] || !InNodeScope(inbound.Nodes, node.NodeId, node.Group) {
+			continue
+		}
+		var options struct {
+			ListenPort int `json:"listen_port"`
+		}
+		json.Unmarshal(inbound.Options, &options)
+		if options.ListenPort == 0 {
+			continue
+		}
+		spec := nodeProbeSpec{
+			target:  inbound.Tag,
+			address: net.JoinHostPort(host, strconv.Itoa(options.ListenPort)),
+		}
+		if inbound.TlsId > 0 {
+			spec.serverName = host
+		}
+		specs = append(specs, spec)
+	}
+	return specs
+}
+
+// probeNode 探测一个节点的所有目标并更新节点延迟和可达状态
+func (s *NodeService) probeNode(ctx context.Context, prober *NodeProber, node *model.Node, specs []nodeProbeSpec) error {
+	now := time.Now().Unix()
+	probes := make([]model.NodeProbe, 0, len(specs))
+	reachable := false
+	latency := 0
+	for _, spec := range specs {
+		result := prober.Probe(ctx, spec.address, spec.serverName)
+		// 本轮探测超时未能发起的目标不计入结果
+		if result.Sent == 0 {
+			continue
+		}
+		probe := model.NodeProbe{
+			NodeId:   node.NodeId,
+			DateTime: now,
+			Target:   spec.target,
+			Address:  spec.address,
+			Latency:  int(result.Latency.Milliseconds()),
+			Sent:     result.Sent,
+			Lost:     result.Lost,
+		}
+		probes = append(probes, probe)
+		if result.Reachable() {
+			// 节点延迟优先使用外部地址的结果，否则取第一个可达入站的结果
+			if !reachable || spec.target == nodeProbeTarget {
+				latency = probe.Latency
+			}
+			reachable = true
+		}
+	}
+
+	if len(probes) == 0 {
+		return nil
+	}
+
+	var err error
+	db := database.GetDB()
+	tx := db.Begin()
+	defer func() {
+		if err == nil {
+			tx.Commit()
+		} else {
+			tx.Rollback()
+		}
+	}()
+
+	if err = tx.Create(&probes).Error; err != nil {
+		return err
+	}
+	updates := map[string]interface{}{
+		"unreachable": !reachable,
+		"last_probe":  now,
+	}
+	if reachable {
+		updates["latency"] = latency
+	}
+	err = tx.Model(&model.Node{}).Where("node_id = ?", node.NodeId).Updates(updates).Error
+	if err != nil {
+		return err
+	}
+
+	// 只切换心跳正常的节点，离线状态仍由心跳决定
+	if reachable {
+		err = tx.Model(&model.Node{}).Where("node_id = ? AND status = ?", node.NodeId, "degraded").
+			Update("status", "online").Error
+	} else {
+		err = tx.Model(&model.Node{}).Where("node_id = ? AND status = ?", node.NodeId, "online").
+			Update("status", "degraded").Error
+	}
+	return err
+}
+
+// clearUnreachable 清除节点的不可达标记，degraded 节点恢复为 online
+func (s *NodeService) clearUnreachable(nodeId string) error {
+	db := database.GetDB()
+	err := db.Model(&model.Node{}).Where("node_id = ?", nodeId).Update("unreachable", false).Error
+	if err != nil {
+		return err
+	}
+	return db.Model(&model.Node{}).Where("node_id = ? AND status = ?", nodeId, "degraded").
+		Update("status", "online").Error
+}
+
+// GetNodeProbes 获取节点最近的探测历史
+func (s *NodeService) GetNodeProbes(nodeId string, since int64) ([]model.NodeProbe, error) {
+	db := database.GetDB()
+	var probes []model.NodeProbe
+	err := db.Where("node_id = ? AND date_time >= ?", nodeId, since).Order("date_time asc").Find(&probes).Error
+	if err != nil {
+		return nil, err
+	}
+	return probes, nil
+}
@@ -0,0 +1,143 @@
+package service
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alireza0/s-ui/database"
+	"github.com/alireza0/s-ui/database/model"
+)
+
+func newTestProber() *NodeProber {
+	return &NodeProber{
+		Attempts: 3,
+		Timeout:  time.Second,
+	}
+}
+
+// listenLocal 在本地随机端口监听并接受连接，测试结束时关闭
+func listenLocal(t *testing.T) net.Listener {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	return listener
+}
+
+// closedPort 获取一个没有监听的本地端口
+func closedPort(t *testing.T) int {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+	return port
+}
+
+func TestNodeProberLocalListener(t *testing.T) {
+	listener := listenLocal(t)
+
+	result := newTestProber().Probe(context.Background(), listener.Addr().String(), "")
+	if result.Sent != 3 || result.Lost != 0 {
+		t.Fatalf("sent/lost = %d/%d, want 3/0", result.Sent, result.Lost)
+	}
+	if !result.Reachable() {
+		t.Fatal("local listener is not reachable")
+	}
+	if result.Latency <= 0 || result.Latency >= time.Second {
+		t.Fatalf("latency = %v, want between 0 and the timeout", result.Latency)
+	}
+}
+
+func TestNodeProberClosedPort(t *testing.T) {
+	address := net.JoinHostPort("127.0.0.1", strconv.Itoa(closedPort(t)))
+
+	result := newTestProber().Probe(context.Background(), address, "")
+	if result.Sent != 3 || result.Lost != 3 {
+		t.Fatalf("sent/lost = %d/%d, want 3/3", result.Sent, result.Lost)
+	}
+	if result.Reachable() {
+		t.Fatal("closed port is reachable")
+	}
+	if result.Latency != 0 {
+		t.Fatalf("latency = %v, want 0", result.Latency)
+	}
+}
+
+func TestProbeNodesMarksDegraded(t *testing.T) {
+	initTestDB(t)
+	db := database.GetDB()
+
+	// 心跳正常但外部端口不可达的节点
+	node := model.Node{
+		NodeId:       "probe-test",
+		Name:         "probe-test",
+		Token:        "token",
+		Enable:       true,
+		Status:       "online",
+		LastSeen:     time.Now().Unix(),
+		ExternalHost: "127.0.0.1",
+		ExternalPort: closedPort(t),
+	}
+	if err := db.Create(&node).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	var nodeService NodeService
+	err := nodeService.ProbeNodes(newTestProber())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = db.Where("node_id = ?", node.NodeId).First(&node).Error; err != nil {
+		t.Fatal(err)
+	}
+	if node.Status != "degraded" || !node.Unreachable {
+		t.Fatalf("status = %s, unreachable = %t, want degraded and unreachable", node.Status, node.Unreachable)
+	}
+	probes, err := nodeService.GetNodeProbes(node.NodeId, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(probes) != 1 || probes[0].Target != nodeProbeTarget || probes[0].Sent != 3 || probes[0].Lost != 3 || probes[0].Latency != 0 {
+		t.Fatalf("unexpected probes: %+v", probes)
+	}
+
+	// 恢复可达后重新标记为 online 并记录延迟
+	listener := listenLocal(t)
+	node.ExternalPort = listener.Addr().(*net.TCPAddr).Port
+	if err = db.Model(&node).Update("external_port", node.ExternalPort).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err = nodeService.ProbeNodes(newTestProber()); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.Where("node_id = ?", node.NodeId).First(&node).Error; err != nil {
+		t.Fatal(err)
+	}
+	if node.Status != "online" || node.Unreachable {
+		t.Fatalf("status = %s, unreachable = %t, want online and reachable", node.Status, node.Unreachable)
+	}
+	probes, err = nodeService.GetNodeProbes(node.NodeId, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(probes) != 2 || probes[0].Lost+probes[1].Lost != 3 {
+		t.Fatalf("unexpected probes: %+v", probes)
+	}
+}
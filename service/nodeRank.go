@@ -0,0 +1,94 @@
+package service
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"math"
+	"sort"
+
+	"github.com/alireza0/s-ui/database/model"
+)
+
+// NodeRankWeights 订阅中节点排序的权重 (设置中配置)
+// 分数越低越靠前；权重全为 0 时保持数据库顺序
+type NodeRankWeights struct {
+	Load     float64 // 实时负载: 连接数占容量的比例和 CPU 使用率
+	Latency  float64 // 主节点探测的延迟
+	Capacity float64 // 节点容量，容量越大越靠前
+	Spread   float64 // 按客户端 UUID 打散，使分数相近的节点分摊到不同客户端
+	Limit    int     // 每个入站最多下发的节点数 (按排序取运行该入站的前几个节点)，0 表示不限
+}
+
+// nodeLoad 心跳上报的负载 (保存在 system_info 中)
+type nodeLoad struct {
+	CPU         float64 `json:"cpu"`
+	Connections int     `json:"connections"`
+}
+
+// rankClientNodes 按权重为客户端排序节点
+// 同一客户端在节点状态不变时得到的顺序不变
+func rankClientNodes(client *model.Client, nodes []model.Node, weights NodeRankWeights) []model.Node {
+	if len(nodes) == 0 {
+		return nodes
+	}
+
+	loads := make([]nodeLoad, len(nodes))
+	maxConnections, maxLatency, maxCapacity := 0, 0, 0
+	for i := range nodes {
+		json.Unmarshal(nodes[i].SystemInfo, &loads[i])
+		maxConnections = max(maxConnections, loads[i].Connections)
+		maxLatency = max(maxLatency, nodes[i].Latency)
+		maxCapacity = max(maxCapacity, nodes[i].Capacity)
+	}
+
+	scores := make(map[string]float64, len(nodes))
+	for i := range nodes {
+		node := &nodes[i]
+
+		// 设置了容量时按连接数占容量的比例计算，否则与负载最高的节点比较
+		connections := 0.0
+		if node.Capacity > 0 {
+			connections = float64(loads[i].Connections) / float64(node.Capacity)
+		} else if maxConnections > 0 {
+			connections = float64(loads[i].Connections) / float64(maxConnections)
+		}
+		load := (connections + loads[i].CPU/100) / 2
+
+		// 未探测到延迟或未设置容量的节点取中间值
+		latency := 0.5
+		if node.Latency > 0 && maxLatency > 0 {
+			latency = float64(node.Latency) / float64(maxLatency)
+		}
+		capacity := 0.5
+		if node.Capacity > 0 && maxCapacity > 0 {
+			capacity = 1 - float64(node.Capacity)/float64(maxCapacity)
+		}
+
+		scores[node.NodeId] = weights.Load*load +
+			weights.Latency*latency +
+			weights.Capacity*capacity +
+			weights.Spread*nodeSpread(client.UUID, node.NodeId)
+	}
+
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return scores[nodes[i].NodeId] < scores[nodes[j].NodeId]
+	})
+	return nodes
+}
+
+// nodeSpread 客户端与节点组合的稳定随机值 [0, 1)，节点增减不影响其他节点的相对顺序
+func nodeSpread(uuid string, nodeId string) float64 {
+	h := fnv.New64a()
+	h.Write([]byte(uuid))
+	h.Write([]byte{0})
+	h.Write([]byte(nodeId))
+	return float64(h.Sum64()>>11) / float64(1<<53)
+}
+
+// normalizeRankWeight 权重必须是非负有限数
+func normalizeRankWeight(weight float64) float64 {
+	if weight < 0 || math.IsNaN(weight) || math.IsInf(weight, 0) {
+		return 0
+	}
+	return weight
+}
@@ -0,0 +1,254 @@
+package service
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"strconv"
+	"sync"
+
+	"github.com/alireza0/s-ui/config"
+	"github.com/alireza0/s-ui/database"
+	"github.com/alireza0/s-ui/database/model"
+	"github.com/alireza0/s-ui/logger"
+	"github.com/alireza0/s-ui/util/common"
+
+	"gorm.io/gorm"
+)
+
+// 配置签名
+// 主节点首次启动时生成 ed25519 密钥，对下发给每个从节点的配置签名；
+// 从节点只在注册时 (或由运维通过 SUI_MASTER_SIGN_KEY 指定) 固定主节点公钥，签名缺失或校验失败的配置不会应用，继续使用上一次的配置
+// 签名包含每个节点递增的配置序号，从节点拒绝序号不大于上一次应用的配置，防止重放旧配置
+
+// nodeConfigSignContext 签名内容的前缀，区分其他用途的签名
+const nodeConfigSignContext = "s-ui node config v2"
+
+// 串行化签名密钥的首次生成
+var nodeSignKeyMutex sync.Mutex
+
+// nodeConfigMessage 签名的内容：前缀、节点 ID、配置序号和配置的原始 JSON，配置不能转发给其他节点使用
+func nodeConfigMessage(nodeId string, serial int64, payload []byte) []byte {
+	serialStr := strconv.FormatInt(serial, 10)
+	message := make([]byte, 0, len(nodeConfigSignContext)+len(nodeId)+len(serialStr)+len(payload)+3)
+	message = append(message, nodeConfigSignContext...)
+	message = append(message, '\n')
+	message = append(message, nodeId...)
+	message = append(message, '\n')
+	message = append(message, serialStr...)
+	message = append(message, '\n')
+	return append(message, payload...)
+}
+
+// ========== 主节点 ==========
+
+// nodeSigningKey 读取配置签名私钥，不存在时生成
+func (s *NodeService) nodeSigningKey() (ed25519.PrivateKey, error) {
+	nodeSignKeyMutex.Lock()
+	defer nodeSignKeyMutex.Unlock()
+
+	var settingService SettingService
+	seed, err := settingService.getString("nodeSignKey")
+	if err != nil {
+		return nil, err
+	}
+	if seed != "" {
+		data, err := base64.StdEncoding.DecodeString(seed)
+		if err != nil || len(data) != ed25519.SeedSize {
+			return nil, common.NewError("invalid node signing key")
+		}
+		return ed25519.NewKeyFromSeed(data), nil
+	}
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	err = settingService.setString("nodeSignKey", base64.StdEncoding.EncodeToString(key.Seed()))
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("Node config signing key generated")
+	return key, nil
+}
+
+// NodeSignPublicKey 获取配置签名公钥 (base64)，从节点注册时固定
+func (s *NodeService) NodeSignPublicKey() (string, error) {
+	key, err := s.nodeSigningKey()
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey)), nil
+}
+
+// nextNodeConfigSerial 递增并返回节点的配置序号
+// 序号保存在数据库中，不受主节点重启和时钟回拨影响
+func (s *NodeService) nextNodeConfigSerial(nodeId string) (int64, error) {
+	var serial int64
+	err := database.GetDB().Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&model.Node{}).Where("node_id = ?", nodeId).Update("config_serial", gorm.Expr("config_serial + 1"))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return common.NewError("node not found")
+		}
+		return tx.Model(&model.Node{}).Where("node_id = ?", nodeId).Pluck("config_serial", &serial).Error
+	})
+	return serial, err
+}
+
+// raiseNodeConfigSerial 从节点心跳上报已应用的配置序号，主节点的序号落后时 (如从备份恢复数据库) 追上，避免新配置被拒绝
+func (s *NodeService) raiseNodeConfigSerial(tx *gorm.DB, nodeId string, applied int64) error {
+	if applied <= 0 {
+		return nil
+	}
+	return tx.Model(&model.Node{}).Where("node_id = ? AND config_serial < ?", nodeId, applied).
+		Update("config_serial", applied).Error
+}
+
+// SignNodeConfig 对下发给节点的配置签名，返回配置序号和签名 (base64)
+func (s *NodeService) SignNodeConfig(nodeId string, payload []byte) (int64, string, error) {
+	key, err := s.nodeSigningKey()
+	if err != nil {
+		return 0, "", err
+	}
+	serial, err := s.nextNodeConfigSerial(nodeId)
+	if err != nil {
+		return 0, "", err
+	}
+	return serial, base64.StdEncoding.EncodeToString(ed25519.Sign(key, nodeConfigMessage(nodeId, serial, payload))), nil
+}
+
+// ========== 从节点 ==========
+
+// loadMasterSignKey 启动时读取固定的主节点公钥，运维指定的 SUI_MASTER_SIGN_KEY 优先
+// 没有固定公钥时不会应用任何配置，需要重新注册或指定公钥
+func (s *SyncService) loadMasterSignKey() {
+	if envKey := config.GetMasterSignKey(); envKey != "" {
+		if err := s.pinMasterSignKey(envKey); err != nil {
+			logger.Error("Invalid SUI_MASTER_SIGN_KEY: ", err)
+		}
+	}
+	if s.getMasterSignKey() == "" {
+		logger.Error("Master signing key is not pinned, configs will not be applied. Re-register the node or set SUI_MASTER_SIGN_KEY")
+	}
+}
+
+func (s *SyncService) getMasterSignKey() string {
+	s.secretMutex.RLock()
+	defer s.secretMutex.RUnlock()
+	return s.masterSignKey
+}
+
+// pinMasterSignKey 固定主节点的配置签名公钥，只在注册响应或运维指定时调用 (心跳响应未签名，不能用来固定公钥)
+// 公钥变化时配置序号重新开始记录
+func (s *SyncService) pinMasterSignKey(publicKey string) error {
+	if publicKey == "" {
+		return nil
+	}
+	data, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil || len(data) != ed25519.PublicKeySize {
+		return common.NewError("invalid master signing key")
+	}
+
+	s.secretMutex.Lock()
+	defer s.secretMutex.Unlock()
+	if s.masterSignKey == publicKey {
+		return nil
+	}
+	var settingService SettingService
+	if err := settingService.SetMasterSignKey(publicKey); err != nil {
+		return err
+	}
+	if err := s.resetConfigSerialLocked(); err != nil {
+		return err
+	}
+	s.masterSignKey = publicKey
+	logger.Info("Master signing key pinned")
+	return nil
+}
+
+// resetConfigSerial 重新注册后主节点为节点重新计数，已应用的配置序号清零
+func (s *SyncService) resetConfigSerial() error {
+	s.secretMutex.Lock()
+	defer s.secretMutex.Unlock()
+	return s.resetConfigSerialLocked()
+}
+
+func (s *SyncService) resetConfigSerialLocked() error {
+	if s.configSerial == 0 {
+		return nil
+	}
+	var settingService SettingService
+	if err := settingService.SetNodeConfigSerial(0); err != nil {
+		return err
+	}
+	s.configSerial = 0
+	return nil
+}
+
+// verifyConfig 用固定的主节点公钥校验配置签名，并拒绝序号不大于上一次应用的配置
+func (s *SyncService) verifyConfig(payload json.RawMessage, serial int64, signature string) error {
+	s.secretMutex.RLock()
+	publicKey := s.masterSignKey
+	lastSerial := s.configSerial
+	s.secretMutex.RUnlock()
+
+	if publicKey == "" {
+		return common.NewError("master signing key is not pinned, re-register the node or set SUI_MASTER_SIGN_KEY")
+	}
+	if signature == "" {
+		return common.NewError("config is not signed")
+	}
+	key, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return common.NewError("invalid master signing key")
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil || !ed25519.Verify(key, nodeConfigMessage(s.nodeId, serial, payload), sig) {
+		return common.NewError("config signature verification failed")
+	}
+	if serial <= lastSerial {
+		return common.NewErrorf("config serial %d is not newer than the applied config (%d)", serial, lastSerial)
+	}
+	return nil
+}
+
+// setConfigSerial 记录已应用配置的序号，保存到本地设置，重启后仍拒绝旧配置
+func (s *SyncService) setConfigSerial(serial int64) {
+	s.secretMutex.Lock()
+	defer s.secretMutex.Unlock()
+	if serial <= s.configSerial {
+		return
+	}
+	s.configSerial = serial
+	var settingService SettingService
+	if err := settingService.SetNodeConfigSerial(serial); err != nil {
+		logger.Warning("Failed to save applied config serial: ", err)
+	}
+}
+
+func (s *SyncService) getConfigSerial() int64 {
+	s.secretMutex.RLock()
+	defer s.secretMutex.RUnlock()
+	return s.configSerial
+}
+
+// setConfigError 记录配置校验失败的原因，通过心跳上报主节点 (成功同步后清除)
+func (s *SyncService) setConfigError(err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err == nil {
+		s.configError = ""
+		return
+	}
+	s.configError = err.Error()
+}
+
+func (s *SyncService) getConfigError() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.configError
+}
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+)
+
+func TestNodeConfigMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		nodeId  string
+		serial  int64
+		payload string
+		want    string
+	}{
+		{"basic", "node-1", 1, `{"full":true}`, "s-ui node config v2\nnode-1\n1\n{\"full\":true}"},
+		{"empty payload", "node-1", 42, "", "s-ui node config v2\nnode-1\n42\n"},
+		{"zero serial", "hk-01", 0, `{}`, "s-ui node config v2\nhk-01\n0\n{}"},
+		{"large serial", "n", 1 << 40, `[]`, "s-ui node config v2\nn\n1099511627776\n[]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := string(nodeConfigMessage(tt.nodeId, tt.serial, []byte(tt.payload)))
+			if got != tt.want {
+				t.Fatalf("nodeConfigMessage() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyConfig(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pinned := base64.StdEncoding.EncodeToString(publicKey)
+	payload := json.RawMessage(`{"full":true,"hash":"a71b03"}`)
+	sign := func(key ed25519.PrivateKey, nodeId string, serial int64, payload []byte) string {
+		return base64.StdEncoding.EncodeToString(ed25519.Sign(key, nodeConfigMessage(nodeId, serial, payload)))
+	}
+
+	tests := []struct {
+		name      string
+		pinned    string
+		payload   json.RawMessage
+		serial    int64
+		signature string
+		wantErr   bool
+	}{
+		{"valid", pinned, payload, 6, sign(privateKey, "node-1", 6, payload), false},
+		{"newer serial", pinned, payload, 100, sign(privateKey, "node-1", 100, payload), false},
+		{"replayed serial", pinned, payload, 5, sign(privateKey, "node-1", 5, payload), true},
+		{"older serial", pinned, payload, 4, sign(privateKey, "node-1", 4, payload), true},
+		{"serial changed after signing", pinned, payload, 7, sign(privateKey, "node-1", 6, payload), true},
+		{"signed for another node", pinned, payload, 6, sign(privateKey, "node-2", 6, payload), true},
+		{"tampered payload", pinned, json.RawMessage(`{"full":true,"hash":"000000"}`), 6, sign(privateKey, "node-1", 6, payload), true},
+		{"signed by another key", pinned, payload, 6, sign(otherKey, "node-1", 6, payload), true},
+		{"missing signature", pinned, payload, 6, "", true},
+		{"invalid signature encoding", pinned, payload, 6, "not base64!", true},
+		{"no pinned key", "", payload, 6, sign(privateKey, "node-1", 6, payload), true},
+		{"invalid pinned key", "c2hvcnQ=", payload, 6, sign(privateKey, "node-1", 6, payload), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &SyncService{nodeId: "node-1", masterSignKey: tt.pinned, configSerial: 5}
+			err := s.verifyConfig(tt.payload, tt.serial, tt.signature)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("verifyConfig() error = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,274 @@
+package service
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/alireza0/s-ui/database"
+	"github.com/alireza0/s-ui/database/model"
+	"github.com/alireza0/s-ui/util/common"
+)
+
+// 心跳间隔 (秒)，节点首次心跳或长时间离线后的记录按此计算
+const defaultHeartbeatInterval = 30
+
+// nodeStatsTier 降采样层级：早于 age 秒的记录合并为 bucket 秒一条
+type nodeStatsTier struct {
+	age    int64
+	bucket int64
+}
+
+var nodeStatsTiers = []nodeStatsTier{
+	{age: 86400, bucket: 300},        // 1 天前的记录按 5 分钟合并
+	{age: 7 * 86400, bucket: 3600},   // 7 天前的记录按 1 小时合并
+	{age: 30 * 86400, bucket: 86400}, // 30 天前的记录按 1 天合并
+}
+
+// nodeStatsMaxAge 节点统计最长保留时间 (秒)
+const nodeStatsMaxAge = 365 * 86400
+
+// NodeStatsMetrics 可查询的节点统计指标
+// cpu / memory / connections 按时长加权平均，upload / download 求和
+var NodeStatsMetrics = map[string]bool{
+	"cpu":         true,
+	"memory":      true,
+	"connections": true,
+	"upload":      true,
+	"download":    true,
+}
+
+// NodeStatsPoint 节点统计时间序列中的一个点
+type NodeStatsPoint struct {
+	DateTime int64   `json:"dateTime"`
+	Value    float64 `json:"value"`
+}
+
+// nodeTraffic 从节点自上次心跳以来的入站流量，心跳时上报并清零
+var nodeTraffic struct {
+	sync.Mutex
+	upload   int64
+	download int64
+}
+
+// addNodeTraffic 累加从节点入站流量
+func addNodeTraffic(upload, download int64) {
+	nodeTraffic.Lock()
+	defer nodeTraffic.Unlock()
+	nodeTraffic.upload += upload
+	nodeTraffic.download += download
+}
+
+// takeNodeTraffic 取出并清零从节点入站流量 (心跳失败时通过 addNodeTraffic 放回)
+func takeNodeTraffic() (int64, int64) {
+	nodeTraffic.Lock()
+	defer nodeTraffic.Unlock()
+	upload, download := nodeTraffic.upload, nodeTraffic.download
+	nodeTraffic.upload, nodeTraffic.download = 0, 0
+	return upload, download
+}
+
+// heartbeatInterval 计算本次心跳记录覆盖的秒数
+func heartbeatInterval(lastSeen, now int64) int64 {
+	interval := now - lastSeen
+	if lastSeen == 0 || interval <= 0 || interval > 10*defaultHeartbeatInterval {
+		return defaultHeartbeatInterval
+	}
+	return interval
+}
+
+// nodeStatsWeight 记录在加权平均中的权重
+func nodeStatsWeight(stat *model.NodeStats) float64 {
+	if stat.Interval <= 0 {
+		return defaultHeartbeatInterval
+	}
+	return float64(stat.Interval)
+}
+
+// nodeStatsAccumulator 合并一个时间桶内的多条记录
+type nodeStatsAccumulator struct {
+	weight      float64
+	cpu         float64
+	memory      float64
+	connections float64
+	upload      int64
+	download    int64
+}
+
+func (a *nodeStatsAccumulator) add(stat *model.NodeStats) {
+	w := nodeStatsWeight(stat)
+	a.weight += w
+	a.cpu += stat.CPU * w
+	a.memory += stat.Memory * w
+	a.connections += float64(stat.Connections) * w
+	a.upload += stat.Upload
+	a.download += stat.Download
+}
+
+func (a *nodeStatsAccumulator) value(metric string) float64 {
+	switch metric {
+	case "upload":
+		return float64(a.upload)
+	case "download":
+		return float64(a.download)
+	}
+	if a.weight == 0 {
+		return 0
+	}
+	switch metric {
+	case "cpu":
+		return a.cpu / a.weight
+	case "memory":
+		return a.memory / a.weight
+	case "connections":
+		return a.connections / a.weight
+	}
+	return 0
+}
+
+// DownsampleNodeStats 按层级合并旧的节点统计并删除超过保留时间的记录 (定时任务调用)
+func (s *NodeService) DownsampleNodeStats() error {
+	var err error
+	db := database.GetDB()
+	now := time.Now().Unix()
+
+	err = db.Where("date_time < ?", now-nodeStatsMaxAge).Delete(&model.NodeStats{}).Error
+	if err != nil {
+		return err
+	}
+
+	for _, tier := range nodeStatsTiers {
+		// 截止时间对齐到桶边界，保证每个桶只合并一次
+		cutoff := (now - tier.age) / tier.bucket * tier.bucket
+		if err = s.downsampleNodeStats(cutoff, tier.bucket); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// downsampleNodeStats 将 cutoff 之前、精度高于 bucket 的记录合并为每桶一条
+func (s *NodeService) downsampleNodeStats(cutoff int64, bucket int64) error {
+	var err error
+	db := database.GetDB()
+	tx := db.Begin()
+	defer func() {
+		if err == nil {
+			tx.Commit()
+		} else {
+			tx.Rollback()
+		}
+	}()
+
+	var stats []model.NodeStats
+	err = tx.Model(&model.NodeStats{}).
+		Where("date_time < ? AND `interval` < ?", cutoff, bucket).
+		Order("date_time asc").Find(&stats).Error
+	if err != nil || len(stats) == 0 {
+		return err
+	}
+
+	type bucketKey struct {
+		nodeId   string
+		dateTime int64
+	}
+	buckets := make(map[bucketKey]*nodeStatsAccumulator)
+	var keys []bucketKey
+	ids := make([]uint64, 0, len(stats))
+	for i := range stats {
+		key := bucketKey{stats[i].NodeId, stats[i].DateTime / bucket * bucket}
+		if buckets[key] == nil {
+			buckets[key] = &nodeStatsAccumulator{}
+			keys = append(keys, key)
+		}
+		buckets[key].add(&stats[i])
+		ids = append(ids, stats[i].Id)
+	}
+
+	merged := make([]model.NodeStats, 0, len(keys))
+	for _, key := range keys {
+		acc := buckets[key]
+		merged = append(merged, model.NodeStats{
+			NodeId:      key.nodeId,
+			DateTime:    key.dateTime,
+			Interval:    bucket,
+			CPU:         acc.value("cpu"),
+			Memory:      acc.value("memory"),
+			Connections: int(acc.value("connections") + 0.5),
+			Upload:      acc.upload,
+			Download:    acc.download,
+		})
+	}
+
+	// 分批删除，避免超出 SQLite 的参数数量限制
+	for start := 0; start < len(ids); start += 500 {
+		end := min(start+500, len(ids))
+		err = tx.Where("id in ?", ids[start:end]).Delete(&model.NodeStats{}).Error
+		if err != nil {
+			return err
+		}
+	}
+	err = tx.CreateInBatches(&merged, 500).Error
+	return err
+}
+
+// GetNodeStatsRange 查询节点统计时间序列
+// nodeId 为空时返回所有节点，结果按节点 ID 分组；bucket 为时间桶大小 (秒)
+func (s *NodeService) GetNodeStatsRange(nodeId string, metric string, from, to, bucket int64) (map[string][]NodeStatsPoint, error) {
+	if !NodeStatsMetrics[metric] {
+		return nil, common.NewError("unknown metric: ", metric)
+	}
+	if to <= 0 {
+		to = time.Now().Unix()
+	}
+	if from <= 0 || from >= to {
+		return nil, common.NewError("invalid time range")
+	}
+	if bucket < defaultHeartbeatInterval {
+		bucket = defaultHeartbeatInterval
+	}
+	// 限制返回的点数
+	if (to-from)/bucket > 5000 {
+		return nil, common.NewError("bucket is too small for the time range")
+	}
+
+	db := database.GetDB()
+	query := db.Model(&model.NodeStats{}).Where("date_time >= ? AND date_time < ?", from, to)
+	if nodeId != "" {
+		query = query.Where("node_id = ?", nodeId)
+	}
+	var stats []model.NodeStats
+	err := query.Order("date_time asc").Find(&stats).Error
+	if err != nil {
+		return nil, err
+	}
+
+	buckets := make(map[string]map[int64]*nodeStatsAccumulator)
+	for i := range stats {
+		stat := &stats[i]
+		if buckets[stat.NodeId] == nil {
+			buckets[stat.NodeId] = make(map[int64]*nodeStatsAccumulator)
+		}
+		dateTime := from + (stat.DateTime-from)/bucket*bucket
+		acc := buckets[stat.NodeId][dateTime]
+		if acc == nil {
+			acc = &nodeStatsAccumulator{}
+			buckets[stat.NodeId][dateTime] = acc
+		}
+		acc.add(stat)
+	}
+
+	result := make(map[string][]NodeStatsPoint, len(buckets))
+	for id, nodeBuckets := range buckets {
+		points := make([]NodeStatsPoint, 0, len(nodeBuckets))
+		for dateTime, acc := range nodeBuckets {
+			points = append(points, NodeStatsPoint{
+				DateTime: dateTime,
+				Value:    acc.value(metric),
+			})
+		}
+		sort.Slice(points, func(i, j int) bool { return points[i].DateTime < points[j].DateTime })
+		result[id] = points
+	}
+	return result, nil
+}
@@ -23,9 +23,10 @@ func (o *OutboundService) GetAll() (*[]map[string]interface{}, error) {
 	var data []map[string]interface{}
 	for _, outbound := range outbounds {
 		outData := map[string]interface{}{
-			"id":   outbound.Id,
-			"type": outbound.Type,
-			"tag":  outbound.Tag,
+			"id":    outbound.Id,
+			"type":  outbound.Type,
+			"tag":   outbound.Tag,
+			"nodes": outbound.Nodes,
 		}
 		if outbound.Options != nil {
 			var restFields map[string]json.RawMessage
@@ -49,6 +50,9 @@ func (o *OutboundService) GetAllConfig(db *gorm.DB) ([]json.RawMessage, error) {
 		return nil, err
 	}
 	for _, outbound := range outbounds {
+		if !runsLocally(outbound.Nodes) {
+			continue
+		}
 		outboundJson, err := outbound.MarshalJSON()
 		if err != nil {
 			return nil, err
@@ -85,9 +89,11 @@ func (s *OutboundService) Save(tx *gorm.DB, act string, data json.RawMessage) er
 					return err
 				}
 			}
-			err = corePtr.AddOutbound(configData)
-			if err != nil {
-				return err
+			if runsLocally(outbound.Nodes) {
+				err = corePtr.AddOutbound(configData)
+				if err != nil {
+					return err
+				}
 			}
 		}
 
@@ -0,0 +1,253 @@
+package service
+
+import (
+	"encoding/json"
+
+	"github.com/alireza0/s-ui/config"
+	"github.com/alireza0/s-ui/database"
+	"github.com/alireza0/s-ui/database/model"
+	"github.com/alireza0/s-ui/logger"
+	"github.com/alireza0/s-ui/util/common"
+
+	"gorm.io/gorm"
+)
+
+// 本地覆盖配置类型
+const (
+	OverlayOutbound  = "outbound"
+	OverlayEndpoint  = "endpoint"
+	OverlayRouteRule = "route_rule"
+)
+
+// overlayRuleAnchors 本地路由规则插入到这些前置动作之后 (嗅探等需要先于匹配执行)
+var overlayRuleAnchors = map[string]bool{
+	"sniff":      true,
+	"resolve":    true,
+	"hijack-dns": true,
+}
+
+// OverlayConflict 本地覆盖配置与同步配置的 tag 冲突 (本地配置生效，同步的同名对象被忽略)
+type OverlayConflict struct {
+	Id     uint   `json:"id"`
+	Kind   string `json:"kind"`
+	Tag    string `json:"tag"`
+	Synced string `json:"synced"`
+}
+
+// OverlayService 从节点本地覆盖配置
+// 本地配置单独存表，同步时不会被清除；出站和端点与同步配置同名时覆盖同步的对象
+type OverlayService struct{}
+
+// GetOverlays 获取所有本地覆盖配置
+func (s *OverlayService) GetOverlays() ([]model.LocalOverlay, error) {
+	db := database.GetDB()
+	overlays := []model.LocalOverlay{}
+	err := db.Order("id asc").Find(&overlays).Error
+	if err != nil {
+		return nil, err
+	}
+	return overlays, nil
+}
+
+// SaveOverlay 新增或更新本地覆盖配置，出站和端点的 tag 取自配置内容
+func (s *OverlayService) SaveOverlay(overlay *model.LocalOverlay) error {
+	var options map[string]interface{}
+	if err := json.Unmarshal(overlay.Options, &options); err != nil {
+		return common.NewError("invalid overlay options: ", err.Error())
+	}
+
+	switch overlay.Kind {
+	case OverlayOutbound, OverlayEndpoint:
+		tag, _ := options["tag"].(string)
+		if tag == "" {
+			return common.NewError("overlay ", overlay.Kind, " requires a tag")
+		}
+		overlay.Tag = tag
+	case OverlayRouteRule:
+		overlay.Tag = ""
+	default:
+		return common.NewError("unknown overlay kind: ", overlay.Kind)
+	}
+
+	db := database.GetDB()
+	// 出站和端点共用 tag 空间，本地配置之间不允许重名
+	if overlay.Tag != "" {
+		var count int64
+		err := db.Model(model.LocalOverlay{}).
+			Where("tag = ? AND kind IN ? AND id != ?", overlay.Tag, []string{OverlayOutbound, OverlayEndpoint}, overlay.Id).
+			Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return common.NewError("duplicate overlay tag: ", overlay.Tag)
+		}
+	}
+
+	return db.Save(overlay).Error
+}
+
+// DeleteOverlay 删除本地覆盖配置
+func (s *OverlayService) DeleteOverlay(id uint) error {
+	db := database.GetDB()
+	return db.Where("id = ?", id).Delete(model.LocalOverlay{}).Error
+}
+
+// getEnabledOverlays 获取已启用的本地覆盖配置
+func (s *OverlayService) getEnabledOverlays(db *gorm.DB) ([]model.LocalOverlay, error) {
+	var overlays []model.LocalOverlay
+	err := db.Where("enable = ?", true).Order("id asc").Find(&overlays).Error
+	return overlays, err
+}
+
+// GetOverlayTags 获取本地出站和端点占用的 tag，同步的同名对象不加载到 core
+func (s *OverlayService) GetOverlayTags(db *gorm.DB) (map[string]bool, error) {
+	var tags []string
+	err := db.Model(model.LocalOverlay{}).
+		Where("enable = ? AND kind IN ?", true, []string{OverlayOutbound, OverlayEndpoint}).
+		Pluck("tag", &tags).Error
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		result[tag] = true
+	}
+	return result, nil
+}
+
+// GetOverlayConflicts 检测本地出站和端点与同步的出站、端点的 tag 冲突 (通过心跳上报给主节点)
+func (s *OverlayService) GetOverlayConflicts() ([]OverlayConflict, error) {
+	db := database.GetDB()
+	overlays, err := s.getEnabledOverlays(db)
+	if err != nil {
+		return nil, err
+	}
+
+	synced := make(map[string]string)
+	var tags []string
+	if err = db.Model(model.Outbound{}).Pluck("tag", &tags).Error; err != nil {
+		return nil, err
+	}
+	for _, tag := range tags {
+		synced[tag] = OverlayOutbound
+	}
+	tags = nil
+	if err = db.Model(model.Endpoint{}).Pluck("tag", &tags).Error; err != nil {
+		return nil, err
+	}
+	for _, tag := range tags {
+		synced[tag] = OverlayEndpoint
+	}
+
+	conflicts := []OverlayConflict{}
+	for _, overlay := range overlays {
+		if overlay.Tag == "" {
+			continue
+		}
+		if kind, ok := synced[overlay.Tag]; ok {
+			conflicts = append(conflicts, OverlayConflict{
+				Id:     overlay.Id,
+				Kind:   overlay.Kind,
+				Tag:    overlay.Tag,
+				Synced: kind,
+			})
+		}
+	}
+	return conflicts, nil
+}
+
+// applyOverlays 将本地覆盖配置合并到同步的 sing-box 配置中
+// 同名的同步出站或端点被本地配置替换，本地路由规则插入在嗅探等前置规则之后
+// 只在从节点生效
+func (s *OverlayService) applyOverlays(db *gorm.DB, singboxConfig *SingBoxConfig) error {
+	if !config.IsWorker() {
+		return nil
+	}
+	overlays, err := s.getEnabledOverlays(db)
+	if err != nil || len(overlays) == 0 {
+		return err
+	}
+
+	shadowed := make(map[string]bool)
+	var outbounds, endpoints, rules []json.RawMessage
+	for _, overlay := range overlays {
+		switch overlay.Kind {
+		case OverlayOutbound:
+			shadowed[overlay.Tag] = true
+			outbounds = append(outbounds, overlay.Options)
+		case OverlayEndpoint:
+			shadowed[overlay.Tag] = true
+			endpoints = append(endpoints, overlay.Options)
+		case OverlayRouteRule:
+			rules = append(rules, overlay.Options)
+		}
+	}
+
+	singboxConfig.Outbounds = append(dropShadowed(singboxConfig.Outbounds, shadowed), outbounds...)
+	singboxConfig.Endpoints = append(dropShadowed(singboxConfig.Endpoints, shadowed), endpoints...)
+
+	if len(rules) > 0 {
+		singboxConfig.Route, err = insertRouteRules(singboxConfig.Route, rules)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dropShadowed 去掉被本地配置覆盖的同步对象
+func dropShadowed(configs []json.RawMessage, shadowed map[string]bool) []json.RawMessage {
+	result := make([]json.RawMessage, 0, len(configs))
+	for _, item := range configs {
+		var obj struct {
+			Tag string `json:"tag"`
+		}
+		if err := json.Unmarshal(item, &obj); err == nil && shadowed[obj.Tag] {
+			logger.Info("local overlay overrides synced tag: ", obj.Tag)
+			continue
+		}
+		result = append(result, item)
+	}
+	return result
+}
+
+// insertRouteRules 将本地路由规则插入到 route.rules 开头的前置动作之后
+func insertRouteRules(route json.RawMessage, rules []json.RawMessage) (json.RawMessage, error) {
+	routeMap := make(map[string]json.RawMessage)
+	if len(route) > 0 && string(route) != "null" {
+		if err := json.Unmarshal(route, &routeMap); err != nil {
+			return nil, err
+		}
+	}
+	var existing []json.RawMessage
+	if raw, ok := routeMap["rules"]; ok {
+		if err := json.Unmarshal(raw, &existing); err != nil {
+			return nil, err
+		}
+	}
+
+	pos := 0
+	for pos < len(existing) {
+		var rule struct {
+			Action string `json:"action"`
+		}
+		json.Unmarshal(existing[pos], &rule)
+		if !overlayRuleAnchors[rule.Action] {
+			break
+		}
+		pos++
+	}
+
+	merged := make([]json.RawMessage, 0, len(existing)+len(rules))
+	merged = append(merged, existing[:pos]...)
+	merged = append(merged, rules...)
+	merged = append(merged, existing[pos:]...)
+
+	rulesJSON, err := json.Marshal(merged)
+	if err != nil {
+		return nil, err
+	}
+	routeMap["rules"] = rulesJSON
+	return json.Marshal(routeMap)
+}
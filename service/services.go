@@ -27,6 +27,7 @@ func (s *ServicesService) GetAll() (*[]map[string]interface{}, error) {
 			"type":   srv.Type,
 			"tag":    srv.Tag,
 			"tls_id": srv.TlsId,
+			"nodes":  srv.Nodes,
 		}
 		if srv.Options != nil {
 			var restFields map[string]json.RawMessage
@@ -51,6 +52,9 @@ func (s *ServicesService) GetAllConfig(db *gorm.DB) ([]json.RawMessage, error) {
 		return nil, err
 	}
 	for _, srv := range services {
+		if !runsLocally(srv.Nodes) {
+			continue
+		}
 		srvJson, err := srv.MarshalJSON()
 		if err != nil {
 			return nil, err
@@ -94,9 +98,11 @@ func (s *ServicesService) Save(tx *gorm.DB, act string, data json.RawMessage) er
 					return err
 				}
 			}
-			err = corePtr.AddService(configData)
-			if err != nil {
-				return err
+			if runsLocally(srv.Nodes) {
+				err = corePtr.AddService(configData)
+				if err != nil {
+					return err
+				}
 			}
 		}
 
@@ -140,6 +146,9 @@ func (s *ServicesService) RestartServices(tx *gorm.DB, ids []uint) error {
 		if err != nil && err != os.ErrInvalid {
 			return err
 		}
+		if !runsLocally(srv.Nodes) {
+			continue
+		}
 		srvConfig, err := srv.MarshalJSON()
 		if err != nil {
 			return err
@@ -65,8 +65,62 @@ var defaultValueMap = map[string]string{
 	"subURI":        "",
 	"subJsonExt":    "",
 	"subClashExt":   "",
-	"config":        defaultConfig,
-	"version":       config.GetVersion(),
+	// 订阅中节点排序权重 (负载 / 延迟 / 容量 / 按客户端打散) 和每个客户端最多下发的节点数 (0 表示不限)
+	"subNodeLoadWeight":     "1",
+	"subNodeLatencyWeight":  "1",
+	"subNodeCapacityWeight": "0.5",
+	"subNodeSpreadWeight":   "0.3",
+	"subNodeLimit":          "0",
+	// 订阅链接签名密钥；是否允许按客户端名称查找订阅 (兼容旧链接)；
+	// 设置了订阅令牌的客户端是否仍接受 UUID 链接 (关闭后轮换令牌才能停用泄露的 UUID 链接)
+	"subSecret":       "",
+	"subNameFallback": "true",
+	"subUuidFallback": "true",
+	// 按 User-Agent 选择订阅格式的规则 (JSON 数组)，为空时使用内置规则
+	"subFormatRules": "",
+	// 订阅获取记录保留天数 (0 表示不记录)
+	"subLogAge": "30",
+	// 共享检测: 时间窗口 (小时) 内获取订阅的不同 IP 数、不同网段数 (IPv4 /24, IPv6 /48)，
+	// 同时在多个节点在线的不同 IP 数 (0 表示不检测)；检测到后是否自动轮换订阅令牌
+	"subShareWindow":       "24",
+	"subShareMaxIPs":       "10",
+	"subShareMaxNetworks":  "5",
+	"subShareMaxOnlineIPs": "5",
+	"subShareRotate":       "false",
+	// 外部订阅 (sub 类型链接): 缓存时间 (秒)、获取超时 (秒)、响应大小上限 (字节)、是否跳过证书校验
+	"subExternalTTL":      "600",
+	"subExternalTimeout":  "10",
+	"subExternalMaxSize":  "1048576",
+	"subExternalInsecure": "false",
+	// 设备数超限策略: reject (拒绝新设备) / evict (踢掉最早的设备)
+	"deviceLimitPolicy": "reject",
+	// 多节点在线时长合并策略: max (同一个 10 秒采样时间段按各节点上报的最大秒数计) / sum (各节点时长直接累加)
+	// max 只按采样时间段去重，不是精确的时间并集；旧值 union 按 max 处理
+	"onlineTimePolicy": "max",
+	// 主节点探测从节点时是否做 TLS 握手，以及是否额外探测每个入站端口
+	"nodeProbeTLS":      "false",
+	"nodeProbeInbounds": "false",
+	// 从节点的节点密钥 (注册时由主节点下发)
+	"nodeSecret": "",
+	// 从节点已同步的配置版本 (配置内容的哈希)，重启后无需重新同步
+	"nodeConfigHash": "",
+	// 从节点已应用配置的序号，拒绝重放更早的配置
+	"nodeConfigSerial": "0",
+	// 从节点已执行的远程命令和回报失败的结果 (JSON)，重启后不重复执行、继续回报
+	"nodeDoneCommands":   "[]",
+	"nodePendingResults": "[]",
+	// 配置签名: 主节点的 ed25519 私钥种子；从节点固定的主节点公钥
+	"nodeSignKey":   "",
+	"masterSignKey": "",
+	// 节点 mTLS: 主节点 CA 和是否强制要求客户端证书；从节点保存的主节点 CA 和客户端证书
+	"nodeCaCert":            "",
+	"nodeCaKey":             "",
+	"nodeRequireClientCert": "false",
+	"masterCaCert":          "",
+	"nodeClientCert":        "",
+	"nodeClientKey":         "",
+	"config":                defaultConfig,
+	"version":               config.GetVersion(),
 }
 
 type SettingService struct {
@@ -97,6 +151,16 @@ func (s *SettingService) GetAllSetting() (*map[string]string, error) {
 
 	// Due to security principles
 	delete(allSetting, "secret")
+	delete(allSetting, "nodeSecret")
+	delete(allSetting, "nodeCaCert")
+	delete(allSetting, "nodeCaKey")
+	delete(allSetting, "masterCaCert")
+	delete(allSetting, "nodeClientCert")
+	delete(allSetting, "nodeClientKey")
+	delete(allSetting, "nodeSignKey")
+	delete(allSetting, "nodeDoneCommands")
+	delete(allSetting, "nodePendingResults")
+	delete(allSetting, "subSecret")
 	delete(allSetting, "config")
 	delete(allSetting, "version")
 
@@ -175,6 +239,15 @@ func (s *SettingService) getInt(key string) (int, error) {
 func (s *SettingService) setInt(key string, value int) error {
 	return s.setString(key, strconv.Itoa(value))
 }
+
+func (s *SettingService) getFloat(key string) (float64, error) {
+	str, err := s.getString(key)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(str, 64)
+}
+
 func (s *SettingService) GetListen() (string, error) {
 	return s.getString("webListen")
 }
@@ -323,6 +396,49 @@ func (s *SettingService) GetSubURI() (string, error) {
 	return s.getString("subURI")
 }
 
+func (s *SettingService) GetSubNameFallback() (bool, error) {
+	return s.getBool("subNameFallback")
+}
+
+func (s *SettingService) GetSubUuidFallback() (bool, error) {
+	return s.getBool("subUuidFallback")
+}
+
+func (s *SettingService) GetSubLogAge() (int, error) {
+	return s.getInt("subLogAge")
+}
+
+// GetSubShareSettings 获取订阅共享检测设置
+func (s *SettingService) GetSubShareSettings() (*SubShareSettings, error) {
+	window, err := s.getInt("subShareWindow")
+	if err != nil {
+		return nil, err
+	}
+	maxIPs, err := s.getInt("subShareMaxIPs")
+	if err != nil {
+		return nil, err
+	}
+	maxNetworks, err := s.getInt("subShareMaxNetworks")
+	if err != nil {
+		return nil, err
+	}
+	maxOnlineIPs, err := s.getInt("subShareMaxOnlineIPs")
+	if err != nil {
+		return nil, err
+	}
+	rotate, err := s.getBool("subShareRotate")
+	if err != nil {
+		return nil, err
+	}
+	return &SubShareSettings{
+		Window:       int64(max(window, 1)) * 3600,
+		MaxIPs:       maxIPs,
+		MaxNetworks:  maxNetworks,
+		MaxOnlineIPs: maxOnlineIPs,
+		Rotate:       rotate,
+	}, nil
+}
+
 func (s *SettingService) GetFinalSubURI(host string) (string, error) {
 	allSetting, err := s.GetAllSetting()
 	if err != nil {
@@ -392,6 +508,34 @@ func (s *SettingService) Save(tx *gorm.DB, data json.RawMessage) error {
 			}
 		}
 
+		// Node ranking weights must be non-negative numbers, the node limit a non-negative integer
+		if key == "subNodeLimit" {
+			value, parseErr := strconv.Atoi(obj)
+			if parseErr != nil || value < 0 {
+				return common.NewError("invalid ", key, ": ", obj)
+			}
+		} else if strings.HasPrefix(key, "subNode") {
+			value, parseErr := strconv.ParseFloat(obj, 64)
+			if parseErr != nil || value < 0 {
+				return common.NewError("invalid ", key, ": ", obj)
+			}
+		}
+
+		// Subscription log age, sharing thresholds and external subscription limits must be non-negative integers
+		if key == "subLogAge" || (strings.HasPrefix(key, "subShare") && key != "subShareRotate") ||
+			(strings.HasPrefix(key, "subExternal") && key != "subExternalInsecure") {
+			value, parseErr := strconv.Atoi(obj)
+			if parseErr != nil || value < 0 {
+				return common.NewError("invalid ", key, ": ", obj)
+			}
+		}
+
+		if key == "subFormatRules" && strings.TrimSpace(obj) != "" {
+			if _, err = ParseSubFormatRules(obj); err != nil {
+				return err
+			}
+		}
+
 		// Delete all stats if it is set to 0
 		if key == "trafficAge" && obj == "0" {
 			err = tx.Where("id > 0").Delete(model.Stats{}).Error
@@ -415,6 +559,135 @@ func (s *SettingService) GetSubClashExt() (string, error) {
 	return s.getString("subClashExt")
 }
 
+// GetSubNodeRanking 获取订阅中节点排序的权重
+func (s *SettingService) GetSubNodeRanking() (NodeRankWeights, error) {
+	var weights NodeRankWeights
+	var err error
+	fields := map[string]*float64{
+		"subNodeLoadWeight":     &weights.Load,
+		"subNodeLatencyWeight":  &weights.Latency,
+		"subNodeCapacityWeight": &weights.Capacity,
+		"subNodeSpreadWeight":   &weights.Spread,
+	}
+	for key, field := range fields {
+		*field, err = s.getFloat(key)
+		if err != nil {
+			return weights, err
+		}
+		*field = normalizeRankWeight(*field)
+	}
+	weights.Limit, err = s.getInt("subNodeLimit")
+	if err != nil {
+		return weights, err
+	}
+	weights.Limit = max(weights.Limit, 0)
+	return weights, nil
+}
+
+func (s *SettingService) GetDeviceLimitPolicy() (string, error) {
+	return s.getString("deviceLimitPolicy")
+}
+
+func (s *SettingService) SetDeviceLimitPolicy(policy string) error {
+	return s.setString("deviceLimitPolicy", policy)
+}
+
+func (s *SettingService) GetOnlineTimePolicy() (string, error) {
+	return s.getString("onlineTimePolicy")
+}
+
+func (s *SettingService) GetNodeProbeTLS() (bool, error) {
+	return s.getBool("nodeProbeTLS")
+}
+
+func (s *SettingService) GetNodeProbeInbounds() (bool, error) {
+	return s.getBool("nodeProbeInbounds")
+}
+
+func (s *SettingService) GetNodeSecret() (string, error) {
+	return s.getString("nodeSecret")
+}
+
+func (s *SettingService) SetNodeSecret(secret string) error {
+	return s.setString("nodeSecret", secret)
+}
+
+func (s *SettingService) GetNodeConfigHash() (string, error) {
+	return s.getString("nodeConfigHash")
+}
+
+func (s *SettingService) SetNodeConfigHash(hash string) error {
+	return s.setString("nodeConfigHash", hash)
+}
+
+func (s *SettingService) GetNodeConfigSerial() (int64, error) {
+	str, err := s.getString("nodeConfigSerial")
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(str, 10, 64)
+}
+
+func (s *SettingService) SetNodeConfigSerial(serial int64) error {
+	return s.setString("nodeConfigSerial", strconv.FormatInt(serial, 10))
+}
+
+func (s *SettingService) GetNodeDoneCommands() (string, error) {
+	return s.getString("nodeDoneCommands")
+}
+
+func (s *SettingService) SetNodeDoneCommands(done string) error {
+	return s.setString("nodeDoneCommands", done)
+}
+
+func (s *SettingService) GetNodePendingResults() (string, error) {
+	return s.getString("nodePendingResults")
+}
+
+func (s *SettingService) SetNodePendingResults(results string) error {
+	return s.setString("nodePendingResults", results)
+}
+
+func (s *SettingService) GetMasterSignKey() (string, error) {
+	return s.getString("masterSignKey")
+}
+
+func (s *SettingService) SetMasterSignKey(publicKey string) error {
+	return s.setString("masterSignKey", publicKey)
+}
+
+func (s *SettingService) GetNodeRequireClientCert() (bool, error) {
+	return s.getBool("nodeRequireClientCert")
+}
+
+// GetNodeClientCert 获取从节点保存的主节点 CA、客户端证书和私钥
+func (s *SettingService) GetNodeClientCert() (string, string, string, error) {
+	caPEM, err := s.getString("masterCaCert")
+	if err != nil {
+		return "", "", "", err
+	}
+	certPEM, err := s.getString("nodeClientCert")
+	if err != nil {
+		return "", "", "", err
+	}
+	keyPEM, err := s.getString("nodeClientKey")
+	if err != nil {
+		return "", "", "", err
+	}
+	return caPEM, certPEM, keyPEM, nil
+}
+
+// SetNodeClientCert 保存从节点的主节点 CA、客户端证书和私钥
+func (s *SettingService) SetNodeClientCert(caPEM, certPEM, keyPEM string) error {
+	if err := s.setString("masterCaCert", caPEM); err != nil {
+		return err
+	}
+	if err := s.setString("nodeClientCert", certPEM); err != nil {
+		return err
+	}
+	return s.setString("nodeClientKey", keyPEM)
+}
+
 func (s *SettingService) fileExists(path string) error {
 	_, err := os.Stat(path)
 	return err
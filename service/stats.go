@@ -49,11 +49,16 @@ func (s *StatsService) SaveStats(enableTraffic bool) error {
 	nodeId := getLocalNodeId()
 
 	var err error
+	// 从节点本次入站流量，提交后计入心跳上报的节点流量
+	var upload, download int64
 	db := database.GetDB()
 	tx := db.Begin()
 	defer func() {
 		if err == nil {
 			tx.Commit()
+			if config.IsWorker() {
+				addNodeTraffic(upload, download)
+			}
 		} else {
 			tx.Rollback()
 		}
@@ -64,6 +69,14 @@ func (s *StatsService) SaveStats(enableTraffic bool) error {
 		// 设置节点 ID
 		stat.NodeId = nodeId
 
+		if stat.Resource == "inbound" {
+			if stat.Direction {
+				upload += stat.Traffic
+			} else {
+				download += stat.Traffic
+			}
+		}
+
 		if stat.Resource == "user" {
 			if stat.Direction {
 				err = tx.Model(model.Client{}).Where("name = ?", stat.Tag).
@@ -88,6 +101,24 @@ func (s *StatsService) SaveStats(enableTraffic bool) error {
 		}
 	}
 
+	// 从节点写入待上报队列，与本地统计在同一事务中，保证重启或断网时不丢失
+	if config.IsWorker() {
+		outbox := make([]model.StatsOutbox, len(*stats))
+		for i, stat := range *stats {
+			outbox[i] = model.StatsOutbox{
+				DateTime:  stat.DateTime,
+				Resource:  stat.Resource,
+				Tag:       stat.Tag,
+				Direction: stat.Direction,
+				Traffic:   stat.Traffic,
+			}
+		}
+		err = tx.Create(&outbox).Error
+		if err != nil {
+			return err
+		}
+	}
+
 	if !enableTraffic {
 		return nil
 	}
@@ -229,6 +260,48 @@ func (s *StatsService) GetClientOnlineDevices(clientName string) ([]model.Client
 	return onlines, nil
 }
 
+// GetOnlineDevices 获取各客户端在从节点上的在线设备 (client -> 来源 IP 列表)
+// excludeNodeId 非空时排除该节点上报的记录
+func (s *StatsService) GetOnlineDevices(excludeNodeId string) (map[string][]string, error) {
+	db := database.GetDB()
+	var onlines []model.ClientOnline
+	threshold := time.Now().Unix() - 60
+	query := db.Model(&model.ClientOnline{}).Select("DISTINCT client_name, source_ip").
+		Where("last_seen > ? AND source_ip != ''", threshold)
+	if excludeNodeId != "" {
+		query = query.Where("node_id != ?", excludeNodeId)
+	}
+	err := query.Scan(&onlines).Error
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string][]string)
+	for _, online := range onlines {
+		result[online.ClientName] = appendUnique(result[online.ClientName], online.SourceIP)
+	}
+	return result, nil
+}
+
+// UpdateDeviceLimits 刷新设备数限制所需的集群数据 (主节点/单机)
+// 主节点把从节点上报的在线设备交给本地 core，并落库本地的拒绝计数
+func (s *StatsService) UpdateDeviceLimits() error {
+	if config.IsWorker() || !corePtr.IsRunning() {
+		return nil
+	}
+	connTracker := corePtr.GetInstance().ConnTracker()
+
+	if config.IsMaster() {
+		devices, err := s.GetOnlineDevices("")
+		if err != nil {
+			return err
+		}
+		connTracker.SetRemoteDevices(devices)
+	}
+
+	var clientService ClientService
+	return clientService.AddDeviceRejected(connTracker.GetAndResetRejected())
+}
+
 // GetUniqueDeviceCount 获取客户端唯一设备数
 func (s *StatsService) GetUniqueDeviceCount(clientName string) (int, error) {
 	db := database.GetDB()
@@ -0,0 +1,233 @@
+package service
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/alireza0/s-ui/database"
+	"github.com/alireza0/s-ui/database/model"
+	"github.com/alireza0/s-ui/logger"
+)
+
+// subAccessUserAgentSize 记录的 User-Agent 最大长度
+const subAccessUserAgentSize = 256
+
+// 共享检测原因
+const (
+	SubShareReasonIPs       = "sub_ips"      // 窗口内获取订阅的不同 IP 过多
+	SubShareReasonNetworks  = "sub_networks" // 窗口内获取订阅的不同网段过多
+	SubShareReasonOnlineIPs = "online_ips"   // 同时在多个节点以不同 IP 在线
+)
+
+// SubShareSettings 共享检测设置
+type SubShareSettings struct {
+	Window       int64 // 检测窗口 (秒)
+	MaxIPs       int
+	MaxNetworks  int
+	MaxOnlineIPs int
+	Rotate       bool
+}
+
+// SubShareEventData 订阅疑似被共享事件数据
+type SubShareEventData struct {
+	ClientName string   `json:"clientName"`
+	UUID       string   `json:"uuid,omitempty"`
+	Reason     string   `json:"reason"`
+	Count      int      `json:"count"`
+	Limit      int      `json:"limit"`
+	Window     int64    `json:"window"`
+	IPs        []string `json:"ips"`
+	Nodes      []string `json:"nodes,omitempty"`
+	Rotated    bool     `json:"rotated"`
+}
+
+// 已告警客户端的告警时间，同一窗口内不重复告警
+var (
+	subShareFlagged      = make(map[uint]int64)
+	subShareFlaggedMutex sync.Mutex
+)
+
+type SubAccessService struct {
+}
+
+// RecordSubAccess 记录一次订阅获取
+func (s *SubAccessService) RecordSubAccess(client *model.Client, ip string, userAgent string, format string) {
+	var settingService SettingService
+	logAge, err := settingService.GetSubLogAge()
+	if err != nil || logAge <= 0 {
+		return
+	}
+	if len(userAgent) > subAccessUserAgentSize {
+		userAgent = userAgent[:subAccessUserAgentSize]
+	}
+	db := database.GetDB()
+	err = db.Create(&model.SubAccess{
+		DateTime:   time.Now().Unix(),
+		ClientId:   client.Id,
+		ClientName: client.Name,
+		IP:         ip,
+		UserAgent:  userAgent,
+		Format:     format,
+	}).Error
+	if err != nil {
+		logger.Warning("Failed to record subscription access: ", err)
+	}
+}
+
+// GetSubAccess 获取订阅获取记录 (最新的在前)，clientId 为 0 时返回所有客户端
+func (s *SubAccessService) GetSubAccess(clientId uint, limit int) ([]model.SubAccess, error) {
+	db := database.GetDB()
+	records := []model.SubAccess{}
+	query := db.Model(&model.SubAccess{})
+	if clientId > 0 {
+		query = query.Where("client_id = ?", clientId)
+	}
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	err := query.Order("id desc").Limit(limit).Find(&records).Error
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// DelOldSubAccess 删除超过保留天数的订阅获取记录
+func (s *SubAccessService) DelOldSubAccess() error {
+	var settingService SettingService
+	logAge, err := settingService.GetSubLogAge()
+	if err != nil {
+		return err
+	}
+	db := database.GetDB()
+	if logAge <= 0 {
+		return db.Where("id > 0").Delete(&model.SubAccess{}).Error
+	}
+	oldTime := time.Now().AddDate(0, 0, -logAge).Unix()
+	return db.Where("date_time < ?", oldTime).Delete(&model.SubAccess{}).Error
+}
+
+// subNetwork IP 所在网段 (IPv4 /24, IPv6 /48)，无法解析时返回原值
+func subNetwork(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ip
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String() + "/24"
+	}
+	return parsed.Mask(net.CIDRMask(48, 128)).String() + "/48"
+}
+
+// DetectSubSharing 检测订阅疑似被共享的客户端，发送事件并按设置轮换订阅令牌
+// 同一客户端在一个检测窗口内只告警一次
+func (s *SubAccessService) DetectSubSharing() error {
+	var settingService SettingService
+	settings, err := settingService.GetSubShareSettings()
+	if err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	events := make(map[uint]*SubShareEventData)
+
+	// 窗口内获取订阅的 IP
+	if settings.MaxIPs > 0 || settings.MaxNetworks > 0 {
+		var rows []model.SubAccess
+		err = database.GetDB().Model(&model.SubAccess{}).Select("DISTINCT client_id, ip").
+			Where("date_time > ? AND ip != ''", now-settings.Window).Scan(&rows).Error
+		if err != nil {
+			return err
+		}
+		ips := make(map[uint][]string)
+		networks := make(map[uint][]string)
+		for _, row := range rows {
+			ips[row.ClientId] = appendUnique(ips[row.ClientId], row.IP)
+			networks[row.ClientId] = appendUnique(networks[row.ClientId], subNetwork(row.IP))
+		}
+		for clientId, clientIPs := range ips {
+			switch {
+			case settings.MaxIPs > 0 && len(clientIPs) > settings.MaxIPs:
+				events[clientId] = &SubShareEventData{Reason: SubShareReasonIPs, Count: len(clientIPs), Limit: settings.MaxIPs, IPs: clientIPs}
+			case settings.MaxNetworks > 0 && len(networks[clientId]) > settings.MaxNetworks:
+				events[clientId] = &SubShareEventData{Reason: SubShareReasonNetworks, Count: len(networks[clientId]), Limit: settings.MaxNetworks, IPs: networks[clientId]}
+			}
+		}
+	}
+
+	// 同时在多个节点以不同 IP 在线
+	if settings.MaxOnlineIPs > 0 {
+		var onlines []model.ClientOnline
+		err = database.GetDB().Model(&model.ClientOnline{}).Select("DISTINCT client_name, node_id, source_ip").
+			Where("last_seen > ? AND source_ip != ''", now-60).Scan(&onlines).Error
+		if err != nil {
+			return err
+		}
+		ips := make(map[string][]string)
+		nodes := make(map[string][]string)
+		for _, online := range onlines {
+			ips[online.ClientName] = appendUnique(ips[online.ClientName], online.SourceIP)
+			nodes[online.ClientName] = appendUnique(nodes[online.ClientName], online.NodeId)
+		}
+		for name, clientIPs := range ips {
+			if len(nodes[name]) < 2 || len(clientIPs) <= settings.MaxOnlineIPs {
+				continue
+			}
+			var client model.Client
+			if err := database.GetDB().Select("id").Where("name = ?", name).First(&client).Error; err != nil {
+				continue
+			}
+			if _, exists := events[client.Id]; !exists {
+				events[client.Id] = &SubShareEventData{Reason: SubShareReasonOnlineIPs, Count: len(clientIPs), Limit: settings.MaxOnlineIPs, IPs: clientIPs, Nodes: nodes[name]}
+			}
+		}
+	}
+
+	for clientId, data := range events {
+		if !subShareShouldFlag(clientId, now, settings.Window) {
+			continue
+		}
+		s.flagSubSharing(clientId, data, settings)
+	}
+	return nil
+}
+
+// subShareShouldFlag 客户端是否需要告警 (距上次告警超过一个窗口)
+func subShareShouldFlag(clientId uint, now int64, window int64) bool {
+	subShareFlaggedMutex.Lock()
+	defer subShareFlaggedMutex.Unlock()
+	for id, flaggedAt := range subShareFlagged {
+		if now-flaggedAt >= window {
+			delete(subShareFlagged, id)
+		}
+	}
+	if _, flagged := subShareFlagged[clientId]; flagged {
+		return false
+	}
+	subShareFlagged[clientId] = now
+	return true
+}
+
+// flagSubSharing 发送共享事件，按设置轮换订阅令牌
+func (s *SubAccessService) flagSubSharing(clientId uint, data *SubShareEventData, settings *SubShareSettings) {
+	var client model.Client
+	if err := database.GetDB().Where("id = ?", clientId).First(&client).Error; err != nil {
+		return
+	}
+	data.ClientName = client.Name
+	data.UUID = client.UUID
+	data.Window = settings.Window
+
+	if settings.Rotate {
+		var clientService ClientService
+		if _, err := clientService.RotateSubToken(client.Id, 0); err != nil {
+			logger.Warning("Failed to rotate subscription token of ", client.Name, ": ", err)
+		} else {
+			data.Rotated = true
+		}
+	}
+
+	logger.Warning("Subscription sharing suspected: ", client.Name, ", reason: ", data.Reason, ", count: ", data.Count)
+	var webhookService WebhookService
+	webhookService.SendCallback(EventSubShareSuspected, data)
+}
@@ -0,0 +1,92 @@
+package service
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/alireza0/s-ui/util/common"
+)
+
+// SubFormatLinks 默认订阅格式：base64 编码的链接列表
+const SubFormatLinks = "links"
+
+// SubFormats 订阅支持的格式 (format 参数和 User-Agent 识别规则的取值)
+var SubFormats = map[string]bool{
+	SubFormatLinks: true,
+	"json":         true,
+	"clash":        true,
+	"surge":        true,
+	"loon":         true,
+	"quanx":        true,
+	"shadowrocket": true,
+}
+
+// SubFormatRule User-Agent 识别规则：User-Agent 包含 Match (不区分大小写) 时使用 Format
+type SubFormatRule struct {
+	Match  string `json:"match"`
+	Format string `json:"format"`
+}
+
+// defaultSubFormatRules 内置识别规则，按顺序匹配，第一个匹配的规则生效
+// 更具体的名称放在前面 (如 clash 系列客户端中的 Stash、sing-box 内核的图形客户端)
+var defaultSubFormatRules = []SubFormatRule{
+	{Match: "shadowrocket", Format: "shadowrocket"},
+	{Match: "quantumult", Format: "quanx"},
+	{Match: "surge", Format: "surge"},
+	{Match: "loon", Format: "loon"},
+	{Match: "stash", Format: "clash"},
+	{Match: "mihomo", Format: "clash"},
+	{Match: "clash", Format: "clash"},
+	{Match: "sing-box", Format: "json"},
+	{Match: "sfa/", Format: "json"},
+	{Match: "sfi/", Format: "json"},
+	{Match: "sfm/", Format: "json"},
+	{Match: "v2rayn", Format: SubFormatLinks}, // 包括 v2rayNG
+	{Match: "nekobox", Format: SubFormatLinks},
+	{Match: "hiddify", Format: SubFormatLinks},
+}
+
+// ParseSubFormatRules 解析设置中的识别规则 (JSON 数组)
+func ParseSubFormatRules(value string) ([]SubFormatRule, error) {
+	var rules []SubFormatRule
+	err := json.Unmarshal([]byte(value), &rules)
+	if err != nil {
+		return nil, common.NewError("invalid subFormatRules: ", err.Error())
+	}
+	for _, rule := range rules {
+		if strings.TrimSpace(rule.Match) == "" {
+			return nil, common.NewError("invalid subFormatRules: empty match")
+		}
+		if !SubFormats[rule.Format] {
+			return nil, common.NewError("invalid subFormatRules: unknown format ", rule.Format)
+		}
+	}
+	return rules, nil
+}
+
+// GetSubFormatRules 获取 User-Agent 识别规则，未设置时使用内置规则
+func (s *SettingService) GetSubFormatRules() ([]SubFormatRule, error) {
+	value, err := s.getString("subFormatRules")
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(value) == "" {
+		return defaultSubFormatRules, nil
+	}
+	return ParseSubFormatRules(value)
+}
+
+// DetectSubFormat 根据 User-Agent 选择订阅格式，没有匹配的规则时使用链接列表
+func (s *SettingService) DetectSubFormat(userAgent string) string {
+	rules, err := s.GetSubFormatRules()
+	if err != nil {
+		rules = defaultSubFormatRules
+	}
+	userAgent = strings.ToLower(userAgent)
+	for _, rule := range rules {
+		if strings.Contains(userAgent, strings.ToLower(strings.TrimSpace(rule.Match))) {
+			return rule.Format
+		}
+	}
+	return SubFormatLinks
+}
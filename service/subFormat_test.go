@@ -0,0 +1,73 @@
+package service
+
+import "testing"
+
+func TestParseSubFormatRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    int
+		wantErr bool
+	}{
+		{"empty list", `[]`, 0, false},
+		{"single rule", `[{"match":"mihomo","format":"clash"}]`, 1, false},
+		{"several rules", `[{"match":"foo","format":"json"},{"match":"bar","format":"links"}]`, 2, false},
+		{"unknown format", `[{"match":"foo","format":"xml"}]`, 0, true},
+		{"empty match", `[{"match":"  ","format":"json"}]`, 0, true},
+		{"not an array", `{"match":"foo","format":"json"}`, 0, true},
+		{"invalid json", `[`, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := ParseSubFormatRules(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSubFormatRules() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if len(rules) != tt.want {
+				t.Fatalf("ParseSubFormatRules() returned %d rules, want %d", len(rules), tt.want)
+			}
+		})
+	}
+}
+
+func TestDetectSubFormat(t *testing.T) {
+	initTestDB(t)
+	var settingService SettingService
+
+	tests := []struct {
+		name      string
+		rules     string
+		userAgent string
+		want      string
+	}{
+		{"shadowrocket", "", "Shadowrocket/2070 CFNetwork/1410.0.3 Darwin/22.6.0", "shadowrocket"},
+		{"quantumult x", "", "Quantumult%20X/1.4.1 (iPhone14,5; iOS 16.6)", "quanx"},
+		{"surge", "", "Surge iOS/2920", "surge"},
+		{"loon", "", "Loon/3.1.6 CFNetwork/1410.0.3", "loon"},
+		{"stash before clash", "", "Stash/2.4.7 Clash/1.9.0", "clash"},
+		{"mihomo", "", "mihomo/1.18.3", "clash"},
+		{"clash verge", "", "clash-verge/v1.7.7", "clash"},
+		{"sing-box", "", "sing-box 1.12.8", "json"},
+		{"sfa", "", "SFA/1.12.8 (Android 14)", "json"},
+		{"sfi", "", "SFI/1.12.8 (iOS 17.5)", "json"},
+		{"v2rayng", "", "v2rayNG/1.8.19", "links"},
+		{"nekobox", "", "NekoBox/Android/1.3.1", "links"},
+		{"hiddify", "", "HiddifyNext/1.5.2 (android)", "links"},
+		{"browser", "", "Mozilla/5.0 (Windows NT 10.0; Win64; x64)", "links"},
+		{"empty user agent", "", "", "links"},
+		{"custom rule", `[{"match":"MyClient","format":"json"}]`, "myclient/1.0", "json"},
+		{"custom rules replace builtin", `[{"match":"myclient","format":"json"}]`, "clash-verge/v1.7.7", "links"},
+		{"first matching custom rule wins", `[{"match":"client","format":"surge"},{"match":"myclient","format":"json"}]`, "myclient/1.0", "surge"},
+		{"invalid custom rules use builtin", `[{"match":"clash","format":"xml"}]`, "clash-verge/v1.7.7", "clash"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := settingService.setString("subFormatRules", tt.rules); err != nil {
+				t.Fatal(err)
+			}
+			if got := settingService.DetectSubFormat(tt.userAgent); got != tt.want {
+				t.Fatalf("DetectSubFormat(%q) = %s, want %s", tt.userAgent, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alireza0/s-ui/database"
+	"github.com/alireza0/s-ui/database/model"
+	"github.com/alireza0/s-ui/logger"
+	"github.com/alireza0/s-ui/util/common"
+)
+
+// 订阅令牌
+// 订阅链接 ID 为 "<令牌>.<过期时间>.<签名>"，令牌与凭据 UUID 无关，签名为主节点密钥对令牌和过期时间的 HMAC；
+// 过期时间为 0 表示长期有效。轮换令牌后旧链接立即失效；设置了令牌的客户端不再接受名称链接，
+// subUuidFallback 关闭时也不再接受 UUID 链接
+
+// subTokenSignSize 签名截取的字节数
+const subTokenSignSize = 16
+
+// 串行化签名密钥的首次生成
+var subSecretMutex sync.Mutex
+
+// SubTokenInfo 签发的订阅链接
+type SubTokenInfo struct {
+	SubToken  string `json:"subToken"`
+	SubId     string `json:"subId"`
+	ExpiresAt int64  `json:"expiresAt"`
+}
+
+// subSigningKey 读取订阅链接签名密钥，不存在时生成
+func (s *SettingService) subSigningKey() ([]byte, error) {
+	subSecretMutex.Lock()
+	defer subSecretMutex.Unlock()
+
+	secret, err := s.getString("subSecret")
+	if err != nil {
+		return nil, err
+	}
+	if secret == "" {
+		secret = common.Random(32)
+		if err = s.setString("subSecret", secret); err != nil {
+			return nil, err
+		}
+		logger.Info("Subscription signing key generated")
+	}
+	return []byte(secret), nil
+}
+
+// signSubToken 计算令牌和过期时间的签名
+func signSubToken(key []byte, token string, expiresAt string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(token + "." + expiresAt))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:subTokenSignSize])
+}
+
+// newSubToken 生成随机订阅令牌
+func newSubToken() (string, error) {
+	data := make([]byte, 12)
+	if _, err := rand.Read(data); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// RotateSubToken 为客户端生成新的订阅令牌，旧令牌签发的链接立即失效，并签发新链接
+func (s *ClientService) RotateSubToken(id uint, expiresAt int64) (*SubTokenInfo, error) {
+	token, err := newSubToken()
+	if err != nil {
+		return nil, err
+	}
+	db := database.GetDB()
+	result := db.Model(&model.Client{}).Where("id = ?", id).UpdateColumn("sub_token", token)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, common.NewError("client not found")
+	}
+	// 令牌随客户端同步到从节点
+	LastUpdate = time.Now().Unix()
+	notifyConfigChanged()
+	return s.SignSubToken(token, expiresAt)
+}
+
+// SignSubToken 用客户端当前的令牌签发订阅链接，不影响已签发的链接
+func (s *ClientService) SignSubToken(token string, expiresAt int64) (*SubTokenInfo, error) {
+	if token == "" {
+		return nil, common.NewError("client has no subscription token")
+	}
+	if expiresAt < 0 {
+		return nil, common.NewError("invalid expiresAt")
+	}
+	var settingService SettingService
+	key, err := settingService.subSigningKey()
+	if err != nil {
+		return nil, err
+	}
+	expires := strconv.FormatInt(expiresAt, 10)
+	return &SubTokenInfo{
+		SubToken:  token,
+		SubId:     token + "." + expires + "." + signSubToken(key, token, expires),
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+// GetSubClient 根据订阅链接 ID 查找启用的客户端
+// 依次尝试签名令牌、UUID 和名称 (subNameFallback 关闭时不按名称查找)；
+// 形如令牌但签名校验失败的 ID (如名称中带点) 仍按 UUID 和名称查找
+func (s *ClientService) GetSubClient(subId string) (*model.Client, error) {
+	db := database.GetDB()
+	client := &model.Client{}
+
+	var tokenErr error
+	if parts := strings.Split(subId, "."); len(parts) == 3 {
+		var token string
+		token, tokenErr = s.verifySubId(parts)
+		if tokenErr == nil {
+			err := db.Model(model.Client{}).Where("enable = true and sub_token = ?", token).First(client).Error
+			if err != nil {
+				return nil, err
+			}
+			return client, nil
+		}
+	}
+
+	client, err := s.getLegacySubClient(subId)
+	if err != nil && tokenErr != nil {
+		return nil, tokenErr
+	}
+	return client, err
+}
+
+// getLegacySubClient 按 UUID 和名称查找没有订阅令牌的客户端
+// subUuidFallback 开启时设置了令牌的客户端也接受 UUID 链接
+func (s *ClientService) getLegacySubClient(subId string) (*model.Client, error) {
+	db := database.GetDB()
+	client := &model.Client{}
+	var settingService SettingService
+
+	noToken := db.Where("sub_token = '' or sub_token is null")
+	query := db.Model(model.Client{}).Where("enable = true and uuid = ?", subId)
+	if uuidFallback, _ := settingService.GetSubUuidFallback(); !uuidFallback {
+		query = query.Where(noToken)
+	}
+	err := query.First(client).Error
+	if err == nil {
+		return client, nil
+	}
+	nameFallback, _ := settingService.GetSubNameFallback()
+	if !nameFallback {
+		return nil, err
+	}
+	err = db.Model(model.Client{}).Where("enable = true and name = ?", subId).Where(noToken).First(client).Error
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// verifySubId 校验签名和过期时间，返回令牌
+func (s *ClientService) verifySubId(parts []string) (string, error) {
+	token, expires, signature := parts[0], parts[1], parts[2]
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if token == "" || err != nil || expiresAt < 0 {
+		return "", common.NewError("invalid subscription token")
+	}
+	var settingService SettingService
+	key, err := settingService.subSigningKey()
+	if err != nil {
+		return "", err
+	}
+	if !hmac.Equal([]byte(signature), []byte(signSubToken(key, token, expires))) {
+		return "", common.NewError("invalid subscription token signature")
+	}
+	if expiresAt > 0 && time.Now().Unix() > expiresAt {
+		return "", common.NewError("subscription token expired")
+	}
+	return token, nil
+}
@@ -0,0 +1,127 @@
+package service
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alireza0/s-ui/database"
+	"github.com/alireza0/s-ui/database/model"
+)
+
+func TestVerifySubId(t *testing.T) {
+	initTestDB(t)
+	var settingService SettingService
+	key, err := settingService.subSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sign := func(token string, expires string) string {
+		return token + "." + expires + "." + signSubToken(key, token, expires)
+	}
+	future := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+	past := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	valid := sign("token", "0")
+
+	tests := []struct {
+		name    string
+		subId   string
+		want    string
+		wantErr bool
+	}{
+		{"no expiry", valid, "token", false},
+		{"future expiry", sign("token", future), "token", false},
+		{"expired", sign("token", past), "", true},
+		{"wrong signature", "token.0." + signSubToken([]byte("other key"), "token", "0"), "", true},
+		{"token changed", "other" + strings.TrimPrefix(valid, "token"), "", true},
+		{"expiry extended", "token." + future + "." + signSubToken(key, "token", "0"), "", true},
+		{"negative expiry", sign("token", "-1"), "", true},
+		{"non-numeric expiry", sign("token", "never"), "", true},
+		{"empty token", sign("", "0"), "", true},
+	}
+	var clientService ClientService
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := clientService.verifySubId(strings.Split(tt.subId, "."))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("verifySubId() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Fatalf("verifySubId() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetSubClient(t *testing.T) {
+	initTestDB(t)
+	db := database.GetDB()
+	clients := []model.Client{
+		{Enable: true, Name: "alice", UUID: "uuid-alice", SubToken: "token-alice"},
+		{Enable: true, Name: "bob", UUID: "uuid-bob"},
+		{Enable: true, Name: "carol.b.c", UUID: "uuid-carol"},
+		{Enable: false, Name: "dave", UUID: "uuid-dave", SubToken: "token-dave"},
+	}
+	if err := db.Create(&clients).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	var clientService ClientService
+	var settingService SettingService
+	alice, err := clientService.SignSubToken("token-alice", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dave, err := clientService.SignSubToken("token-dave", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale, err := clientService.SignSubToken("token-old", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		subId        string
+		uuidFallback bool
+		nameFallback bool
+		want         string
+	}{
+		{"signed link", alice.SubId, true, true, "alice"},
+		{"signed link of disabled client", dave.SubId, true, true, ""},
+		{"signed link of rotated token", stale.SubId, true, true, ""},
+		{"uuid of client with token", "uuid-alice", true, true, "alice"},
+		{"uuid of client with token without fallback", "uuid-alice", false, true, ""},
+		{"name of client with token", "alice", true, true, ""},
+		{"uuid of client without token", "uuid-bob", false, true, "bob"},
+		{"name of client without token", "bob", true, true, "bob"},
+		{"name without name fallback", "bob", true, false, ""},
+		{"dotted name falls back to name lookup", "carol.b.c", true, true, "carol.b.c"},
+		{"unknown", "nobody", true, true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := settingService.setString("subUuidFallback", strconv.FormatBool(tt.uuidFallback)); err != nil {
+				t.Fatal(err)
+			}
+			if err := settingService.setString("subNameFallback", strconv.FormatBool(tt.nameFallback)); err != nil {
+				t.Fatal(err)
+			}
+			client, err := clientService.GetSubClient(tt.subId)
+			if tt.want == "" {
+				if err == nil {
+					t.Fatalf("GetSubClient() = %s, want error", client.Name)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GetSubClient() error = %v", err)
+			}
+			if client.Name != tt.want {
+				t.Fatalf("GetSubClient() = %s, want %s", client.Name, tt.want)
+			}
+		})
+	}
+}
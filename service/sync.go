@@ -2,10 +2,15 @@ package service
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -17,6 +22,7 @@ import (
 
 	"github.com/shirou/gopsutil/v4/cpu"
 	"github.com/shirou/gopsutil/v4/mem"
+	"gorm.io/gorm"
 )
 
 // SyncService 从节点同步服务
@@ -27,6 +33,7 @@ type SyncService struct {
 	nodeId       string
 	nodeToken    string
 	localVersion int64
+	localHash    string
 	running      bool
 	stopChan     chan struct{}
 	wg           sync.WaitGroup
@@ -34,13 +41,70 @@ type SyncService struct {
 	mutex        sync.Mutex
 	stopOnce     sync.Once
 
-	// 待上报的统计数据 (上报失败时保留)
-	pendingStats []model.Stats
+	// 配置推送 (长轮询) 专用客户端，超时需大于主节点的等待时间
+	watchClient *http.Client
+	// 推送通道是否连通，连通时暂停版本轮询
+	streamConnected bool
+	// 主节点是否已将本节点置为排空模式
+	draining bool
+	// 流量预算用满后是否只服务会员客户端
+	budgetLimited bool
+	// 串行化配置同步 (推送和轮询可能同时触发)
+	configMutex sync.Mutex
+
+	// 待上报的设备超限拒绝计数 (上报失败时保留)
+	pendingRejected map[string]int64
+
+	// 节点密钥 (注册时获得，保存在本地设置中)，用于请求签名
+	nodeSecret  string
+	secretMutex sync.RWMutex
+	// 轮换前的节点密钥 (由 secretMutex 保护)，新密钥被主节点拒绝时恢复
+	prevSecret string
+	// 固定的主节点配置签名公钥和已应用配置的序号 (由 secretMutex 保护)
+	masterSignKey string
+	configSerial  int64
+	// 最近一次配置校验失败的原因，通过心跳上报
+	configError string
+
+	// 主节点签发的客户端证书 (mTLS)，续签后通过 GetClientCertificate 切换
+	clientCert *tls.Certificate
+	certMutex  sync.RWMutex
+	// 最近一次在没有证书时申请证书的时间，心跳中按间隔重试
+	certRequestedAt time.Time
+	// 保护 client 和 watchClient，首次获得证书时替换
+	clientMutex sync.RWMutex
+
+	// 串行执行远程命令
+	commandMutex sync.Mutex
+	// 已执行的命令 (由 commandMutex 保护，同时保存到设置中)，重新下发时不重复执行
+	doneCommands map[uint]*doneNodeCommand
+	// 保护设置中回报失败的命令结果 (下一次心跳时重试)
+	resultMutex sync.Mutex
 }
 
 // NewSyncService 创建同步服务
 func NewSyncService(configService *ConfigService) *SyncService {
-	return &SyncService{
+	nodeSecret, err := configService.SettingService.GetNodeSecret()
+	if err != nil {
+		logger.Warning("Failed to load node secret: ", err)
+	}
+	localHash, err := configService.SettingService.GetNodeConfigHash()
+	if err != nil {
+		logger.Warning("Failed to load local config version: ", err)
+	}
+	masterSignKey, err := configService.SettingService.GetMasterSignKey()
+	if err != nil {
+		logger.Warning("Failed to load master signing key: ", err)
+	}
+	configSerial, err := configService.SettingService.GetNodeConfigSerial()
+	if err != nil {
+		logger.Warning("Failed to load applied config serial: ", err)
+	}
+	s := &SyncService{
+		nodeSecret:    nodeSecret,
+		masterSignKey: masterSignKey,
+		configSerial:  configSerial,
+		localHash:     localHash,
 		configService: configService,
 		masterAddr:    config.GetMasterAddr(),
 		nodeId:        config.GetNodeId(),
@@ -49,7 +113,14 @@ func NewSyncService(configService *ConfigService) *SyncService {
 		client: &http.Client{
 			Timeout: 30 * time.Second,
 		},
+		watchClient: &http.Client{
+			Timeout: 70 * time.Second,
+		},
+	}
+	if err := s.loadClientCert(); err != nil {
+		logger.Warning("Failed to load node client certificate: ", err)
 	}
+	return s
 }
 
 // Start 启动同步服务
@@ -72,7 +143,10 @@ func (s *SyncService) Start() error {
 	if err := s.ensureRegistered(); err != nil {
 		logger.Warning("Failed to register with master: ", err)
 		// 继续运行，使用本地缓存
+	} else if err := s.ensureClientCert(true); err != nil {
+		logger.Warning("Failed to obtain node client certificate: ", err)
 	}
+	s.loadMasterSignKey()
 
 	// 首次同步配置
 	if err := s.syncConfig(); err != nil {
@@ -81,7 +155,8 @@ func (s *SyncService) Start() error {
 	}
 
 	// 启动定时任务
-	s.wg.Add(3)
+	s.wg.Add(4)
+	go s.configWatchLoop()
 	go s.configSyncLoop()
 	go s.statsReportLoop()
 	go s.heartbeatLoop()
@@ -122,20 +197,51 @@ func (s *SyncService) ensureRegistered() error {
 
 // checkLocalRegistration 检查本地注册状态
 func (s *SyncService) checkLocalRegistration() bool {
-	// 检查是否有保存的 token (通过命令行参数传入的 token 在首次注册后会被保存)
-	// 这里简单判断：如果有 nodeToken 且能成功验证，则认为已注册
-	if s.nodeToken == "" {
+	// 已保存节点密钥时用签名认证，否则按旧版方式用注册 token 认证 (主节点会通过心跳下发节点密钥)
+	// 能成功获取配置版本则认为已注册
+	if s.getNodeSecret() == "" && s.nodeToken == "" {
 		return false
 	}
 
 	// 尝试获取配置版本来验证 token 是否有效
-	_, err := s.getConfigVersion()
+	_, _, err := s.getConfigVersion()
+	if errors.Is(err, errNodeUnauthorized) && s.getNodeSecret() != "" && s.nodeToken != "" {
+		return s.fallbackToToken()
+	}
 	return err == nil
 }
 
+// fallbackToToken 节点密钥被主节点吊销 (轮换) 后改用重新配置的注册 token 认证，主节点通过心跳下发新密钥
+// token 也无法认证时保留原密钥，避免时钟偏差等原因导致丢失仍然有效的密钥
+func (s *SyncService) fallbackToToken() bool {
+	s.secretMutex.Lock()
+	secret := s.nodeSecret
+	s.nodeSecret = ""
+	s.secretMutex.Unlock()
+
+	if _, _, err := s.getConfigVersion(); err != nil {
+		s.secretMutex.Lock()
+		s.nodeSecret = secret
+		s.secretMutex.Unlock()
+		return false
+	}
+	if err := s.configService.SettingService.SetNodeSecret(""); err != nil {
+		logger.Warning("Failed to clear node secret: ", err)
+	}
+	logger.Info("Node secret rejected by master, authenticating with node token")
+	return true
+}
+
 // register 向主节点注册
 func (s *SyncService) register() error {
+	// 同时申请客户端证书，私钥只保存在本地
+	keyPEM, csrPEM, err := NewNodeCertRequest(s.nodeId)
+	if err != nil {
+		return err
+	}
+
 	reqBody := map[string]interface{}{
+		"csr":          csrPEM,
 		"token":        s.nodeToken,
 		"nodeId":       s.nodeId,
 		"name":         config.GetNodeName(),
@@ -153,13 +259,348 @@ func (s *SyncService) register() error {
 		return fmt.Errorf("registration failed: %s", resp.Msg)
 	}
 
+	if obj, ok := resp.Obj.(map[string]interface{}); ok {
+		if secret, ok := obj["secret"].(string); ok && secret != "" {
+			if err := s.setNodeSecret(secret); err != nil {
+				return err
+			}
+		}
+		if err := s.saveClientCert(obj, keyPEM); err != nil {
+			logger.Warning("Failed to save node client certificate: ", err)
+		}
+		// 新注册的节点在主节点重新计数
+		if err := s.resetConfigSerial(); err != nil {
+			return err
+		}
+		signKey, _ := obj["signKey"].(string)
+		if err := s.pinMasterSignKey(signKey); err != nil {
+			return err
+		}
+	}
+
 	logger.Info("Node registered successfully")
 	return nil
 }
 
+// ========== 客户端证书 (mTLS) ==========
+
+// loadClientCert 读取本地保存的主节点 CA 和客户端证书，并配置连接主节点的 TLS
+func (s *SyncService) loadClientCert() error {
+	caPEM, certPEM, keyPEM, err := s.configService.SettingService.GetNodeClientCert()
+	if err != nil || caPEM == "" || certPEM == "" {
+		return err
+	}
+	cert, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
+	if err != nil {
+		return err
+	}
+	s.certMutex.Lock()
+	s.clientCert = &cert
+	s.certMutex.Unlock()
+	return s.setupClientTLS(caPEM)
+}
+
+// setupClientTLS 只信任主节点 CA (证书固定)，并在握手时出示客户端证书
+// 运行中首次获得证书时替换 HTTP 客户端，进行中的请求继续使用原客户端；续签只替换证书，不需要重建连接
+func (s *SyncService) setupClientTLS(caPEM string) error {
+	tlsConfig, err := NodeClientTLSConfig(caPEM, s.getClientCertificate)
+	if err != nil {
+		return err
+	}
+
+	s.clientMutex.Lock()
+	oldClient, oldWatchClient := s.client, s.watchClient
+	s.client = &http.Client{
+		Timeout: oldClient.Timeout,
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConfig,
+		},
+	}
+	s.watchClient = &http.Client{
+		Timeout: oldWatchClient.Timeout,
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConfig.Clone(),
+		},
+	}
+	s.clientMutex.Unlock()
+
+	oldClient.CloseIdleConnections()
+	oldWatchClient.CloseIdleConnections()
+	return nil
+}
+
+// httpClient 获取请求主节点的 HTTP 客户端
+func (s *SyncService) httpClient() *http.Client {
+	s.clientMutex.RLock()
+	defer s.clientMutex.RUnlock()
+	return s.client
+}
+
+// watchHTTPClient 获取配置推送 (长轮询) 的 HTTP 客户端
+func (s *SyncService) watchHTTPClient() *http.Client {
+	s.clientMutex.RLock()
+	defer s.clientMutex.RUnlock()
+	return s.watchClient
+}
+
+func (s *SyncService) getClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	s.certMutex.RLock()
+	defer s.certMutex.RUnlock()
+	if s.clientCert == nil {
+		return &tls.Certificate{}, nil
+	}
+	return s.clientCert, nil
+}
+
+// ensureClientCert 客户端证书不存在或即将过期时向主节点申请
+// 没有证书时启动时 (requestNew) 立即申请，心跳中每隔 nodeCertRetryInterval 重试一次，
+// 主节点在从节点启动后才开启 TLS 时无需重启从节点即可获得证书
+func (s *SyncService) ensureClientCert(requestNew bool) error {
+	_, certPEM, _, err := s.configService.SettingService.GetNodeClientCert()
+	if err != nil {
+		return err
+	}
+	if certPEM == "" {
+		// 客户端证书只用于 HTTPS 连接
+		if !strings.HasPrefix(s.masterAddr, "https://") {
+			return nil
+		}
+		if !requestNew && time.Since(s.certRequestedAt) < nodeCertRetryInterval {
+			return nil
+		}
+		s.certRequestedAt = time.Now()
+	} else if !nodeCertNeedsRenew(certPEM) {
+		return nil
+	}
+
+	keyPEM, csrPEM, err := NewNodeCertRequest(s.nodeId)
+	if err != nil {
+		return err
+	}
+	resp, err := s.doRequest("POST", "/node/cert", map[string]interface{}{"csr": csrPEM}, true)
+	if err != nil {
+		return err
+	}
+	if !resp.Success {
+		// 主节点未终止 TLS 时不签发证书，继续只使用签名认证
+		logger.Info("Master does not issue node client certificates: ", resp.Msg)
+		return nil
+	}
+	obj, ok := resp.Obj.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("invalid certificate response")
+	}
+	return s.saveClientCert(obj, keyPEM)
+}
+
+// saveClientCert 保存主节点返回的客户端证书和 CA
+func (s *SyncService) saveClientCert(obj map[string]interface{}, keyPEM string) error {
+	certPEM, _ := obj["clientCert"].(string)
+	caPEM, _ := obj["caCert"].(string)
+	if certPEM == "" || caPEM == "" {
+		return nil
+	}
+	cert, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
+	if err != nil {
+		return err
+	}
+	if err = s.configService.SettingService.SetNodeClientCert(caPEM, certPEM, keyPEM); err != nil {
+		return err
+	}
+
+	s.certMutex.Lock()
+	first := s.clientCert == nil
+	s.clientCert = &cert
+	s.certMutex.Unlock()
+
+	// 首次获得证书时配置 TLS (可能在心跳中获得)，续签只替换证书
+	if first {
+		if err = s.setupClientTLS(caPEM); err != nil {
+			return err
+		}
+	}
+	logger.Info("Node client certificate updated")
+	return nil
+}
+
+func (s *SyncService) getNodeSecret() string {
+	s.secretMutex.RLock()
+	defer s.secretMutex.RUnlock()
+	return s.nodeSecret
+}
+
+// setNodeSecret 保存节点密钥，之后的请求使用新密钥签名
+func (s *SyncService) setNodeSecret(secret string) error {
+	s.secretMutex.Lock()
+	defer s.secretMutex.Unlock()
+	if secret == s.nodeSecret {
+		return nil
+	}
+	if err := s.configService.SettingService.SetNodeSecret(secret); err != nil {
+		return err
+	}
+	s.nodeSecret = secret
+	return nil
+}
+
+// acceptNextSecret 解密并切换到主节点轮换的新密钥，随即用新密钥发送一次请求使其在主节点生效
+// 新密钥被拒绝 (如已过期) 时恢复原密钥，主节点会在之后的心跳中重新下发
+func (s *SyncService) acceptNextSecret(sealed string) {
+	current := s.getNodeSecret()
+	next, err := openNodeSecret(current, s.nodeId, sealed)
+	if err != nil {
+		logger.Warning("Failed to open rotated node secret: ", err)
+		return
+	}
+	if err := s.setNodeSecret(next); err != nil {
+		logger.Warning("Failed to save node secret: ", err)
+		return
+	}
+	s.secretMutex.Lock()
+	s.prevSecret = current
+	s.secretMutex.Unlock()
+
+	// 通过认证后 doRequestWithHeaders 丢弃原密钥，401 时恢复原密钥；网络错误时保留原密钥，之后的请求再确认
+	if _, _, err := s.getConfigVersion(); err == nil {
+		logger.Info("Node secret rotated by master")
+	} else {
+		logger.Warning("Failed to confirm rotated node secret: ", err)
+	}
+}
+
+// confirmSecret 用当前密钥签名的请求通过认证，说明主节点已接受新密钥，不再需要轮换前的密钥
+func (s *SyncService) confirmSecret(secret string) {
+	s.secretMutex.Lock()
+	defer s.secretMutex.Unlock()
+	if secret == s.nodeSecret {
+		s.prevSecret = ""
+	}
+}
+
+// restorePrevSecret 用新密钥签名的请求被主节点拒绝时恢复轮换前的密钥
+func (s *SyncService) restorePrevSecret(secret string) {
+	s.secretMutex.Lock()
+	defer s.secretMutex.Unlock()
+	if s.prevSecret == "" || secret != s.nodeSecret {
+		return
+	}
+	if err := s.configService.SettingService.SetNodeSecret(s.prevSecret); err != nil {
+		logger.Warning("Failed to restore node secret: ", err)
+		return
+	}
+	s.nodeSecret, s.prevSecret = s.prevSecret, ""
+	logger.Warning("Rotated node secret rejected by master, restored the previous secret")
+}
+
 // ========== 配置同步 ==========
 
-// configSyncLoop 配置同步循环
+// configWatchLoop 配置推送循环
+// 通过长轮询等待主节点通知配置变更，断开后按指数退避重连，期间由 configSyncLoop 轮询兜底
+func (s *SyncService) configWatchLoop() {
+	defer s.wg.Done()
+
+	// 停止时取消正在等待的长轮询请求
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-s.stopChan:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	backoff := minWatchBackoff
+	for {
+		select {
+		case <-s.stopChan:
+			return
+		default:
+		}
+
+		err := s.watchConfig(ctx)
+		if err == nil {
+			s.setStreamConnected(true)
+			backoff = minWatchBackoff
+			continue
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		if s.isStreamConnected() {
+			logger.Warning("Config stream disconnected, falling back to polling: ", err)
+		} else {
+			logger.Debug("Config stream reconnect failed: ", err)
+		}
+		s.setStreamConnected(false)
+
+		select {
+		case <-s.stopChan:
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > maxWatchBackoff {
+			backoff = maxWatchBackoff
+		}
+	}
+}
+
+const (
+	minWatchBackoff = time.Second
+	maxWatchBackoff = time.Minute
+)
+
+// watchConfig 等待一次配置变更通知，有变更时立即同步
+func (s *SyncService) watchConfig(ctx context.Context) error {
+	// commands=1 表示支持通过推送通道获知新命令；旧版主节点忽略 hash，按 version 等待
+	version, hash := s.getLocalVersion()
+	path := "/node/config/watch?commands=1&hash=" + hash + "&version=" + strconv.FormatInt(version, 10)
+	resp, err := s.doRequestWithClient(ctx, s.watchHTTPClient(), "GET", path, nil, true)
+	if err != nil {
+		return err
+	}
+
+	if !resp.Success {
+		return fmt.Errorf("watch config failed: %s", resp.Msg)
+	}
+
+	if commands, _ := resp.Raw["commands"].(bool); commands {
+		// 获取失败时按推送通道断开处理 (退避重连)，避免反复立即返回
+		if err := s.pullCommands(); err != nil {
+			return err
+		}
+	}
+
+	if changed, _ := resp.Raw["changed"].(bool); changed {
+		logger.Info("Config change notified by master, syncing...")
+		return s.syncConfig()
+	}
+	return nil
+}
+
+func (s *SyncService) setStreamConnected(connected bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.streamConnected = connected
+}
+
+func (s *SyncService) isStreamConnected() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.streamConnected
+}
+
+func (s *SyncService) getLocalVersion() (int64, string) {
+	s.configMutex.Lock()
+	defer s.configMutex.Unlock()
+	return s.localVersion, s.localHash
+}
+
+// configSyncLoop 配置同步循环 (推送通道断开时轮询)
 func (s *SyncService) configSyncLoop() {
 	defer s.wg.Done()
 
@@ -172,6 +613,9 @@ func (s *SyncService) configSyncLoop() {
 		case <-s.stopChan:
 			return
 		case <-ticker.C:
+			if s.isStreamConnected() {
+				continue
+			}
 			if err := s.syncConfigIfNeeded(); err != nil {
 				logger.Warning("Config sync failed: ", err)
 			}
@@ -182,13 +626,14 @@ func (s *SyncService) configSyncLoop() {
 // syncConfigIfNeeded 检查并同步配置
 func (s *SyncService) syncConfigIfNeeded() error {
 	// 获取远程版本
-	remoteVersion, err := s.getConfigVersion()
+	remoteVersion, remoteHash, err := s.getConfigVersion()
 	if err != nil {
 		return err
 	}
 
-	// 版本相同，跳过
-	if remoteVersion == s.localVersion {
+	// 版本相同，跳过 (旧版主节点没有 hash，比较 LastUpdate 时间戳)
+	localVersion, localHash := s.getLocalVersion()
+	if remoteHash != "" && remoteHash == localHash || remoteHash == "" && remoteVersion == localVersion {
 		return nil
 	}
 
@@ -196,28 +641,46 @@ func (s *SyncService) syncConfigIfNeeded() error {
 	return s.syncConfig()
 }
 
-// getConfigVersion 获取远程配置版本
-func (s *SyncService) getConfigVersion() (int64, error) {
+// getConfigVersion 获取远程配置版本 (LastUpdate 时间戳和配置内容的哈希)
+func (s *SyncService) getConfigVersion() (int64, string, error) {
 	resp, err := s.doRequest("GET", "/node/config/version", nil, true)
 	if err != nil {
-		return 0, err
+		return 0, "", err
 	}
 
 	if !resp.Success {
-		return 0, fmt.Errorf("get version failed: %s", resp.Msg)
+		return 0, "", fmt.Errorf("get version failed: %s", resp.Msg)
 	}
 
 	version, ok := resp.Raw["version"].(float64)
 	if !ok {
-		return 0, fmt.Errorf("invalid version format")
+		return 0, "", fmt.Errorf("invalid version format")
 	}
+	hash, _ := resp.Raw["hash"].(string)
 
-	return int64(version), nil
+	return int64(version), hash, nil
 }
 
-// syncConfig 同步完整配置
+// syncConfig 同步配置
+// 已有本地版本时请求增量，只更新变化的行并热加载受影响的入站等；主节点无法提供增量时全量同步
 func (s *SyncService) syncConfig() error {
-	resp, err := s.doRequest("GET", "/node/config", nil, true)
+	s.configMutex.Lock()
+	defer s.configMutex.Unlock()
+
+	// 本地版本与主节点相同时返回 304，不重复下载配置
+	path := "/node/config"
+	var headers map[string]string
+	if s.localHash != "" {
+		path += "?since=" + s.localHash
+		headers = map[string]string{"If-None-Match": strconv.Quote(s.localHash)}
+	} else if s.localVersion > 0 {
+		path += "?since=" + strconv.FormatInt(s.localVersion, 10)
+	}
+	resp, err := s.doRequestWithHeaders(context.Background(), s.httpClient(), "GET", path, nil, true, headers)
+	if err == errNotModified {
+		logger.Debug("Config not modified, version: ", s.localHash)
+		return nil
+	}
 	if err != nil {
 		return err
 	}
@@ -226,28 +689,67 @@ func (s *SyncService) syncConfig() error {
 		return fmt.Errorf("get config failed: %s", resp.Msg)
 	}
 
+	// 校验主节点签名，失败时不应用，继续使用本地配置并通过心跳上报
+	var signed struct {
+		Obj       json.RawMessage `json:"obj"`
+		Serial    int64           `json:"serial"`
+		Signature string          `json:"signature"`
+	}
+	if err := json.Unmarshal(resp.body, &signed); err != nil {
+		return err
+	}
+	if err := s.verifyConfig(signed.Obj, signed.Serial, signed.Signature); err != nil {
+		s.setConfigError(err)
+		return err
+	}
+
 	// 解析配置
-	obj, ok := resp.Raw["obj"].(map[string]interface{})
-	if !ok {
+	var obj map[string]interface{}
+	if err := json.Unmarshal(signed.Obj, &obj); err != nil {
 		return fmt.Errorf("invalid config format")
 	}
 
-	// 应用配置到本地数据库
-	if err := s.applyConfig(obj); err != nil {
-		return err
+	// 旧版主节点不返回 full 字段，按全量处理
+	full, ok := obj["full"].(bool)
+	if !ok || full {
+		// 应用配置到本地数据库
+		if err := s.applyConfig(obj); err != nil {
+			return err
+		}
+
+		// 重启 Core 应用新配置
+		if err := s.configService.RestartCore(); err != nil {
+			logger.Warning("Failed to restart core: ", err)
+		}
+	} else {
+		// 增量必须基于本地版本计算 (since 在签名范围内，防止重放其他版本的增量)
+		if since, _ := obj["since"].(string); since != s.localHash {
+			err := fmt.Errorf("config diff base mismatch: %s", since)
+			s.setConfigError(err)
+			return err
+		}
+		changes, err := s.applyConfigDiff(obj)
+		if err != nil {
+			return err
+		}
+		s.reloadConfigChanges(changes)
 	}
 
-	// 更新本地版本
+	// 更新本地版本，配置内容的哈希保存到本地设置
 	if version, ok := obj["version"].(float64); ok {
 		s.localVersion = int64(version)
 	}
-
-	// 重启 Core 应用新配置
-	if err := s.configService.RestartCore(); err != nil {
-		logger.Warning("Failed to restart core: ", err)
+	hash, _ := obj["hash"].(string)
+	if hash != s.localHash {
+		s.localHash = hash
+		if err := s.configService.SettingService.SetNodeConfigHash(hash); err != nil {
+			logger.Warning("Failed to save local config version: ", err)
+		}
 	}
 
-	logger.Info("Config synced successfully, version: ", s.localVersion)
+	s.setConfigSerial(signed.Serial)
+	s.setConfigError(nil)
+	logger.Info("Config synced successfully, version: ", s.localHash, " (", s.localVersion, ")")
 	return nil
 }
 
@@ -359,6 +861,445 @@ func (s *SyncService) applyConfig(configData map[string]interface{}) error {
 	return tx.Commit().Error
 }
 
+// syncChanges 增量同步后需要应用到 core 的变更
+type syncChanges struct {
+	// 系统配置中影响 core 的部分 (见 coreConfigSections) 变化，需要重启 core
+	config bool
+	// 表名 -> 需要从 core 移除的旧 tag (已删除或已改名)
+	removed map[string][]string
+	// 表名 -> 需要重新加载的 id
+	reload map[string]map[uint]bool
+}
+
+func (c *syncChanges) addReload(table string, ids ...uint) {
+	if c.reload[table] == nil {
+		c.reload[table] = make(map[uint]bool)
+	}
+	for _, id := range ids {
+		c.reload[table][id] = true
+	}
+}
+
+// syncTaggedRow 入站、出站、端点和服务的公共字段
+type syncTaggedRow struct {
+	Id      uint
+	Type    string
+	Tag     string
+	TlsId   uint
+	Options json.RawMessage
+}
+
+// applyConfigDiff 在一个事务中应用增量配置，返回需要热加载的变更
+func (s *SyncService) applyConfigDiff(configData map[string]interface{}) (*syncChanges, error) {
+	changes := &syncChanges{
+		removed: make(map[string][]string),
+		reload:  make(map[string]map[uint]bool),
+	}
+	changedData, _ := configData["changed"].(map[string]interface{})
+	deletedData, _ := configData["deleted"].(map[string]interface{})
+
+	db := database.GetDB()
+	tx := db.Begin()
+
+	var err error
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var changedTls []uint
+	for _, table := range syncTables {
+		rowsJSON, _ := json.Marshal(changedData[table])
+		var deletedIds []uint
+		idsJSON, _ := json.Marshal(deletedData[table])
+		json.Unmarshal(idsJSON, &deletedIds)
+
+		switch table {
+		case "tls":
+			var rows []model.Tls
+			if err = json.Unmarshal(rowsJSON, &rows); err != nil {
+				return nil, err
+			}
+			for _, row := range rows {
+				changedTls = append(changedTls, row.Id)
+				if err = tx.Save(&row).Error; err != nil {
+					return nil, err
+				}
+			}
+			if len(deletedIds) > 0 {
+				if err = tx.Where("id in ?", deletedIds).Delete(model.Tls{}).Error; err != nil {
+					return nil, err
+				}
+			}
+		case "inbounds":
+			var rows []model.Inbound
+			if err = json.Unmarshal(rowsJSON, &rows); err != nil {
+				return nil, err
+			}
+			for _, row := range rows {
+				if err = s.diffTaggedRow(tx, table, syncTaggedRow{row.Id, row.Type, row.Tag, row.TlsId, row.Options}, changes); err != nil {
+					return nil, err
+				}
+				if err = tx.Save(&row).Error; err != nil {
+					return nil, err
+				}
+			}
+			if err = s.deleteTaggedRows(tx, table, model.Inbound{}, deletedIds, changes); err != nil {
+				return nil, err
+			}
+		case "outbounds":
+			var rows []model.Outbound
+			if err = json.Unmarshal(rowsJSON, &rows); err != nil {
+				return nil, err
+			}
+			for _, row := range rows {
+				if err = s.diffTaggedRow(tx, table, syncTaggedRow{row.Id, row.Type, row.Tag, 0, row.Options}, changes); err != nil {
+					return nil, err
+				}
+				if err = tx.Save(&row).Error; err != nil {
+					return nil, err
+				}
+			}
+			if err = s.deleteTaggedRows(tx, table, model.Outbound{}, deletedIds, changes); err != nil {
+				return nil, err
+			}
+		case "endpoints":
+			var rows []model.Endpoint
+			if err = json.Unmarshal(rowsJSON, &rows); err != nil {
+				return nil, err
+			}
+			for _, row := range rows {
+				if err = s.diffTaggedRow(tx, table, syncTaggedRow{row.Id, row.Type, row.Tag, 0, row.Options}, changes); err != nil {
+					return nil, err
+				}
+				if err = tx.Save(&row).Error; err != nil {
+					return nil, err
+				}
+			}
+			if err = s.deleteTaggedRows(tx, table, model.Endpoint{}, deletedIds, changes); err != nil {
+				return nil, err
+			}
+		case "services":
+			var rows []model.Service
+			if err = json.Unmarshal(rowsJSON, &rows); err != nil {
+				return nil, err
+			}
+			for _, row := range rows {
+				if err = s.diffTaggedRow(tx, table, syncTaggedRow{row.Id, row.Type, row.Tag, row.TlsId, row.Options}, changes); err != nil {
+					return nil, err
+				}
+				if err = tx.Save(&row).Error; err != nil {
+					return nil, err
+				}
+			}
+			if err = s.deleteTaggedRows(tx, table, model.Service{}, deletedIds, changes); err != nil {
+				return nil, err
+			}
+		case "clients":
+			var rows []model.Client
+			if err = json.Unmarshal(rowsJSON, &rows); err != nil {
+				return nil, err
+			}
+			for _, row := range rows {
+				var old model.Client
+				if err = tx.Model(model.Client{}).Where("id = ?", row.Id).Find(&old).Error; err != nil {
+					return nil, err
+				}
+				changes.addReload("inbounds", clientInboundChanges(&old, &row)...)
+				if err = tx.Save(&row).Error; err != nil {
+					return nil, err
+				}
+			}
+			if len(deletedIds) > 0 {
+				var olds []model.Client
+				if err = tx.Model(model.Client{}).Where("id in ?", deletedIds).Find(&olds).Error; err != nil {
+					return nil, err
+				}
+				for _, old := range olds {
+					changes.addReload("inbounds", clientInboundChanges(&old, &model.Client{})...)
+				}
+				if err = tx.Where("id in ?", deletedIds).Delete(model.Client{}).Error; err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	// 使用了变化的 TLS 的入站和服务需要重新加载
+	if len(changedTls) > 0 {
+		var inboundIds, serviceIds []uint
+		if err = tx.Model(model.Inbound{}).Where("tls_id in ?", changedTls).Pluck("id", &inboundIds).Error; err != nil {
+			return nil, err
+		}
+		if err = tx.Model(model.Service{}).Where("tls_id in ?", changedTls).Pluck("id", &serviceIds).Error; err != nil {
+			return nil, err
+		}
+		changes.addReload("inbounds", inboundIds...)
+		changes.addReload("services", serviceIds...)
+	}
+
+	// 系统配置 (config)，只有影响 core 的部分变化时才重启
+	if configJSON, ok := configData["config"]; ok {
+		configBytes, _ := json.Marshal(configJSON)
+		var oldConfig string
+		if err = tx.Model(&model.Setting{}).Where("key = ?", "config").Pluck("value", &oldConfig).Error; err != nil {
+			return nil, err
+		}
+		if err = tx.Model(&model.Setting{}).Where("key = ?", "config").Update("value", string(configBytes)).Error; err != nil {
+			return nil, err
+		}
+		changes.config = configNeedsRestart([]byte(oldConfig), configBytes)
+	}
+
+	err = tx.Commit().Error
+	if err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// diffTaggedRow 对比本地旧行，记录需要移除的旧 tag 和需要重新加载的行
+// 只有订阅相关字段 (如 addrs) 变化时不影响 core，无需重新加载
+func (s *SyncService) diffTaggedRow(tx *gorm.DB, table string, row syncTaggedRow, changes *syncChanges) error {
+	var olds []syncTaggedRow
+	err := tx.Table(table).Where("id = ?", row.Id).Scan(&olds).Error
+	if err != nil {
+		return err
+	}
+	if len(olds) == 0 {
+		changes.addReload(table, row.Id)
+		return nil
+	}
+	old := olds[0]
+	if old.Tag != row.Tag {
+		changes.removed[table] = append(changes.removed[table], old.Tag)
+	}
+	if old.Tag != row.Tag || old.Type != row.Type || old.TlsId != row.TlsId || !bytes.Equal(old.Options, row.Options) {
+		changes.addReload(table, row.Id)
+	}
+	return nil
+}
+
+// deleteTaggedRows 删除本地行，并记录需要从 core 移除的 tag
+func (s *SyncService) deleteTaggedRows(tx *gorm.DB, table string, value interface{}, ids []uint, changes *syncChanges) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	var tags []string
+	err := tx.Model(value).Where("id in ?", ids).Pluck("tag", &tags).Error
+	if err != nil {
+		return err
+	}
+	changes.removed[table] = append(changes.removed[table], tags...)
+	return tx.Where("id in ?", ids).Delete(value).Error
+}
+
+// clientInboundChanges 获取客户端变化后需要重新加载用户列表的入站
+func clientInboundChanges(old *model.Client, client *model.Client) []uint {
+	var oldInbounds, newInbounds []uint
+	if old.Enable {
+		json.Unmarshal(old.Inbounds, &oldInbounds)
+	}
+	if client.Enable {
+		json.Unmarshal(client.Inbounds, &newInbounds)
+	}
+
+	// 用户凭据变化时，新旧入站都需要重新加载
+	if old.Name != client.Name || !bytes.Equal(old.Config, client.Config) {
+		return append(oldInbounds, newInbounds...)
+	}
+
+	// 否则只重新加载增减了该用户的入站
+	oldSet := make(map[uint]bool, len(oldInbounds))
+	for _, id := range oldInbounds {
+		oldSet[id] = true
+	}
+	newSet := make(map[uint]bool, len(newInbounds))
+	for _, id := range newInbounds {
+		newSet[id] = true
+	}
+	var result []uint
+	for _, id := range oldInbounds {
+		if !newSet[id] {
+			result = append(result, id)
+		}
+	}
+	for _, id := range newInbounds {
+		if !oldSet[id] {
+			result = append(result, id)
+		}
+	}
+	return result
+}
+
+// coreConfigSections 系统配置中需要重启 core 才能生效的部分；
+// 入站、出站、端点和服务由数据库生成，配置中的同名字段不会被使用
+var coreConfigSections = []string{"log", "dns", "ntp", "route", "experimental"}
+
+// configNeedsRestart 比较新旧系统配置中影响 core 的部分 (忽略格式和字段顺序)，无法解析时按需要重启处理
+func configNeedsRestart(oldConfig, newConfig []byte) bool {
+	var oldSections, newSections map[string]json.RawMessage
+	if json.Unmarshal(oldConfig, &oldSections) != nil || json.Unmarshal(newConfig, &newSections) != nil {
+		return true
+	}
+	for _, section := range coreConfigSections {
+		oldValue, err := canonicalJSON(oldSections[section])
+		if err != nil {
+			return true
+		}
+		newValue, err := canonicalJSON(newSections[section])
+		if err != nil || !bytes.Equal(oldValue, newValue) {
+			return true
+		}
+	}
+	return false
+}
+
+// canonicalJSON 重新编码 JSON (对象按键排序)，缺失的字段与 null 相同
+func canonicalJSON(data json.RawMessage) ([]byte, error) {
+	if len(data) == 0 {
+		return []byte("null"), nil
+	}
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+	return json.Marshal(value)
+}
+
+// reloadConfigChanges 将增量变更热加载到 core，失败时退回重启 core
+func (s *SyncService) reloadConfigChanges(changes *syncChanges) {
+	if !corePtr.IsRunning() {
+		return
+	}
+
+	if changes.config {
+		if err := s.configService.RestartCore(); err != nil {
+			logger.Warning("Failed to restart core: ", err)
+		}
+		return
+	}
+
+	if err := s.hotReload(changes); err != nil {
+		logger.Warning("Hot reload failed, restarting core: ", err)
+		if err := s.configService.RestartCore(); err != nil {
+			logger.Warning("Failed to restart core: ", err)
+		}
+		return
+	}
+
+	if err := s.configService.UpdateUserLimits(); err != nil {
+		logger.Warning("Failed to update user limits: ", err)
+	}
+}
+
+// hotReload 通过 core 的增删接口只重新加载变化的部分
+func (s *SyncService) hotReload(changes *syncChanges) error {
+	var err error
+	db := database.GetDB()
+
+	// 被本地覆盖配置占用的 tag 保持本地配置，不受同步变更影响
+	overlayTags, err := s.configService.GetOverlayTags(db)
+	if err != nil {
+		return err
+	}
+
+	// 移除已删除或已改名的旧 tag
+	for _, tag := range changes.removed["services"] {
+		if err = corePtr.RemoveService(tag); err != nil && err != os.ErrInvalid {
+			return err
+		}
+	}
+	for _, tag := range changes.removed["inbounds"] {
+		if err = corePtr.RemoveInbound(tag); err != nil && err != os.ErrInvalid {
+			return err
+		}
+		corePtr.GetInstance().ConnTracker().CloseConnByInbound(tag)
+	}
+	for _, tag := range changes.removed["endpoints"] {
+		if overlayTags[tag] {
+			continue
+		}
+		if err = corePtr.RemoveEndpoint(tag); err != nil && err != os.ErrInvalid {
+			return err
+		}
+	}
+	for _, tag := range changes.removed["outbounds"] {
+		if overlayTags[tag] {
+			continue
+		}
+		if err = corePtr.RemoveOutbound(tag); err != nil && err != os.ErrInvalid {
+			return err
+		}
+	}
+
+	// 重新加载变化的出站和端点
+	if ids := mapKeys(changes.reload["outbounds"]); len(ids) > 0 {
+		var outbounds []model.Outbound
+		if err = db.Where("id in ?", ids).Find(&outbounds).Error; err != nil {
+			return err
+		}
+		for _, outbound := range outbounds {
+			if overlayTags[outbound.Tag] {
+				continue
+			}
+			if err = corePtr.RemoveOutbound(outbound.Tag); err != nil && err != os.ErrInvalid {
+				return err
+			}
+			configData, err := outbound.MarshalJSON()
+			if err != nil {
+				return err
+			}
+			if err = corePtr.AddOutbound(configData); err != nil {
+				return err
+			}
+		}
+	}
+	if ids := mapKeys(changes.reload["endpoints"]); len(ids) > 0 {
+		var endpoints []model.Endpoint
+		if err = db.Where("id in ?", ids).Find(&endpoints).Error; err != nil {
+			return err
+		}
+		for _, endpoint := range endpoints {
+			if overlayTags[endpoint.Tag] {
+				continue
+			}
+			if err = corePtr.RemoveEndpoint(endpoint.Tag); err != nil && err != os.ErrInvalid {
+				return err
+			}
+			configData, err := endpoint.MarshalJSON()
+			if err != nil {
+				return err
+			}
+			if err = corePtr.AddEndpoint(configData); err != nil {
+				return err
+			}
+		}
+	}
+
+	// 入站和服务使用已有的重启逻辑 (包含用户列表和 TLS)
+	if ids := mapKeys(changes.reload["inbounds"]); len(ids) > 0 {
+		if err = s.configService.RestartInbounds(db, ids); err != nil {
+			return err
+		}
+	}
+	if ids := mapKeys(changes.reload["services"]); len(ids) > 0 {
+		if err = s.configService.RestartServices(db, ids); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func mapKeys(m map[uint]bool) []uint {
+	keys := make([]uint, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
 // ========== 统计上报 ==========
 
 // statsReportLoop 统计上报循环
@@ -380,34 +1321,47 @@ func (s *SyncService) statsReportLoop() {
 	}
 }
 
+const (
+	// maxStatsBatchSize 每批上报的最大统计条数
+	maxStatsBatchSize = 5000
+	// maxStatsBatchesPerRound 每个上报周期最多上报的批次数，积压时分多个周期追赶
+	maxStatsBatchesPerRound = 10
+)
+
 // reportStats 上报流量统计
+// 统计数据由 SaveStats 持久化到 StatsOutbox，按批次顺序上报，主节点确认批次后才删除
 func (s *SyncService) reportStats() {
+	for i := 0; i < maxStatsBatchesPerRound; i++ {
+		sent, err := s.reportStatsBatch()
+		if err != nil {
+			logger.Warning("Failed to report stats: ", err)
+			return
+		}
+		if !sent {
+			return
+		}
+	}
+}
+
+// reportStatsBatch 上报一个批次，没有待上报数据时返回 false
+func (s *SyncService) reportStatsBatch() (bool, error) {
 	db := database.GetDB()
 
-	// 获取待上报的统计数据
-	var stats []model.Stats
-	// 获取最近一个周期的数据
-	threshold := time.Now().Unix() - int64(config.GetSyncStatsInterval()*2)
-	err := db.Where("date_time > ? AND node_id = ?", threshold, config.GetNodeId()).Find(&stats).Error
-	if err != nil {
-		logger.Warning("Failed to get stats: ", err)
-		return
+	batchId, err := s.nextStatsBatch(db)
+	if err != nil || batchId == "" {
+		return false, err
 	}
 
-	// 加上之前失败的数据
-	s.mutex.Lock()
-	stats = append(s.pendingStats, stats...)
-	s.pendingStats = nil
-	s.mutex.Unlock()
-
-	if len(stats) == 0 {
-		return
+	var rows []model.StatsOutbox
+	err = db.Where("batch_id = ?", batchId).Order("id").Find(&rows).Error
+	if err != nil {
+		return false, err
 	}
 
 	// 转换为上报格式
-	reqBody := make([]map[string]interface{}, len(stats))
-	for i, stat := range stats {
-		reqBody[i] = map[string]interface{}{
+	stats := make([]map[string]interface{}, len(rows))
+	for i, stat := range rows {
+		stats[i] = map[string]interface{}{
 			"dateTime":  stat.DateTime,
 			"resource":  stat.Resource,
 			"tag":       stat.Tag,
@@ -415,38 +1369,72 @@ func (s *SyncService) reportStats() {
 			"traffic":   stat.Traffic,
 		}
 	}
+	reqBody := map[string]interface{}{
+		"batchId": batchId,
+		"stats":   stats,
+	}
 
 	resp, err := s.doRequest("POST", "/node/stats", reqBody, true)
-	if err != nil || !resp.Success {
-		// 上报失败，保留数据待重试
-		s.mutex.Lock()
-		s.pendingStats = append(s.pendingStats, stats...)
-		// 限制待上报数据量，防止无限增长
-		if len(s.pendingStats) > 10000 {
-			s.pendingStats = s.pendingStats[len(s.pendingStats)-10000:]
-		}
-		s.mutex.Unlock()
-		if err != nil {
-			logger.Warning("Failed to report stats: ", err)
+	if err != nil {
+		return false, err
+	}
+	if !resp.Success {
+		return false, fmt.Errorf("report stats failed: %s", resp.Msg)
+	}
+
+	// 确认的批次 ID 必须一致 (旧版主节点不返回 obj，视为已确认)
+	if obj, ok := resp.Raw["obj"].(map[string]interface{}); ok {
+		if ackId, _ := obj["batchId"].(string); ackId != batchId {
+			return false, fmt.Errorf("stats batch %s not acknowledged", batchId)
 		}
-		return
 	}
 
-	// 上报成功，清除本地已上报数据
-	db.Where("date_time > ? AND node_id = ?", threshold, config.GetNodeId()).Delete(&model.Stats{})
+	// 主节点已确认，删除该批次
+	err = db.Where("batch_id = ?", batchId).Delete(&model.StatsOutbox{}).Error
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// nextStatsBatch 获取最早的未确认批次，没有时将最早的一批未分配数据标记为新批次
+// 批次按 id 范围划分，新写入的数据 id 更大，不会混入正在上报的批次
+func (s *SyncService) nextStatsBatch(db *gorm.DB) (string, error) {
+	var pending []model.StatsOutbox
+	err := db.Where("batch_id != ''").Order("id").Limit(1).Find(&pending).Error
+	if err != nil {
+		return "", err
+	}
+	if len(pending) > 0 {
+		return pending[0].BatchId, nil
+	}
+
+	var ids []uint64
+	err = db.Model(&model.StatsOutbox{}).Where("batch_id = ''").Order("id").Limit(maxStatsBatchSize).Pluck("id", &ids).Error
+	if err != nil || len(ids) == 0 {
+		return "", err
+	}
+
+	batchId := fmt.Sprintf("%d-%s", time.Now().Unix(), generateSecureToken(8))
+	err = db.Model(&model.StatsOutbox{}).
+		Where("batch_id = '' AND id <= ?", ids[len(ids)-1]).
+		Update("batch_id", batchId).Error
+	if err != nil {
+		return "", err
+	}
+	return batchId, nil
 }
 
 // reportOnlines 上报在线状态
+// 同时上报设备数超限的拒绝计数，并接收其他节点上的在线设备用于全集群设备数限制
 func (s *SyncService) reportOnlines() {
 	// 从 core 获取在线用户
 	if !corePtr.IsRunning() {
 		return
 	}
 
-	connections := corePtr.GetInstance().ConnTracker().GetConnections()
-	if len(connections) == 0 {
-		return
-	}
+	connTracker := corePtr.GetInstance().ConnTracker()
+	connections := connTracker.GetConnections()
 
 	onlines := make([]map[string]interface{}, 0)
 	seen := make(map[string]bool)
@@ -470,21 +1458,70 @@ func (s *SyncService) reportOnlines() {
 		})
 	}
 
-	if len(onlines) == 0 {
-		return
+	// 加上之前上报失败的拒绝计数
+	rejected := connTracker.GetAndResetRejected()
+	s.mutex.Lock()
+	for user, count := range s.pendingRejected {
+		rejected[user] += count
 	}
+	s.pendingRejected = nil
+	s.mutex.Unlock()
 
 	reqBody := map[string]interface{}{
-		"onlines": onlines,
+		"onlines":  onlines,
+		"rejected": rejected,
 	}
 
 	resp, err := s.doRequest("POST", "/node/onlines", reqBody, true)
-	if err != nil {
-		logger.Warning("Failed to report onlines: ", err)
+	if err != nil || !resp.Success {
+		// 上报失败，保留拒绝计数待重试
+		s.mutex.Lock()
+		s.pendingRejected = rejected
+		s.mutex.Unlock()
+		if err != nil {
+			logger.Warning("Failed to report onlines: ", err)
+		} else {
+			logger.Warning("Report onlines failed: ", resp.Msg)
+		}
 		return
 	}
-	if !resp.Success {
-		logger.Warning("Report onlines failed: ", resp.Msg)
+
+	s.applyRemoteDevices(resp.Raw["obj"])
+}
+
+// applyRemoteDevices 应用主节点下发的其他节点在线设备和设备数超限策略
+func (s *SyncService) applyRemoteDevices(obj interface{}) {
+	data, ok := obj.(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	devices := make(map[string][]string)
+	if devicesData, ok := data["devices"].(map[string]interface{}); ok {
+		for user, ipsData := range devicesData {
+			ips, _ := ipsData.([]interface{})
+			for _, ip := range ips {
+				if ipStr, ok := ip.(string); ok {
+					devices[user] = append(devices[user], ipStr)
+				}
+			}
+		}
+	}
+	corePtr.GetInstance().ConnTracker().SetRemoteDevices(devices)
+
+	// 策略以主节点设置为准
+	if policy, ok := data["policy"].(string); ok && policy != "" {
+		var settingService SettingService
+		localPolicy, _ := settingService.GetDeviceLimitPolicy()
+		if localPolicy != policy {
+			if err := settingService.SetDeviceLimitPolicy(policy); err != nil {
+				logger.Warning("Failed to save device limit policy: ", err)
+				return
+			}
+			if err := s.configService.UpdateUserLimits(); err != nil {
+				logger.Warning("Failed to update user limits: ", err)
+			}
+		}
 	}
 }
 
@@ -528,6 +1565,21 @@ func (s *SyncService) sendHeartbeat() {
 		connections = len(corePtr.GetInstance().ConnTracker().GetConnections())
 	}
 
+	// 配置推送通道状态
+	configStream := "disconnected"
+	if s.isStreamConnected() {
+		configStream = "connected"
+	}
+
+	// 本地覆盖配置与同步配置的 tag 冲突
+	conflicts, err := s.configService.GetOverlayConflicts()
+	if err != nil {
+		logger.Warning("Failed to check overlay conflicts: ", err)
+	}
+
+	// 自上次心跳以来的入站流量
+	upload, download := takeNodeTraffic()
+
 	reqBody := map[string]interface{}{
 		"cpu":          cpuPercent,
 		"memory":       memPercent,
@@ -535,16 +1587,57 @@ func (s *SyncService) sendHeartbeat() {
 		"version":      config.GetVersion(),
 		"externalHost": config.GetExternalHost(),
 		"externalPort": config.GetExternalPort(),
+		"configStream": configStream,
+		"conflicts":    conflicts,
+		"upload":       upload,
+		"download":     download,
+		"configError":  s.getConfigError(),
+		"configSerial": s.getConfigSerial(),
+		// 支持通过心跳接收远程命令
+		"commands": true,
 	}
 
 	resp, err := s.doRequest("POST", "/node/heartbeat", reqBody, true)
 	if err != nil {
+		// 上报失败的流量计入下一次心跳
+		addNodeTraffic(upload, download)
 		logger.Debug("Heartbeat failed: ", err)
 		return
 	}
 
 	if !resp.Success {
+		addNodeTraffic(upload, download)
 		logger.Debug("Heartbeat rejected: ", resp.Msg)
+		return
+	}
+
+	// 主节点下发节点密钥 (用注册 token 认证时)
+	if secret, ok := resp.Raw["secret"].(string); ok && secret != "" {
+		if err := s.setNodeSecret(secret); err != nil {
+			logger.Warning("Failed to save node secret: ", err)
+		} else {
+			logger.Info("Node secret updated by master")
+		}
+	}
+	// 主节点轮换了节点密钥 (用当前密钥加密)
+	if sealed, ok := resp.Raw["nextSecret"].(string); ok && sealed != "" {
+		s.acceptNextSecret(sealed)
+	}
+
+	// 申请首个客户端证书或在即将过期时续签
+	if err := s.ensureClientCert(false); err != nil {
+		logger.Warning("Failed to renew node client certificate: ", err)
+	}
+
+	// 排空模式
+	s.applyDrain(resp.Raw["drain"])
+	// 流量预算限制
+	s.applyBudget(resp.Raw["budget"])
+
+	// 重试之前回报失败的命令结果，再执行新下发的命令
+	s.flushCommandResults()
+	if commands, ok := resp.Raw["commands"]; ok {
+		s.runCommands(commands)
 	}
 }
 
@@ -556,37 +1649,70 @@ type APIResponse struct {
 	Msg     string                 `json:"msg"`
 	Obj     interface{}            `json:"obj"`
 	Raw     map[string]interface{} `json:"-"`
+	// 原始响应体 (校验配置签名使用)
+	body []byte
 }
 
 // doRequest 发送请求到主节点
 func (s *SyncService) doRequest(method, path string, body interface{}, auth bool) (*APIResponse, error) {
+	return s.doRequestWithClient(context.Background(), s.httpClient(), method, path, body, auth)
+}
+
+// errNotModified 主节点返回 304 (请求带 If-None-Match 且内容未变化)
+var errNotModified = errors.New("not modified")
+
+// errNodeUnauthorized 主节点返回 401 (节点凭据无效)
+var errNodeUnauthorized = errors.New("node unauthorized")
+
+// doRequestWithClient 使用指定的 HTTP 客户端发送请求到主节点
+func (s *SyncService) doRequestWithClient(ctx context.Context, client *http.Client, method, path string, body interface{}, auth bool) (*APIResponse, error) {
+	return s.doRequestWithHeaders(ctx, client, method, path, body, auth, nil)
+}
+
+// doRequestWithHeaders 发送带额外请求头的请求到主节点，响应 304 时返回 errNotModified
+// 响应由 http.Transport 自动协商 gzip 压缩并解压
+func (s *SyncService) doRequestWithHeaders(ctx context.Context, client *http.Client, method, path string, body interface{}, auth bool, headers map[string]string) (*APIResponse, error) {
 	// 正确拼接 URL: masterAddr + masterPath + path
 	masterAddr := strings.TrimSuffix(s.masterAddr, "/")
 	masterPath := strings.Trim(config.GetMasterPath(), "/")
 	path = strings.TrimPrefix(path, "/")
 	url := masterAddr + "/" + masterPath + "/" + path
 
+	var jsonBody []byte
 	var reqBody io.Reader
 	if body != nil {
-		jsonBody, err := json.Marshal(body)
+		var err error
+		jsonBody, err = json.Marshal(body)
 		if err != nil {
 			return nil, err
 		}
 		reqBody = bytes.NewBuffer(jsonBody)
 	}
 
-	req, err := http.NewRequest(method, url, reqBody)
+	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
 	if err != nil {
 		return nil, err
 	}
 
 	req.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	var secret string
 	if auth {
 		req.Header.Set("X-Node-Id", s.nodeId)
-		req.Header.Set("X-Node-Token", s.nodeToken)
+		if secret = s.getNodeSecret(); secret != "" {
+			timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+			nonce := generateSecureToken(16)
+			req.Header.Set("X-Node-Timestamp", timestamp)
+			req.Header.Set("X-Node-Nonce", nonce)
+			req.Header.Set("X-Node-Signature", NodeRequestSignature(secret, method, req.URL.RequestURI(), jsonBody, timestamp, nonce))
+		} else {
+			req.Header.Set("X-Node-Token", s.nodeToken)
+		}
 	}
 
-	resp, err := s.client.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -597,6 +1723,18 @@ func (s *SyncService) doRequest(method, path string, body interface{}, auth bool
 		return nil, err
 	}
 
+	if resp.StatusCode == http.StatusUnauthorized {
+		if secret != "" {
+			s.restorePrevSecret(secret)
+		}
+		return nil, fmt.Errorf("%w: %s", errNodeUnauthorized, string(respBody))
+	}
+	if secret != "" {
+		s.confirmSecret(secret)
+	}
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, errNotModified
+	}
 	if resp.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("request failed with status %d: %s", resp.StatusCode, string(respBody))
 	}
@@ -608,6 +1746,7 @@ func (s *SyncService) doRequest(method, path string, body interface{}, auth bool
 
 	// 保存原始响应用于解析复杂字段
 	json.Unmarshal(respBody, &result.Raw)
+	result.body = respBody
 
 	return &result, nil
 }
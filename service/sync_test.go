@@ -0,0 +1,39 @@
+package service
+
+import "testing"
+
+func TestConfigNeedsRestart(t *testing.T) {
+	const base = `{"log":{"level":"info"},"dns":{"servers":[],"rules":[]},"route":{"rules":[{"action":"sniff"}]},"experimental":{}}`
+
+	tests := []struct {
+		name      string
+		oldConfig string
+		newConfig string
+		want      bool
+	}{
+		{"unchanged", base, base, false},
+		{"formatting and key order", base, `{
+  "experimental": {},
+  "route": {"rules": [{"action": "sniff"}]},
+  "dns": {"rules": [], "servers": []},
+  "log": {"level": "info"}
+}`, false},
+		{"unused inbounds field", base, `{"log":{"level":"info"},"dns":{"servers":[],"rules":[]},"route":{"rules":[{"action":"sniff"}]},"experimental":{},"inbounds":[{"type":"direct"}]}`, false},
+		{"missing section same as null", `{"log":{"level":"info"}}`, `{"log":{"level":"info"},"ntp":null}`, false},
+		{"log level", base, `{"log":{"level":"debug"},"dns":{"servers":[],"rules":[]},"route":{"rules":[{"action":"sniff"}]},"experimental":{}}`, true},
+		{"dns server added", base, `{"log":{"level":"info"},"dns":{"servers":[{"tag":"local","type":"local"}],"rules":[]},"route":{"rules":[{"action":"sniff"}]},"experimental":{}}`, true},
+		{"route rule order", `{"route":{"rules":[{"action":"sniff"},{"action":"hijack-dns"}]}}`, `{"route":{"rules":[{"action":"hijack-dns"},{"action":"sniff"}]}}`, true},
+		{"ntp added", base, `{"log":{"level":"info"},"dns":{"servers":[],"rules":[]},"ntp":{"enabled":true},"route":{"rules":[{"action":"sniff"}]},"experimental":{}}`, true},
+		{"experimental changed", base, `{"log":{"level":"info"},"dns":{"servers":[],"rules":[]},"route":{"rules":[{"action":"sniff"}]},"experimental":{"cache_file":{"enabled":true}}}`, true},
+		{"empty section added", `{"log":{"level":"info"}}`, `{"log":{"level":"info"},"experimental":{}}`, true},
+		{"old config missing", "", base, true},
+		{"invalid new config", base, `{"log":`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := configNeedsRestart([]byte(tt.oldConfig), []byte(tt.newConfig)); got != tt.want {
+				t.Fatalf("configNeedsRestart() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
@@ -22,6 +22,14 @@ const (
 	EventTimeReset       = "time_reset"
 	EventUserExpired     = "user_expired"
 	EventUserDisabled    = "user_disabled"
+
+	// 节点流量预算
+	EventNodeBudgetWarning  = "node_budget_warning"
+	EventNodeBudgetExceeded = "node_budget_exceeded"
+	EventNodeBudgetReset    = "node_budget_reset"
+
+	// 订阅疑似被共享
+	EventSubShareSuspected = "sub_share_suspected"
 )
 
 // WebhookPayload Webhook 请求体
@@ -50,40 +50,14 @@ type JsonService struct {
 	LinkService
 }
 
-func (j *JsonService) GetJson(subId string, format string) (*string, []string, error) {
+func (j *JsonService) GetJson(client *model.Client, format string) (*string, []string, error) {
 	var jsonConfig map[string]interface{}
 
-	client, inDatas, err := j.getData(subId)
+	outbounds, outTags, err := j.getClientOutbounds(client)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	outbounds, outTags, err := j.getOutbounds(client.Config, inDatas)
-	if err != nil {
-		return nil, nil, err
-	}
-
-	// 主节点模式：为每个从节点生成代理
-	if config.IsMaster() {
-		outbounds, outTags, err = j.expandForNodes(outbounds, outTags)
-		if err != nil {
-			return nil, nil, err
-		}
-	}
-
-	links := j.LinkService.GetLinks(&client.Links, "external", "")
-	tagNumEnable := 0
-	if len(links) > 1 {
-		tagNumEnable = 1
-	}
-	for index, link := range links {
-		json, tag, err := util.GetOutbound(link, (index+1)*tagNumEnable)
-		if err == nil && len(tag) > 0 {
-			*outbounds = append(*outbounds, *json)
-			*outTags = append(*outTags, tag)
-		}
-	}
-
 	j.addDefaultOutbounds(outbounds, outTags)
 
 	err = json.Unmarshal([]byte(defaultJson), &jsonConfig)
@@ -105,29 +79,54 @@ func (j *JsonService) GetJson(subId string, format string) (*string, []string, e
 	return &resultStr, headers, nil
 }
 
-func (j *JsonService) getData(subId string) (*model.Client, []*model.Inbound, error) {
-	db := database.GetDB()
-	client := &model.Client{}
-	// 优先尝试 UUID 查询，如果失败则尝试 Name 查询 (向后兼容)
-	err := db.Model(model.Client{}).Where("enable = true and uuid = ?", subId).First(client).Error
+// getClientOutbounds 获取客户端订阅中的全部代理 (outbound)，各订阅格式共用
+// 主节点模式下为每个运行该入站的节点生成代理，并追加外部链接
+func (j *JsonService) getClientOutbounds(client *model.Client) (*[]map[string]interface{}, *[]string, error) {
+	inDatas, err := j.getData(client)
 	if err != nil {
-		// UUID 查询失败，尝试 Name 查询
-		err = db.Model(model.Client{}).Where("enable = true and name = ?", subId).First(client).Error
-		if err != nil {
-			return nil, nil, err
+		return nil, nil, err
+	}
+
+	var outbounds *[]map[string]interface{}
+	var outTags *[]string
+	if config.IsMaster() {
+		// 主节点模式：为每个运行该入站的节点生成代理
+		outbounds, outTags, err = j.expandForNodes(client, inDatas)
+	} else {
+		outbounds, outTags, err = j.getOutbounds(client.Config, inDatas)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	links := j.LinkService.GetLinks(&client.Links, "external", "")
+	tagNumEnable := 0
+	if len(links) > 1 {
+		tagNumEnable = 1
+	}
+	for index, link := range links {
+		json, tag, err := util.GetOutbound(link, (index+1)*tagNumEnable)
+		if err == nil && len(tag) > 0 {
+			*outbounds = append(*outbounds, *json)
+			*outTags = append(*outTags, tag)
 		}
 	}
+	return outbounds, outTags, nil
+}
+
+func (j *JsonService) getData(client *model.Client) ([]*model.Inbound, error) {
+	db := database.GetDB()
 	var clientInbounds []uint
-	err = json.Unmarshal(client.Inbounds, &clientInbounds)
+	err := json.Unmarshal(client.Inbounds, &clientInbounds)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 	var inbounds []*model.Inbound
 	err = db.Model(model.Inbound{}).Preload("Tls").Where("id in ?", clientInbounds).Find(&inbounds).Error
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
-	return client, inbounds, nil
+	return inbounds, nil
 }
 
 func (j *JsonService) getOutbounds(clientConfig json.RawMessage, inbounds []*model.Inbound) (*[]map[string]interface{}, *[]string, error) {
@@ -340,39 +339,64 @@ func (j *JsonService) pushMixed(outbounds *[]map[string]interface{}, outTags *[]
 	*outTags = append(*outTags, socksTag, httpTag)
 }
 
-// expandForNodes 在主节点模式下，为每个在线从节点复制代理配置
-func (j *JsonService) expandForNodes(outbounds *[]map[string]interface{}, outTags *[]string) (*[]map[string]interface{}, *[]string, error) {
-	nodes, err := j.NodeService.GetEnabledOnlineNodes()
-	if err != nil || len(nodes) == 0 {
-		// 没有从节点，返回空
-		return &[]map[string]interface{}{}, &[]string{}, nil
+// expandForNodes 在主节点模式下，为每个运行该入站且客户端有权使用的在线从节点复制代理配置
+// 明确分配给主节点的入站保留原配置
+func (j *JsonService) expandForNodes(client *model.Client, inbounds []*model.Inbound) (*[]map[string]interface{}, *[]string, error) {
+	newOutbounds := []map[string]interface{}{}
+	newTags := []string{}
+
+	// 每个入站生成的代理配置
+	inboundOutbounds := make([][]map[string]interface{}, len(inbounds))
+	for i, inbound := range inbounds {
+		outbounds, outTags, err := j.getOutbounds(client.Config, []*model.Inbound{inbound})
+		if err != nil {
+			return nil, nil, err
+		}
+		inboundOutbounds[i] = *outbounds
+		if service.ServedByMaster(inbound.Nodes) {
+			newOutbounds = append(newOutbounds, *outbounds...)
+			newTags = append(newTags, *outTags...)
+		}
 	}
 
-	var newOutbounds []map[string]interface{}
-	var newTags []string
+	nodes, limit, err := j.NodeService.GetClientNodes(client)
+	if err != nil {
+		return nil, nil, err
+	}
 
+	// 节点已按排序，每个入站只复制到前 limit 个运行它的节点
+	nodeCounts := make([]int, len(inbounds))
 	for _, node := range nodes {
 		if node.ExternalHost == "" {
 			continue
 		}
-		for _, ob := range *outbounds {
-			// 复制 outbound
-			newOb := make(map[string]interface{})
-			for k, v := range ob {
-				newOb[k] = v
+		for i, inbound := range inbounds {
+			if !service.InNodeScope(inbound.Nodes, node.NodeId, node.Group) {
+				continue
 			}
-			// 替换服务器地址
-			newOb["server"] = node.ExternalHost
-			if node.ExternalPort > 0 {
-				newOb["server_port"] = node.ExternalPort
+			if limit > 0 && nodeCounts[i] >= limit {
+				continue
 			}
-			// 更新 tag，添加节点名称
-			oldTag, _ := ob["tag"].(string)
-			newTag := fmt.Sprintf("%s-%s", node.Name, oldTag)
-			newOb["tag"] = newTag
+			nodeCounts[i]++
+			for _, ob := range inboundOutbounds[i] {
+				// 复制 outbound
+				newOb := make(map[string]interface{})
+				for k, v := range ob {
+					newOb[k] = v
+				}
+				// 替换服务器地址
+				newOb["server"] = node.ExternalHost
+				if node.ExternalPort > 0 {
+					newOb["server_port"] = node.ExternalPort
+				}
+				// 更新 tag，添加节点名称
+				oldTag, _ := ob["tag"].(string)
+				newTag := fmt.Sprintf("%s-%s", node.Name, oldTag)
+				newOb["tag"] = newTag
 
-			newOutbounds = append(newOutbounds, newOb)
-			newTags = append(newTags, newTag)
+				newOutbounds = append(newOutbounds, newOb)
+				newTags = append(newTags, newTag)
+			}
 		}
 	}
 
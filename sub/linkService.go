@@ -1,13 +1,11 @@
 package sub
 
 import (
-	"crypto/tls"
 	"encoding/json"
-	"io"
-	"net/http"
 	"strings"
 
 	"github.com/alireza0/s-ui/logger"
+	"github.com/alireza0/s-ui/service"
 	"github.com/alireza0/s-ui/util"
 )
 
@@ -21,8 +19,17 @@ type LinkService struct {
 }
 
 func (s *LinkService) GetLinks(linkJson *json.RawMessage, types string, clientInfo string) []string {
-	links := []Link{}
 	var result []string
+	for _, link := range s.GetLinkItems(linkJson, types, clientInfo) {
+		result = append(result, link.Uri)
+	}
+	return result
+}
+
+// GetLinkItems 获取链接并保留来源，本地链接的 Remark 为对应入站的 tag
+func (s *LinkService) GetLinkItems(linkJson *json.RawMessage, types string, clientInfo string) []Link {
+	links := []Link{}
+	var result []Link
 	err := json.Unmarshal(*linkJson, &links)
 	if err != nil {
 		return nil
@@ -30,12 +37,15 @@ func (s *LinkService) GetLinks(linkJson *json.RawMessage, types string, clientIn
 	for _, link := range links {
 		switch link.Type {
 		case "external":
-			result = append(result, link.Uri)
+			result = append(result, link)
 		case "sub":
-			result = append(result, s.getExternalSub(link.Uri)...)
+			for _, uri := range s.getExternalSub(link.Uri) {
+				result = append(result, Link{Type: link.Type, Remark: link.Remark, Uri: uri})
+			}
 		case "local":
 			if types == "all" {
-				result = append(result, s.addClientInfo(link.Uri, clientInfo))
+				link.Uri = s.addClientInfo(link.Uri, clientInfo)
+				result = append(result, link)
 			}
 		}
 	}
@@ -78,30 +88,8 @@ func (s *LinkService) addClientInfo(uri string, clientInfo string) string {
 	}
 }
 
+// getExternalSub 从缓存获取外部订阅中的链接，缓存在后台按 TTL 刷新
 func (s *LinkService) getExternalSub(url string) []string {
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-	}
-
-	client := &http.Client{Transport: tr}
-
-	// Make the HTTP request
-	response, err := client.Get(url)
-	if err != nil {
-		logger.Warning("sub: Error making HTTP request:", err)
-		return nil
-	}
-	defer response.Body.Close()
-
-	// Read the response body
-	body, err := io.ReadAll(response.Body)
-	if err != nil {
-		logger.Warning("sub: Error reading response body:", err)
-		return nil
-	}
-
-	// Convert if the content is Base64 encoded
-	links := util.StrOrBase64Encoded(string(body))
-	return strings.Split(links, "\n")
-
+	var externalSubService service.ExternalSubService
+	return externalSubService.GetExternalLinks(url)
 }
@@ -0,0 +1,201 @@
+package sub
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/alireza0/s-ui/database/model"
+	"github.com/alireza0/s-ui/service"
+	"github.com/alireza0/s-ui/util"
+)
+
+type LoonService struct {
+	service.SettingService
+	JsonService
+}
+
+const loonGeneral = `[General]
+ip-mode = dual
+dns-server = system
+skip-proxy = 127.0.0.1, 192.168.0.0/16, 10.0.0.0/8, 172.16.0.0/12, 100.64.0.0/10, localhost, *.local
+internet-test-url = http://www.gstatic.com/generate_204
+proxy-test-url = http://www.gstatic.com/generate_204
+`
+
+const loonRules = `[Rule]
+FINAL,Proxy
+`
+
+// GetLoon 生成 Loon 配置
+func (s *LoonService) GetLoon(client *model.Client) (*string, []string, error) {
+	outbounds, _, err := s.JsonService.getClientOutbounds(client)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var proxies []*proxyOutbound
+	var lines []string
+	for _, obMap := range *outbounds {
+		p, ok := parseProxyOutbound(obMap)
+		if !ok {
+			continue
+		}
+		if line, ok := loonProxy(p); ok {
+			proxies = append(proxies, p)
+			lines = append(lines, line)
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString(loonGeneral)
+	b.WriteString("\n[Proxy]\n")
+	for _, line := range lines {
+		b.WriteString(line + "\n")
+	}
+	b.WriteString("\n[Proxy Group]\n")
+	if len(proxies) > 0 {
+		tags := strings.Join(proxyTags(proxies), ",")
+		b.WriteString("Proxy = select,Auto," + tags + "\n")
+		b.WriteString("Auto = url," + tags + ",url = http://www.gstatic.com/generate_204,interval = 300,tolerance = 50\n")
+	} else {
+		b.WriteString("Proxy = select,DIRECT\n")
+	}
+	b.WriteString("\n" + loonRules)
+	result := b.String()
+
+	updateInterval, _ := s.SettingService.GetSubUpdates()
+	headers := util.GetHeaders(client, updateInterval)
+
+	return &result, headers, nil
+}
+
+// loonProxy 生成 Loon [Proxy] 中的一行
+// Loon 不支持 Hysteria (v1)、TUIC 和 AnyTLS，传输层不支持 gRPC 和 HTTPUpgrade，Reality 只用于 VLESS，无法表达的组合跳过
+func loonProxy(p *proxyOutbound) (string, bool) {
+	if p.Reality && p.Type != "vless" {
+		return "", false
+	}
+	transport := "tcp"
+	switch p.Transport {
+	case "":
+	case "ws", "http":
+		// 只有 VMess、VLESS 和 Trojan 可以设置传输层 (Trojan 不支持 HTTP)
+		if p.Type != "vmess" && p.Type != "vless" && !(p.Type == "trojan" && p.Transport == "ws") {
+			return "", false
+		}
+		transport = p.Transport
+	default:
+		return "", false
+	}
+
+	var fields []string
+	switch p.Type {
+	case "shadowsocks":
+		// Loon 的 shadowsocks 不支持外层 TLS
+		if p.TLS {
+			return "", false
+		}
+		fields = []string{"Shadowsocks", p.Server, fmt.Sprint(p.Port), p.Method, loonQuote(p.Password)}
+		if p.udpRelay() {
+			fields = append(fields, "udp=true")
+		}
+	case "vmess":
+		fields = []string{"vmess", p.Server, fmt.Sprint(p.Port), "auto", loonQuote(p.UUID)}
+		fields = append(fields, loonTransport(p, transport)...)
+		fields = append(fields, fmt.Sprintf("alterId=%d", p.AlterId))
+	case "vless":
+		fields = []string{"VLESS", p.Server, fmt.Sprint(p.Port), loonQuote(p.UUID)}
+		fields = append(fields, loonTransport(p, transport)...)
+		if p.Flow != "" {
+			fields = append(fields, "flow="+p.Flow)
+		}
+		if p.Reality {
+			fields = append(fields, "public-key="+loonQuote(p.PublicKey))
+			if p.ShortId != "" {
+				fields = append(fields, "short-id="+p.ShortId)
+			}
+		}
+	case "trojan":
+		// Loon 的 trojan 始终使用 TLS
+		if !p.TLS {
+			return "", false
+		}
+		fields = []string{"trojan", p.Server, fmt.Sprint(p.Port), loonQuote(p.Password)}
+		if transport == "ws" {
+			fields = append(fields, loonTransport(p, transport)...)
+		} else {
+			fields = append(fields, loonTLS(p)...)
+		}
+	case "hysteria2":
+		// Loon 只支持 salamander 混淆
+		if p.Obfs != "" && p.Obfs != "salamander" {
+			return "", false
+		}
+		fields = []string{"Hysteria2", p.Server, fmt.Sprint(p.Port), loonQuote(p.Password)}
+		fields = append(fields, loonTLS(p)...)
+		if p.Obfs != "" {
+			fields = append(fields, "salamander-password="+loonQuote(p.ObfsPassword))
+		}
+		if p.DownMbps > 0 {
+			fields = append(fields, fmt.Sprintf("download-bandwidth=%d", p.DownMbps))
+		}
+		fields = append(fields, "udp=true")
+	case "socks", "http":
+		kind := "socks5"
+		if p.Type == "http" {
+			kind = "http"
+			if p.TLS {
+				kind = "https"
+			}
+		}
+		fields = []string{kind, p.Server, fmt.Sprint(p.Port)}
+		if p.Username != "" {
+			fields = append(fields, p.Username, loonQuote(p.Password))
+		}
+		if p.TLS {
+			if p.Type == "socks" {
+				fields = append(fields, "over-tls=true")
+			}
+			fields = append(fields, loonTLS(p)...)
+		}
+	default:
+		return "", false
+	}
+
+	return p.Tag + " = " + strings.Join(fields, ","), true
+}
+
+// loonTransport VMess / VLESS / Trojan 的传输层和 TLS 参数
+func loonTransport(p *proxyOutbound, transport string) []string {
+	fields := []string{"transport=" + transport}
+	if transport != "tcp" {
+		if p.Path != "" {
+			fields = append(fields, "path="+p.Path)
+		}
+		if p.Host != "" {
+			fields = append(fields, "host="+p.Host)
+		}
+	}
+	if p.TLS && p.Type != "trojan" {
+		fields = append(fields, "over-tls=true")
+	}
+	if p.TLS {
+		fields = append(fields, loonTLS(p)...)
+	}
+	return fields
+}
+
+// loonTLS SNI 和证书校验参数
+func loonTLS(p *proxyOutbound) []string {
+	var fields []string
+	if p.SNI != "" {
+		fields = append(fields, "tls-name="+p.SNI)
+	}
+	fields = append(fields, fmt.Sprintf("skip-cert-verify=%t", p.Insecure))
+	return fields
+}
+
+// loonQuote 密码类参数统一加引号
+func loonQuote(value string) string {
+	return `"` + value + `"`
+}
@@ -0,0 +1,167 @@
+package sub
+
+import (
+	"strings"
+)
+
+// proxyOutbound 从 sing-box outbound 中提取的代理参数，供 Surge / Quantumult X / Loon / Shadowrocket 渲染使用
+type proxyOutbound struct {
+	Tag      string
+	Type     string
+	Server   string
+	Port     int
+	UUID     string
+	Password string
+	Username string
+	Method   string
+	Flow     string
+	AlterId  int
+	Network  string
+	// TLS
+	TLS         bool
+	SNI         string
+	Insecure    bool
+	ALPN        []string
+	Fingerprint string
+	Reality     bool
+	PublicKey   string
+	ShortId     string
+	// 传输层 (为空表示 TCP)
+	Transport   string
+	Path        string
+	Host        string
+	ServiceName string
+	// hysteria / hysteria2 / tuic
+	UpMbps            int
+	DownMbps          int
+	AuthStr           string
+	Obfs              string
+	ObfsPassword      string
+	CongestionControl string
+}
+
+// proxyNameReplacer 代理名称中会破坏 Surge / Loon / Quantumult X 配置行的字符
+var proxyNameReplacer = strings.NewReplacer(",", " ", "=", " ", "\r", " ", "\n", " ")
+
+// parseProxyOutbound 解析 getClientOutbounds 生成的代理，分组和直连返回 false
+func parseProxyOutbound(obMap map[string]interface{}) (*proxyOutbound, bool) {
+	t, _ := obMap["type"].(string)
+	if t == "" || t == "selector" || t == "urltest" || t == "direct" {
+		return nil, false
+	}
+
+	p := &proxyOutbound{Type: t}
+	tag, _ := obMap["tag"].(string)
+	p.Tag = strings.TrimSpace(proxyNameReplacer.Replace(tag))
+	p.Server, _ = obMap["server"].(string)
+	p.Port = toInt(obMap["server_port"])
+	p.UUID, _ = obMap["uuid"].(string)
+	p.Password, _ = obMap["password"].(string)
+	p.Username, _ = obMap["username"].(string)
+	p.Method, _ = obMap["method"].(string)
+	p.Flow, _ = obMap["flow"].(string)
+	p.AlterId = toInt(obMap["alter_id"])
+	p.Network, _ = obMap["network"].(string)
+	p.UpMbps = toInt(obMap["up_mbps"])
+	p.DownMbps = toInt(obMap["down_mbps"])
+	p.AuthStr, _ = obMap["auth_str"].(string)
+	p.CongestionControl, _ = obMap["congestion_control"].(string)
+
+	// hysteria 的 obfs 为字符串，hysteria2 为 {type, password}
+	switch obfs := obMap["obfs"].(type) {
+	case string:
+		p.Obfs = obfs
+	case map[string]interface{}:
+		p.Obfs, _ = obfs["type"].(string)
+		p.ObfsPassword, _ = obfs["password"].(string)
+	}
+
+	if tls, ok := obMap["tls"].(map[string]interface{}); ok {
+		enabled, _ := tls["enabled"].(bool)
+		p.TLS = enabled
+		p.SNI, _ = tls["server_name"].(string)
+		p.Insecure, _ = tls["insecure"].(bool)
+		if alpn, ok := tls["alpn"].([]interface{}); ok {
+			for _, a := range alpn {
+				if s, ok := a.(string); ok {
+					p.ALPN = append(p.ALPN, s)
+				}
+			}
+		}
+		if utls, ok := tls["utls"].(map[string]interface{}); ok {
+			if enabled, _ := utls["enabled"].(bool); enabled {
+				p.Fingerprint, _ = utls["fingerprint"].(string)
+			}
+		}
+		if reality, ok := tls["reality"].(map[string]interface{}); ok {
+			p.Reality, _ = reality["enabled"].(bool)
+			p.PublicKey, _ = reality["public_key"].(string)
+			p.ShortId, _ = reality["short_id"].(string)
+		}
+	}
+
+	if transport, ok := obMap["transport"].(map[string]interface{}); ok {
+		p.Transport, _ = transport["type"].(string)
+		p.Path = firstString(transport["path"])
+		p.Host = firstString(transport["host"])
+		if headers, ok := transport["headers"].(map[string]interface{}); ok && p.Host == "" {
+			p.Host = firstString(headers["Host"])
+		}
+		p.ServiceName, _ = transport["service_name"].(string)
+	}
+
+	return p, true
+}
+
+// udpRelay shadowsocks 是否开启 UDP (network 未限制为 tcp)
+func (p *proxyOutbound) udpRelay() bool {
+	return p.Network != "tcp"
+}
+
+// tlsHost TLS 使用的 SNI，未设置时使用服务器地址
+func (p *proxyOutbound) tlsHost() string {
+	if p.SNI != "" {
+		return p.SNI
+	}
+	return p.Server
+}
+
+// toInt 兼容 JSON 解析得到的 float64 和代码中直接赋值的 int
+func toInt(value interface{}) int {
+	switch v := value.(type) {
+	case int:
+		return v
+	case int64:
+		return int(v)
+	case float64:
+		return int(v)
+	}
+	return 0
+}
+
+// firstString 字符串或字符串数组的第一个值
+func firstString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []interface{}:
+		if len(v) > 0 {
+			s, _ := v[0].(string)
+			return s
+		}
+	case []string:
+		if len(v) > 0 {
+			return v[0]
+		}
+	}
+	return ""
+}
+
+// proxyTags 已渲染代理的名称
+func proxyTags(proxies []*proxyOutbound) []string {
+	tags := make([]string, len(proxies))
+	for i, p := range proxies {
+		tags[i] = p.Tag
+	}
+	return tags
+}
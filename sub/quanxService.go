@@ -0,0 +1,157 @@
+package sub
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/alireza0/s-ui/database/model"
+	"github.com/alireza0/s-ui/service"
+	"github.com/alireza0/s-ui/util"
+)
+
+// QuanXService 生成 Quantumult X 节点列表 (server_remote 资源)
+type QuanXService struct {
+	service.SettingService
+	JsonService
+}
+
+// GetQuanX 生成 Quantumult X 节点列表，每行一个节点
+func (s *QuanXService) GetQuanX(client *model.Client) (*string, []string, error) {
+	outbounds, _, err := s.JsonService.getClientOutbounds(client)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var lines []string
+	for _, obMap := range *outbounds {
+		p, ok := parseProxyOutbound(obMap)
+		if !ok {
+			continue
+		}
+		if line, ok := quanxProxy(p); ok {
+			lines = append(lines, line)
+		}
+	}
+	result := strings.Join(lines, "\n")
+
+	updateInterval, _ := s.SettingService.GetSubUpdates()
+	headers := util.GetHeaders(client, updateInterval)
+
+	return &result, headers, nil
+}
+
+// quanxProxy 生成 Quantumult X 的一行节点
+// Quantumult X 不支持 Hysteria、Hysteria2、TUIC 和 AnyTLS，传输层只支持 WebSocket，
+// VMess 只支持 AEAD (alterId 为 0)，Reality 只用于 VLESS，无法表达的组合跳过
+func quanxProxy(p *proxyOutbound) (string, bool) {
+	if p.Reality && p.Type != "vless" {
+		return "", false
+	}
+	if p.Transport != "" && p.Transport != "ws" {
+		return "", false
+	}
+	// 参数值不能加引号，包含逗号的凭据无法表达
+	if strings.Contains(p.Password, ",") || strings.Contains(p.Username, ",") {
+		return "", false
+	}
+
+	address := fmt.Sprintf("%s:%d", p.Server, p.Port)
+	var fields []string
+	switch p.Type {
+	case "shadowsocks":
+		// Quantumult X 的 shadowsocks 不支持外层 TLS 和 WebSocket (v2ray-plugin 除外)
+		if p.TLS || p.Transport != "" {
+			return "", false
+		}
+		fields = []string{"shadowsocks=" + address, "method=" + p.Method, "password=" + p.Password}
+		fields = append(fields, fmt.Sprintf("udp-relay=%t", p.udpRelay()))
+	case "vmess":
+		if p.AlterId != 0 {
+			return "", false
+		}
+		fields = []string{"vmess=" + address, "method=chacha20-ietf-poly1305", "password=" + p.UUID}
+		fields = append(fields, quanxObfs(p)...)
+	case "vless":
+		// Reality 不能与 WebSocket 组合
+		if p.Reality && p.Transport != "" {
+			return "", false
+		}
+		fields = []string{"vless=" + address, "method=none", "password=" + p.UUID}
+		fields = append(fields, quanxObfs(p)...)
+		if p.Reality {
+			fields = append(fields, "reality-base64-pubkey="+p.PublicKey)
+			if p.ShortId != "" {
+				fields = append(fields, "reality-hex-shortid="+p.ShortId)
+			}
+		}
+		if p.Flow != "" {
+			fields = append(fields, "vless-flow="+p.Flow)
+		}
+	case "trojan":
+		// Quantumult X 的 trojan 始终使用 TLS
+		if !p.TLS {
+			return "", false
+		}
+		fields = []string{"trojan=" + address, "password=" + p.Password}
+		if p.Transport == "ws" {
+			fields = append(fields, quanxObfs(p)...)
+		} else {
+			fields = append(fields, "over-tls=true", "tls-host="+p.tlsHost(), fmt.Sprintf("tls-verification=%t", !p.Insecure))
+		}
+	case "socks", "http":
+		if p.Transport != "" {
+			return "", false
+		}
+		kind := "http"
+		if p.Type == "socks" {
+			kind = "socks5"
+		}
+		fields = []string{kind + "=" + address}
+		if p.Username != "" {
+			fields = append(fields, "username="+p.Username, "password="+p.Password)
+		}
+		if p.TLS {
+			fields = append(fields, "over-tls=true", "tls-host="+p.tlsHost(), fmt.Sprintf("tls-verification=%t", !p.Insecure))
+		}
+	default:
+		return "", false
+	}
+
+	fields = append(fields, "tag="+p.Tag)
+	return strings.Join(fields, ", "), true
+}
+
+// quanxObfs VMess / VLESS / Trojan 的 obfs 参数：ws / wss 表示 WebSocket，over-tls 表示 TCP + TLS
+func quanxObfs(p *proxyOutbound) []string {
+	var fields []string
+	switch {
+	case p.Transport == "ws" && p.TLS:
+		fields = append(fields, "obfs=wss")
+	case p.Transport == "ws":
+		fields = append(fields, "obfs=ws")
+	case p.TLS:
+		fields = append(fields, "obfs=over-tls")
+	default:
+		return nil
+	}
+
+	host := p.Host
+	if host == "" || (p.TLS && p.Transport == "") {
+		host = p.tlsHost()
+	}
+	fields = append(fields, "obfs-host="+host)
+	if p.Transport == "ws" {
+		path := p.Path
+		if path == "" {
+			path = "/"
+		}
+		fields = append(fields, "obfs-uri="+path)
+	}
+	if p.TLS {
+		fields = append(fields, fmt.Sprintf("tls-verification=%t", !p.Insecure))
+		if p.Transport == "ws" && p.SNI != "" && p.SNI != host {
+			fields = append(fields, "tls-host="+p.SNI)
+		}
+	}
+	return fields
+}
@@ -0,0 +1,241 @@
+package sub
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/alireza0/s-ui/database/model"
+	"github.com/alireza0/s-ui/service"
+	"github.com/alireza0/s-ui/util"
+)
+
+// ShadowrocketService 生成 Shadowrocket 节点链接 (base64 编码)
+type ShadowrocketService struct {
+	service.SettingService
+	JsonService
+}
+
+// GetShadowrocket 生成 Shadowrocket 订阅
+func (s *ShadowrocketService) GetShadowrocket(client *model.Client) (*string, []string, error) {
+	outbounds, _, err := s.JsonService.getClientOutbounds(client)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var links []string
+	for _, obMap := range *outbounds {
+		p, ok := parseProxyOutbound(obMap)
+		if !ok {
+			continue
+		}
+		if link, ok := shadowrocketLink(p); ok {
+			links = append(links, link)
+		}
+	}
+	result := base64.StdEncoding.EncodeToString([]byte(strings.Join(links, "\n")))
+
+	updateInterval, _ := s.SettingService.GetSubUpdates()
+	headers := util.GetHeaders(client, updateInterval)
+
+	return &result, headers, nil
+}
+
+// shadowrocketLink 生成 Shadowrocket 可导入的节点链接
+// HTTP 代理的链接与订阅地址无法区分，不输出；传输层不支持 HTTPUpgrade 和 QUIC，无法表达的组合跳过
+func shadowrocketLink(p *proxyOutbound) (string, bool) {
+	switch p.Transport {
+	case "", "ws", "http", "grpc":
+	default:
+		return "", false
+	}
+	address := fmt.Sprintf("%s:%d", p.Server, p.Port)
+	fragment := "#" + url.PathEscape(p.Tag)
+
+	switch p.Type {
+	case "shadowsocks":
+		// shadowsocks 不支持外层 TLS 和传输层
+		if p.TLS || p.Transport != "" {
+			return "", false
+		}
+		userInfo := base64.RawURLEncoding.EncodeToString([]byte(p.Method + ":" + p.Password))
+		query := url.Values{}
+		if p.udpRelay() {
+			query.Set("udp", "1")
+		}
+		return "ss://" + userInfo + "@" + address + encodeQuery(query) + fragment, true
+	case "vmess":
+		// Shadowrocket 格式: base64(加密方式:UUID@地址:端口)，其他参数放在查询串
+		if p.Reality {
+			return "", false
+		}
+		userInfo := base64.RawURLEncoding.EncodeToString([]byte("auto:" + p.UUID + "@" + address))
+		query := url.Values{}
+		query.Set("remarks", p.Tag)
+		query.Set("alterId", fmt.Sprint(p.AlterId))
+		switch p.Transport {
+		case "ws":
+			query.Set("obfs", "websocket")
+		case "http":
+			query.Set("obfs", "http")
+		case "grpc":
+			query.Set("obfs", "grpc")
+		}
+		if p.Transport != "" {
+			query.Set("path", p.Path)
+			if p.Transport == "grpc" {
+				query.Set("path", p.ServiceName)
+			}
+			if p.Host != "" {
+				query.Set("obfsParam", p.Host)
+			}
+		}
+		if p.TLS {
+			query.Set("tls", "1")
+			query.Set("peer", p.tlsHost())
+			if p.Insecure {
+				query.Set("allowInsecure", "1")
+			}
+		}
+		return "vmess://" + userInfo + encodeQuery(query), true
+	case "vless", "trojan":
+		if p.Type == "trojan" && !p.TLS {
+			return "", false
+		}
+		secret := p.UUID
+		if p.Type == "trojan" {
+			secret = p.Password
+		}
+		query := standardQuery(p)
+		if p.Type == "vless" {
+			query.Set("encryption", "none")
+			if p.Flow != "" {
+				query.Set("flow", p.Flow)
+			}
+		}
+		return p.Type + "://" + url.PathEscape(secret) + "@" + address + encodeQuery(query) + fragment, true
+	case "hysteria2":
+		if p.Transport != "" {
+			return "", false
+		}
+		query := url.Values{}
+		query.Set("sni", p.tlsHost())
+		if p.Insecure {
+			query.Set("insecure", "1")
+		}
+		if p.Obfs != "" {
+			query.Set("obfs", p.Obfs)
+			query.Set("obfs-password", p.ObfsPassword)
+		}
+		return "hysteria2://" + url.PathEscape(p.Password) + "@" + address + encodeQuery(query) + fragment, true
+	case "hysteria":
+		if p.Transport != "" {
+			return "", false
+		}
+		query := url.Values{}
+		query.Set("peer", p.tlsHost())
+		query.Set("auth", p.AuthStr)
+		query.Set("upmbps", fmt.Sprint(p.UpMbps))
+		query.Set("downmbps", fmt.Sprint(p.DownMbps))
+		if p.Obfs != "" {
+			query.Set("obfs", "xplus")
+			query.Set("obfsParam", p.Obfs)
+		}
+		if len(p.ALPN) > 0 {
+			query.Set("alpn", strings.Join(p.ALPN, ","))
+		}
+		if p.Insecure {
+			query.Set("insecure", "1")
+		}
+		return "hysteria://" + address + encodeQuery(query) + fragment, true
+	case "tuic":
+		if p.Transport != "" {
+			return "", false
+		}
+		query := url.Values{}
+		query.Set("sni", p.tlsHost())
+		if len(p.ALPN) > 0 {
+			query.Set("alpn", strings.Join(p.ALPN, ","))
+		}
+		if p.CongestionControl != "" {
+			query.Set("congestion_control", p.CongestionControl)
+		}
+		if p.Insecure {
+			query.Set("allowInsecure", "1")
+		}
+		return "tuic://" + url.PathEscape(p.UUID) + ":" + url.PathEscape(p.Password) + "@" + address + encodeQuery(query) + fragment, true
+	case "anytls":
+		if p.Transport != "" {
+			return "", false
+		}
+		query := url.Values{}
+		query.Set("sni", p.tlsHost())
+		if p.Insecure {
+			query.Set("insecure", "1")
+		}
+		return "anytls://" + url.PathEscape(p.Password) + "@" + address + encodeQuery(query) + fragment, true
+	case "socks":
+		if p.TLS || p.Transport != "" {
+			return "", false
+		}
+		userInfo := base64.RawURLEncoding.EncodeToString([]byte(p.Username + ":" + p.Password))
+		return "socks://" + userInfo + "@" + address + fragment, true
+	}
+	return "", false
+}
+
+// standardQuery VLESS / Trojan 通用分享链接的 TLS 和传输层参数
+func standardQuery(p *proxyOutbound) url.Values {
+	query := url.Values{}
+	switch {
+	case p.Reality:
+		query.Set("security", "reality")
+		query.Set("pbk", p.PublicKey)
+		if p.ShortId != "" {
+			query.Set("sid", p.ShortId)
+		}
+	case p.TLS:
+		query.Set("security", "tls")
+	default:
+		query.Set("security", "none")
+	}
+	if p.TLS {
+		query.Set("sni", p.tlsHost())
+		if p.Fingerprint != "" {
+			query.Set("fp", p.Fingerprint)
+		}
+		if len(p.ALPN) > 0 {
+			query.Set("alpn", strings.Join(p.ALPN, ","))
+		}
+		if p.Insecure {
+			query.Set("allowInsecure", "1")
+		}
+	}
+
+	network := p.Transport
+	if network == "" {
+		network = "tcp"
+	}
+	query.Set("type", network)
+	switch network {
+	case "ws", "http":
+		if p.Path != "" {
+			query.Set("path", p.Path)
+		}
+		if p.Host != "" {
+			query.Set("host", p.Host)
+		}
+	case "grpc":
+		query.Set("serviceName", p.ServiceName)
+	}
+	return query
+}
+
+// encodeQuery 非空时带上 "?" 前缀
+func encodeQuery(query url.Values) string {
+	if len(query) == 0 {
+		return ""
+	}
+	return "?" + query.Encode()
+}
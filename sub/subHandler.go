@@ -12,6 +12,11 @@ type SubHandler struct {
 	SubService
 	JsonService
 	ClashService
+	SurgeService
+	LoonService
+	QuanXService
+	ShadowrocketService
+	service.SubAccessService
 }
 
 func NewSubHandler(g *gin.RouterGroup) {
@@ -28,13 +33,33 @@ func (s *SubHandler) subs(c *gin.Context) {
 	var result *string
 	var err error
 	subId := c.Param("subid")
+	// 签名令牌、UUID 或名称 (向后兼容)，解析一次供生成订阅和记录访问共用
+	client, err := s.SubService.ClientService.GetSubClient(subId)
+	if err != nil {
+		logger.Error(err)
+		c.String(400, "Error!")
+		return
+	}
+	// 明确指定的 format 优先，否则按 User-Agent 识别客户端
 	format, isFormat := c.GetQuery("format")
-	if isFormat {
+	if !isFormat {
+		c.Writer.Header().Set("Vary", "User-Agent")
+		format = s.SettingService.DetectSubFormat(c.GetHeader("User-Agent"))
+	}
+	if format != service.SubFormatLinks {
 		switch format {
 		case "json":
-			result, headers, err = s.JsonService.GetJson(subId, format)
+			result, headers, err = s.JsonService.GetJson(client, format)
 		case "clash":
-			result, headers, err = s.ClashService.GetClash(subId)
+			result, headers, err = s.ClashService.GetClash(client)
+		case "surge":
+			result, headers, err = s.SurgeService.GetSurge(client)
+		case "loon":
+			result, headers, err = s.LoonService.GetLoon(client)
+		case "quanx":
+			result, headers, err = s.QuanXService.GetQuanX(client)
+		case "shadowrocket":
+			result, headers, err = s.ShadowrocketService.GetShadowrocket(client)
 		}
 		if err != nil || result == nil {
 			logger.Error(err)
@@ -42,13 +67,16 @@ func (s *SubHandler) subs(c *gin.Context) {
 			return
 		}
 	} else {
-		result, headers, err = s.SubService.GetSubs(subId)
+		result, headers, err = s.SubService.GetSubs(client)
 		if err != nil || result == nil {
 			logger.Error(err)
 			c.String(400, "Error!")
 			return
 		}
 	}
+	// 记录订阅获取
+	s.SubAccessService.RecordSubAccess(client, c.ClientIP(), c.GetHeader("User-Agent"), format)
+
 	// Add headers
 	c.Writer.Header().Set("Subscription-Userinfo", headers[0])
 	c.Writer.Header().Set("Profile-Update-Interval", headers[1])
@@ -9,7 +9,6 @@ import (
 	"time"
 
 	"github.com/alireza0/s-ui/config"
-	"github.com/alireza0/s-ui/database"
 	"github.com/alireza0/s-ui/database/model"
 	"github.com/alireza0/s-ui/service"
 	"github.com/alireza0/s-ui/util"
@@ -18,38 +17,28 @@ import (
 type SubService struct {
 	service.SettingService
 	service.NodeService
+	service.ClientService
 	LinkService
 }
 
-func (s *SubService) GetSubs(subId string) (*string, []string, error) {
+func (s *SubService) GetSubs(client *model.Client) (*string, []string, error) {
 	var err error
 
-	db := database.GetDB()
-	client := &model.Client{}
-	// 优先尝试 UUID 查询，如果失败则尝试 Name 查询 (向后兼容)
-	err = db.Model(model.Client{}).Where("enable = true and uuid = ?", subId).First(client).Error
-	if err != nil {
-		// UUID 查询失败，尝试 Name 查询
-		err = db.Model(model.Client{}).Where("enable = true and name = ?", subId).First(client).Error
-		if err != nil {
-			return nil, nil, err
-		}
-	}
-
 	clientInfo := ""
 	subShowInfo, _ := s.SettingService.GetSubShowInfo()
 	if subShowInfo {
 		clientInfo = s.getClientInfo(client)
 	}
 
-	linksArray := s.LinkService.GetLinks(&client.Links, "all", clientInfo)
-
-	// 主节点模式：为每个从节点复制链接
+	var linksArray []string
 	if config.IsMaster() {
-		linksArray, err = s.expandLinksForNodes(linksArray)
+		// 主节点模式：为每个运行该入站的节点生成链接
+		linksArray, err = s.expandLinksForNodes(client, s.LinkService.GetLinkItems(&client.Links, "all", clientInfo))
 		if err != nil {
 			return nil, nil, err
 		}
+	} else {
+		linksArray = s.LinkService.GetLinks(&client.Links, "all", clientInfo)
 	}
 
 	result := strings.Join(linksArray, "\n")
@@ -98,23 +87,46 @@ func (s *SubService) formatTraffic(trafficBytes int64) string {
 	}
 }
 
-// expandLinksForNodes 在主节点模式下，为每个在线从节点复制链接
-func (s *SubService) expandLinksForNodes(links []string) ([]string, error) {
-	nodes, err := s.NodeService.GetEnabledOnlineNodes()
-	if err != nil || len(nodes) == 0 {
-		// 没有从节点，返回空
-		return []string{}, nil
+// expandLinksForNodes 在主节点模式下，为每个运行该入站且客户端有权使用的在线从节点复制链接
+// 明确分配给主节点的入站保留原链接；外部链接和外部订阅不属于任何入站，按所有节点处理
+func (s *SubService) expandLinksForNodes(client *model.Client, links []Link) ([]string, error) {
+	scopes, err := s.NodeService.GetInboundNodeScopes()
+	if err != nil {
+		return nil, err
 	}
 
-	var result []string
+	result := []string{}
+	for _, link := range links {
+		if link.Type == "local" && service.ServedByMaster(scopes[link.Remark]) {
+			result = append(result, link.Uri)
+		}
+	}
+
+	nodes, limit, err := s.NodeService.GetClientNodes(client)
+	if err != nil {
+		return nil, err
+	}
+	// 节点已按排序，每个入站 (按 Remark 区分) 只下发到前 limit 个运行它的节点
+	nodeCounts := make(map[string]int)
 	for _, node := range nodes {
 		if node.ExternalHost == "" {
 			continue
 		}
+		counted := make(map[string]bool)
 		for _, link := range links {
-			newLink := s.replaceHostInLink(link, node.ExternalHost, node.ExternalPort, node.Name)
+			if link.Type == "local" && !service.InNodeScope(scopes[link.Remark], node.NodeId, node.Group) {
+				continue
+			}
+			if limit > 0 && !counted[link.Remark] && nodeCounts[link.Remark] >= limit {
+				continue
+			}
+			newLink := s.replaceHostInLink(link.Uri, node.ExternalHost, node.ExternalPort, node.Name)
 			if newLink != "" {
 				result = append(result, newLink)
+				if !counted[link.Remark] {
+					counted[link.Remark] = true
+					nodeCounts[link.Remark]++
+				}
 			}
 		}
 	}
@@ -0,0 +1,175 @@
+package sub
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/alireza0/s-ui/database/model"
+	"github.com/alireza0/s-ui/service"
+	"github.com/alireza0/s-ui/util"
+)
+
+type SurgeService struct {
+	service.SettingService
+	JsonService
+}
+
+const surgeGeneral = `[General]
+loglevel = notify
+dns-server = system
+skip-proxy = 127.0.0.1, 192.168.0.0/16, 10.0.0.0/8, 172.16.0.0/12, 100.64.0.0/10, localhost, *.local
+internet-test-url = http://www.gstatic.com/generate_204
+proxy-test-url = http://www.gstatic.com/generate_204
+`
+
+const surgeRules = `[Rule]
+RULE-SET,LAN,DIRECT
+FINAL,Proxy,dns-failed
+`
+
+// GetSurge 生成 Surge 配置
+func (s *SurgeService) GetSurge(client *model.Client) (*string, []string, error) {
+	outbounds, _, err := s.JsonService.getClientOutbounds(client)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var proxies []*proxyOutbound
+	var lines []string
+	for _, obMap := range *outbounds {
+		p, ok := parseProxyOutbound(obMap)
+		if !ok {
+			continue
+		}
+		if line, ok := surgeProxy(p); ok {
+			proxies = append(proxies, p)
+			lines = append(lines, line)
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString(surgeGeneral)
+	b.WriteString("\n[Proxy]\n")
+	for _, line := range lines {
+		b.WriteString(line + "\n")
+	}
+	tags := strings.Join(append([]string{"Auto"}, proxyTags(proxies)...), ", ")
+	b.WriteString("\n[Proxy Group]\n")
+	b.WriteString("Proxy = select, " + tags + "\n")
+	if len(proxies) > 0 {
+		b.WriteString("Auto = url-test, " + strings.Join(proxyTags(proxies), ", ") + ", url=http://www.gstatic.com/generate_204, interval=300, tolerance=50\n")
+	} else {
+		b.WriteString("Auto = select, DIRECT\n")
+	}
+	b.WriteString("\n" + surgeRules)
+	result := b.String()
+
+	updateInterval, _ := s.SettingService.GetSubUpdates()
+	headers := util.GetHeaders(client, updateInterval)
+
+	return &result, headers, nil
+}
+
+// surgeProxy 生成 Surge [Proxy] 中的一行
+// Surge 不支持 VLESS、Hysteria (v1)、AnyTLS 和 Reality，传输层只支持 WebSocket，无法表达的组合跳过
+func surgeProxy(p *proxyOutbound) (string, bool) {
+	if p.Reality {
+		return "", false
+	}
+	switch p.Transport {
+	case "":
+	case "ws":
+		// 只有 VMess 和 Trojan 可以使用 WebSocket
+		if p.Type != "vmess" && p.Type != "trojan" {
+			return "", false
+		}
+	default:
+		// gRPC、HTTP、HTTPUpgrade、QUIC 传输层
+		return "", false
+	}
+
+	kind := p.Type
+	var params []string
+	switch p.Type {
+	case "shadowsocks":
+		// Surge 的 shadowsocks 不支持外层 TLS
+		if p.TLS {
+			return "", false
+		}
+		kind = "ss"
+		params = append(params, "encrypt-method="+p.Method, "password="+surgeQuote(p.Password))
+		if p.udpRelay() {
+			params = append(params, "udp-relay=true")
+		}
+	case "vmess":
+		params = append(params, "username="+p.UUID)
+		if p.AlterId == 0 {
+			params = append(params, "vmess-aead=true")
+		}
+	case "trojan":
+		// Surge 的 trojan 始终使用 TLS
+		if !p.TLS {
+			return "", false
+		}
+		params = append(params, "password="+surgeQuote(p.Password))
+	case "hysteria2":
+		// Surge 不支持 salamander 混淆
+		if p.Obfs != "" {
+			return "", false
+		}
+		params = append(params, "password="+surgeQuote(p.Password))
+		if p.DownMbps > 0 {
+			params = append(params, fmt.Sprintf("download-bandwidth=%d", p.DownMbps))
+		}
+	case "tuic":
+		kind = "tuic-v5"
+		params = append(params, "uuid="+p.UUID, "password="+surgeQuote(p.Password))
+		if len(p.ALPN) > 0 {
+			params = append(params, "alpn="+p.ALPN[0])
+		}
+	case "socks", "http":
+		if p.Type == "socks" {
+			kind = "socks5"
+		}
+		if p.TLS {
+			kind = map[string]string{"socks5": "socks5-tls", "http": "https"}[kind]
+		}
+		if p.Username != "" {
+			params = append(params, p.Username, surgeQuote(p.Password))
+		}
+	default:
+		return "", false
+	}
+
+	if p.Transport == "ws" {
+		params = append(params, "ws=true")
+		if p.Path != "" {
+			params = append(params, "ws-path="+p.Path)
+		}
+		if p.Host != "" {
+			params = append(params, "ws-headers=Host:"+surgeQuote(p.Host))
+		}
+	}
+	if p.TLS {
+		if p.Type == "vmess" {
+			params = append(params, "tls=true")
+		}
+		if p.SNI != "" {
+			params = append(params, "sni="+p.SNI)
+		}
+		if p.Insecure {
+			params = append(params, "skip-cert-verify=true")
+		}
+	}
+
+	fields := append([]string{kind, p.Server, fmt.Sprint(p.Port)}, params...)
+	return p.Tag + " = " + strings.Join(fields, ", "), true
+}
+
+// surgeQuote 为包含分隔符的值加引号
+func surgeQuote(value string) string {
+	if strings.ContainsAny(value, ",=") {
+		return `"` + value + `"`
+	}
+	return value
+}
@@ -34,6 +34,7 @@ type Server struct {
 	ctx            context.Context
 	cancel         context.CancelFunc
 	settingService service.SettingService
+	nodeService    service.NodeService
 }
 
 func NewServer() *Server {
@@ -191,6 +192,20 @@ func (s *Server) Start() (err error) {
 		c := &tls.Config{
 			Certificates: []tls.Certificate{cert},
 		}
+		// 主节点: 从节点以专用 SNI 连接时使用节点 CA 的证书并校验客户端证书 (mTLS)
+		if config.IsMaster() {
+			nodeConfig, err := s.nodeService.NodeTLSConfig()
+			if err != nil {
+				logger.Warning("node mTLS disabled: ", err)
+			} else {
+				c.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+					if hello.ServerName == service.NodeTLSServerName {
+						return nodeConfig, nil
+					}
+					return nil, nil
+				}
+			}
+		}
 		listener = network.NewAutoHttpsListener(listener)
 		listener = tls.NewListener(listener, c)
 	}